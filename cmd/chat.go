@@ -0,0 +1,334 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+    "bufio"
+    "bytes"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "image"
+    "math/rand/v2"
+    "os"
+    "os/user"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+
+    "github.com/Pranavjeet-Naidu/Mosquito/mqtt"
+    "github.com/Pranavjeet-Naidu/Mosquito/steg"
+    MQTT "github.com/eclipse/paho.mqtt.golang"
+    "github.com/spf13/cobra"
+)
+
+// chatContentType marks a chat line, which carries the name of its author inside
+// the encrypted payload
+const chatContentType = "application/vnd.mosquito.chat+json"
+
+// chatLine is the payload of a chat message
+type chatLine struct {
+    Name string `json:"name"`
+    Text string `json:"text"`
+}
+
+var (
+    chatBroker       string
+    chatTopic        string
+    chatCoverDir     string
+    chatName         string
+    chatPasswordFile string
+    chatOutputDir    string
+    chatChunkSize    int
+    chatPayload      payloadFlags
+    chatConn         brokerFlags
+)
+
+// chatCmd represents the chat command
+var chatCmd = &cobra.Command{
+    Use:   "chat",
+    Short: "Chat over MQTT with every line hidden in an image",
+    Long: `Chat with everyone on an MQTT topic who knows the password. Each line you type
+is encrypted, hidden in a cover picked at random from --cover-dir and published.
+Images from the others are extracted as they arrive and shown with the name of
+their author and the time they were sent.
+
+Images that cannot be decrypted with the password, or that carry no encrypted
+payload, are ignored, as are your own messages coming back from the broker.
+Type /quit or press Ctrl+D to leave.
+
+Example:
+  mosquito chat -b tcp://broker.example.com:1883 -t room/x --cover-dir ./covers -p secret
+  mosquito chat -b ssl://broker.example.com:8883 -t room/x --cover-dir ./covers --password-file ~/.chat-pass --name alice`,
+    RunE: func(cmd *cobra.Command, args []string) error {
+        if chatBroker == "" || chatTopic == "" || chatCoverDir == "" {
+            return usageError("broker URL, topic, and cover directory are required")
+        }
+        if chatPayload.password != "" && chatPasswordFile != "" {
+            return usageError("use only one of -p and --password-file")
+        }
+        if chatPasswordFile != "" {
+            password, err := readSecretFile(chatPasswordFile, "password")
+            if err != nil {
+                return err
+            }
+            chatPayload.password = password
+        }
+        if chatPayload.password == "" {
+            return usageError("a password (-p or --password-file) is required, it keeps out everyone else on the topic")
+        }
+        if _, err := steg.ParseCipherSuite(chatPayload.cipher); err != nil {
+            return usageError("%v", err)
+        }
+        if _, err := parseModeFlag(chatPayload.mode); err != nil {
+            return err
+        }
+        if err := chatPayload.checkKDFCost(); err != nil {
+            return err
+        }
+
+        chunkSize := chatChunkSize * 1024
+        if chunkSize < 0 || (chunkSize > 0 && chunkSize < mqtt.MinChunkSize) {
+            return usageError("--chunk-size must be 0 or at least %d KiB", mqtt.MinChunkSize/1024)
+        }
+        opts, err := mqttOptions(cmd, chatBroker, &chatConn)
+        if err != nil {
+            return err
+        }
+        // Own messages are recognised by the client ID in their envelope
+        clientID := chatConn.clientID
+        if clientID == "" {
+            clientID = mqtt.NewClientID("chat")
+            opts = append(opts, mqtt.WithClientID(clientID))
+        }
+        msgOpts := []mqtt.Option{mqtt.WithQoS(byte(chatConn.qos)), mqtt.WithChunkSize(chunkSize)}
+
+        if chatName == "" {
+            chatName = defaultChatName()
+        }
+
+        covers, err := loadChatCovers(chatCoverDir)
+        if err != nil {
+            return err
+        }
+
+        if chatOutputDir != "" {
+            if err := os.MkdirAll(chatOutputDir, 0755); err != nil {
+                return failWith(exitIO, "creating output directory", err)
+            }
+        }
+
+        c := &chat{clientID: clientID, password: chatPayload.password, kdfCost: chatPayload.kdfCost, sent: map[string]bool{}}
+        opts = append(opts, mqtt.WithImageHandler(c.receive), mqtt.WithConnectionHandler(func(e mqtt.ConnectionEvent) {
+            // In line with the chat rather than on stderr
+            if notice := connectionNotice(chatBroker, e); notice != "" {
+                c.printf("[%s] %s\n", time.Now().Format(time.TimeOnly), notice)
+            }
+        }))
+        client, err := mqtt.SubscribeForImages(chatBroker, chatTopic, chatOutputDir, opts...)
+        if err != nil {
+            return failWith(exitIO, "subscribing", err)
+        }
+        defer client.Disconnect(250)
+
+        c.printf("Joined %s on %s as %s, with %d covers\n", chatTopic, chatBroker, chatName, len(covers))
+        c.printf("Type a message and press Enter. /quit or Ctrl+D leaves.\n")
+
+        // Lines are read in the background so Ctrl+C is noticed while waiting
+        lines := make(chan string)
+        go func() {
+            defer close(lines)
+            scanner := bufio.NewScanner(os.Stdin)
+            for scanner.Scan() {
+                lines <- scanner.Text()
+            }
+        }()
+
+        for {
+            select {
+            case <-cmd.Context().Done():
+                c.printf("\nLeaving %s\n", chatTopic)
+                return nil
+            case line, ok := <-lines:
+                if !ok || strings.TrimSpace(line) == "/quit" {
+                    c.printf("Leaving %s\n", chatTopic)
+                    return nil
+                }
+                if strings.TrimSpace(line) == "" {
+                    continue
+                }
+                if err := c.send(cmd, client, covers, line, msgOpts); err != nil {
+                    c.printf("Could not send: %v\n", err)
+                }
+            }
+        }
+    },
+}
+
+// chat holds the state shared by the sending loop and the receive handler
+type chat struct {
+    clientID string
+    password string
+    kdfCost  int
+
+    mu   sync.Mutex
+    sent map[string]bool // IDs of our own messages, to skip their echoes
+}
+
+func (c *chat) printf(format string, args ...any) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    fmt.Fprintf(out, format, args...)
+}
+
+// send hides a line in a random cover that can hold it and publishes the image
+func (c *chat) send(cmd *cobra.Command, client MQTT.Client, covers []image.Image, text string, msgOpts []mqtt.Option) error {
+    payload, err := json.Marshal(chatLine{Name: chatName, Text: text})
+    if err != nil {
+        return err
+    }
+    protected, err := chatPayload.protect(payload, chatContentType, &hideReport{})
+    if err != nil {
+        return err
+    }
+    defer protected.wipe()
+
+    // Covers are only measured until one fits, so the password is stretched once
+    var cover image.Image
+    for _, i := range rand.Perm(len(covers)) {
+        err = protected.fits(covers[i])
+        if !errors.Is(err, steg.ErrImageTooSmall) {
+            cover = covers[i]
+            break
+        }
+    }
+    if err != nil {
+        return err
+    }
+    encoded, err := steg.NewEncoder(cover, protected.opts...).EncodeBytesContext(cmd.Context(), protected.data)
+    if err != nil {
+        return err
+    }
+
+    var buf bytes.Buffer
+    if err := steg.WriteImage(&buf, encoded, "png"); err != nil {
+        return err
+    }
+    meta := mqtt.Metadata{ID: mqtt.NewMessageID(), Sender: c.clientID, Timestamp: time.Now().UTC()}
+    sealed, err := mqtt.Seal(meta, buf.Bytes())
+    if err != nil {
+        return err
+    }
+
+    c.mu.Lock()
+    c.sent[meta.ID] = true
+    c.mu.Unlock()
+    return mqtt.Publish(client, chatTopic, sealed, msgOpts...)
+}
+
+// receive shows an incoming chat image. Anything that is not ours and does not
+// open with the password is dropped without a word.
+func (c *chat) receive(r mqtt.Received) {
+    c.mu.Lock()
+    own := r.Metadata != nil && (r.Metadata.Sender == c.clientID || c.sent[r.Metadata.ID])
+    c.mu.Unlock()
+    if own {
+        return
+    }
+
+    img, err := steg.ReadImage(bytes.NewReader(r.Data))
+    if err != nil {
+        return
+    }
+    payload, err := steg.NewDecoder(img, steg.WithPassword(c.password), steg.WithKDFCost(c.kdfCost)).Decode()
+    if err != nil || !(payload.Header.IsEncrypted() || payload.Header.IsStealth()) {
+        return
+    }
+    defer steg.Wipe(payload.Data)
+
+    at := time.Now()
+    name := "unknown"
+    if r.Metadata != nil {
+        at = r.Metadata.Timestamp
+        if r.Metadata.Sender != "" {
+            name = r.Metadata.Sender
+        }
+    }
+
+    var text string
+    switch {
+    case payload.ContentType == chatContentType:
+        var line chatLine
+        if err := json.Unmarshal(payload.Data, &line); err != nil {
+            return
+        }
+        name, text = line.Name, line.Text
+    case payload.ContentType == "" || steg.IsTextType(payload.ContentType):
+        text = string(payload.Data)
+    default:
+        text = fmt.Sprintf("(sent %s, not shown)", payload.ContentType)
+    }
+    c.printf("[%s] %s: %s\n", at.Local().Format(time.TimeOnly), name, text)
+}
+
+// loadChatCovers decodes every cover in dir up front, so sending a line does not
+// wait for the disk
+func loadChatCovers(dir string) ([]image.Image, error) {
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        return nil, failWith(exitIO, "reading cover directory", err)
+    }
+
+    var covers []image.Image
+    for _, e := range entries {
+        name := e.Name()
+        if !e.Type().IsRegular() || strings.HasPrefix(name, ".") || !coverExtensions[strings.ToLower(filepath.Ext(name))] {
+            continue
+        }
+        path := filepath.Join(dir, name)
+        img, err := steg.LoadImage(path)
+        if err != nil {
+            return nil, failWith(exitIO, "loading image", fmt.Errorf("%s: %w", path, err))
+        }
+        covers = append(covers, img)
+    }
+    if len(covers) == 0 {
+        return nil, usageError("no cover images found in %s", dir)
+    }
+    return covers, nil
+}
+
+// defaultChatName is the login name, for --name
+func defaultChatName() string {
+    if u, err := user.Current(); err == nil && u.Username != "" {
+        return u.Username
+    }
+    return "anonymous"
+}
+
+func init() {
+    rootCmd.AddCommand(chatCmd)
+
+    // Add flags
+    chatCmd.Flags().StringVarP(&chatBroker, "broker", "b", "", "MQTT broker URL (required)")
+    chatCmd.Flags().StringVarP(&chatTopic, "topic", "t", "", "MQTT topic of the chat room (required)")
+    chatCmd.Flags().StringVar(&chatCoverDir, "cover-dir", "", "Directory of cover images to hide messages in (required)")
+    chatCmd.Flags().StringVar(&chatName, "name", "", "Name shown to the others (default: your login name)")
+    chatCmd.Flags().StringVarP(&chatPayload.password, "password", "p", "", "Password shared by everyone in the chat")
+    chatCmd.Flags().StringVar(&chatPasswordFile, "password-file", "", "File holding the chat password")
+    chatCmd.Flags().BoolVar(&chatPayload.stealth, "stealth", false, "Encrypt the header too so no plaintext marker is left")
+    chatCmd.Flags().StringVar(&chatPayload.cipher, "cipher", "aes-gcm", "Cipher suite for encryption (aes-gcm, chacha20, xchacha20, aes-gcm-siv)")
+    addKDFCostFlag(chatCmd, &chatPayload)
+    chatCmd.Flags().StringVarP(&chatPayload.mode, "mode", "M", "0", modeFlagUsage())
+    chatCmd.Flags().StringVarP(&chatOutputDir, "output", "o", "", "Also save every received image to this directory")
+    chatCmd.Flags().IntVar(&chatChunkSize, "chunk-size", 0, "Split images larger than this many KiB into chunks, to fit the broker's packet limit (0, the default, sends them whole so older receivers can read them)")
+    addBrokerFlags(chatCmd, &chatConn)
+
+    // Mark required flags
+    chatCmd.MarkFlagRequired("broker")
+    chatCmd.MarkFlagRequired("topic")
+    chatCmd.MarkFlagRequired("cover-dir")
+}
@@ -0,0 +1,413 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+
+    "github.com/spf13/cobra"
+    "github.com/spf13/pflag"
+    "gopkg.in/yaml.v3"
+)
+
+// Where flag defaults are read from, see "Configuration" in usage.md
+const (
+    configEnvPrefix   = "MOSQUITO_"
+    configEnvFile     = configEnvPrefix + "CONFIG"
+    projectConfigFile = ".mosquito.yaml"
+)
+
+// noConfigFlags are never read from a config file or the environment
+var noConfigFlags = map[string]bool{
+    "help":   true,
+    "config": true,
+}
+
+// secretFlags hold credentials, which 'config show' masks
+var secretFlags = map[string]bool{
+    "password":        true,
+    "hmac-key":        true,
+    "broker-password": true,
+    "receipt-key":     true,
+}
+
+var configPath string
+
+// configFile is a loaded config file. Top-level keys set the flag of that name on
+// every command; a mapping named after a command holds settings for that command
+// and its subcommands only.
+type configFile struct {
+    path   string
+    values map[string]any
+}
+
+// config holds the files in use, most important first
+var config []*configFile
+
+// configSetting is a flag value found in the environment or a config file
+type configSetting struct {
+    values []string
+    source string
+}
+
+// userConfigPath returns ~/.config/mosquito/config.yaml, or the equivalent on
+// the platform
+func userConfigPath() (string, error) {
+    base, err := os.UserConfigDir()
+    if err != nil {
+        return "", err
+    }
+    return filepath.Join(base, "mosquito", "config.yaml"), nil
+}
+
+// loadConfig reads the config files. --config or MOSQUITO_CONFIG names the only
+// file to use; otherwise .mosquito.yaml in the current directory overrides the
+// user file, and neither has to exist.
+func loadConfig(root *cobra.Command) error {
+    config = nil
+
+    path := configPath
+    if path == "" {
+        path = os.Getenv(configEnvFile)
+    }
+    if path != "" {
+        return addConfigFile(root, path)
+    }
+
+    paths := []string{projectConfigFile}
+    if user, err := userConfigPath(); err == nil {
+        paths = append(paths, user)
+    }
+    for _, path := range paths {
+        if err := addConfigFile(root, path); err != nil && !errors.Is(err, fs.ErrNotExist) {
+            return err
+        }
+    }
+    return nil
+}
+
+// addConfigFile reads a config file and checks every key against the commands
+// and their flags, so a misspelt setting is reported instead of silently ignored
+func addConfigFile(root *cobra.Command, path string) error {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return failWith(exitIO, "reading config", err)
+    }
+    f := &configFile{path: path}
+    if err := yaml.Unmarshal(data, &f.values); err != nil {
+        return usageError("%s: %v", path, err)
+    }
+    if err := checkConfigSection(root, f.values); err != nil {
+        return usageError("%s: %v", path, err)
+    }
+    config = append(config, f)
+    return nil
+}
+
+func checkConfigSection(cmd *cobra.Command, values map[string]any) error {
+    for key, value := range values {
+        if section, ok := value.(map[string]any); ok {
+            child := subcommand(cmd, key)
+            if child == nil {
+                return fmt.Errorf("unknown command %q", key)
+            }
+            if err := checkConfigSection(child, section); err != nil {
+                return fmt.Errorf("%s: %v", key, err)
+            }
+            continue
+        }
+        if noConfigFlags[key] || !hasFlag(cmd, key) {
+            return fmt.Errorf("unknown setting %q", key)
+        }
+    }
+    return nil
+}
+
+// subcommand returns the direct subcommand of cmd with the given name
+func subcommand(cmd *cobra.Command, name string) *cobra.Command {
+    for _, child := range cmd.Commands() {
+        if child.Name() == name {
+            return child
+        }
+    }
+    return nil
+}
+
+// hasFlag reports whether cmd or any of its subcommands has the named flag
+func hasFlag(cmd *cobra.Command, name string) bool {
+    if cmd.Flags().Lookup(name) != nil || cmd.PersistentFlags().Lookup(name) != nil ||
+        cmd.InheritedFlags().Lookup(name) != nil {
+        return true
+    }
+    for _, child := range cmd.Commands() {
+        if hasFlag(child, name) {
+            return true
+        }
+    }
+    return false
+}
+
+// commandPath returns the names of cmd and its parents below the root
+func commandPath(cmd *cobra.Command) []string {
+    var path []string
+    for c := cmd; c.HasParent(); c = c.Parent() {
+        path = append([]string{c.Name()}, path...)
+    }
+    return path
+}
+
+// envName returns the environment variable for a flag given the command path it
+// applies to, e.g. MOSQUITO_MQTTSEND_TOPIC or MOSQUITO_BROKER
+func envName(path []string, flag string) string {
+    name := strings.Join(append(append([]string{}, path...), flag), "_")
+    return configEnvPrefix + envWord(name)
+}
+
+func envWord(s string) string {
+    return strings.ToUpper(strings.ReplaceAll(s, "-", "_"))
+}
+
+// lookupSetting finds the value of a flag of cmd that was not given on the
+// command line. The environment comes before the config files; within each, a
+// setting for the command beats one for its parent and the top-level one.
+func lookupSetting(cmd *cobra.Command, flag string) (configSetting, bool) {
+    path := commandPath(cmd)
+
+    for n := len(path); n >= 0; n-- {
+        name := envName(path[:n], flag)
+        if value, ok := os.LookupEnv(name); ok {
+            return configSetting{values: []string{value}, source: name}, true
+        }
+    }
+
+    for _, f := range config {
+        sections := []map[string]any{f.values}
+        for _, name := range path {
+            section, ok := sections[len(sections)-1][name].(map[string]any)
+            if !ok {
+                break
+            }
+            sections = append(sections, section)
+        }
+        for i := len(sections) - 1; i >= 0; i-- {
+            value, ok := sections[i][flag]
+            if !ok || value == nil {
+                continue
+            }
+            if _, isSection := value.(map[string]any); isSection {
+                continue
+            }
+            return configSetting{values: settingValues(value), source: f.path}, true
+        }
+    }
+    return configSetting{}, false
+}
+
+// settingValues turns a YAML value into flag values; a list sets a slice flag
+// one element at a time
+func settingValues(value any) []string {
+    if list, ok := value.([]any); ok {
+        values := make([]string, 0, len(list))
+        for _, v := range list {
+            values = append(values, fmt.Sprint(v))
+        }
+        return values
+    }
+    return []string{fmt.Sprint(value)}
+}
+
+// applyConfig loads the config files and fills in every flag of cmd that was not
+// given on the command line. It runs before each command.
+func applyConfig(cmd *cobra.Command) error {
+    if err := loadConfig(cmd.Root()); err != nil {
+        return err
+    }
+
+    var err error
+    cmd.Flags().VisitAll(func(f *pflag.Flag) {
+        if err != nil || f.Changed || noConfigFlags[f.Name] {
+            return
+        }
+        setting, ok := lookupSetting(cmd, f.Name)
+        if !ok {
+            return
+        }
+        for _, value := range setting.values {
+            if setErr := cmd.Flags().Set(f.Name, value); setErr != nil {
+                err = usageError("%s: invalid value %q for %s: %v", setting.source, value, f.Name, setErr)
+                return
+            }
+        }
+    })
+    return err
+}
+
+// configEntry is one line of 'config show'
+type configEntry struct {
+    Name   string `json:"name"`
+    Value  string `json:"value"`
+    Source string `json:"source"` // flag, default, an environment variable or a config file
+}
+
+// configReport is the JSON form of 'config show'
+type configReport struct {
+    reportMeta
+    Files    []string      `json:"files"`
+    For      string        `json:"for,omitempty"`
+    Settings []configEntry `json:"settings"`
+}
+
+// maskSetting hides the value of credentials
+func maskSetting(flag, value string) string {
+    if secretFlags[flag] && value != "" {
+        return "********"
+    }
+    return value
+}
+
+// configCmd represents the config command
+var configCmd = &cobra.Command{
+    Use:   "config",
+    Short: "Inspect the configuration",
+    Long: `Inspect the configuration read from config files and MOSQUITO_* environment
+variables. Any flag can be given a default there, see 'mosquito config show'.`,
+}
+
+// configShowCmd prints the effective configuration
+var configShowCmd = &cobra.Command{
+    Use:   "show [command]",
+    Short: "Print the effective configuration",
+    Long: `Print the settings read from config files and MOSQUITO_* environment variables.
+
+With a command, print the value every flag of that command will have when it is
+not given on the command line, and where that value comes from.
+
+Example:
+  mosquito config show
+  mosquito config show mqttSend
+  mosquito config show session init --output-format json`,
+    RunE: func(cmd *cobra.Command, args []string) error {
+        report := configReport{reportMeta: newReportMeta(cmd), Files: []string{}, Settings: []configEntry{}}
+        for _, f := range config {
+            report.Files = append(report.Files, f.path)
+        }
+
+        if len(args) > 0 {
+            target, rest, err := cmd.Root().Find(args)
+            if err != nil || len(rest) > 0 || target == cmd.Root() {
+                return usageError("unknown command %q", strings.Join(args, " "))
+            }
+            report.For = strings.Join(commandPath(target), " ")
+            report.Settings = commandSettings(target)
+        } else {
+            report.Settings = configSettings()
+        }
+
+        if jsonOutput() {
+            return writeReport(report)
+        }
+
+        if len(report.Files) == 0 {
+            fmt.Fprintln(out, "Config files: none")
+        } else {
+            fmt.Fprintln(out, "Config files:")
+            for _, path := range report.Files {
+                fmt.Fprintf(out, "  %s\n", path)
+            }
+        }
+        if report.For != "" {
+            fmt.Fprintf(out, "Flags of %s:\n", report.For)
+        } else {
+            fmt.Fprintln(out, "Settings:")
+        }
+        if len(report.Settings) == 0 {
+            fmt.Fprintln(out, "  (none)")
+        }
+        for _, s := range report.Settings {
+            fmt.Fprintf(out, "  %s = %s (%s)\n", s.Name, s.Value, s.Source)
+        }
+        return nil
+    },
+}
+
+// commandSettings lists the effective value of every flag of cmd
+func commandSettings(cmd *cobra.Command) []configEntry {
+    var entries []configEntry
+    visit := func(f *pflag.Flag) {
+        if noConfigFlags[f.Name] || f.Hidden {
+            return
+        }
+        entry := configEntry{Name: f.Name, Value: f.DefValue, Source: "default"}
+        if setting, ok := lookupSetting(cmd, f.Name); ok {
+            entry.Value, entry.Source = strings.Join(setting.values, ","), setting.source
+        }
+        entry.Value = maskSetting(f.Name, entry.Value)
+        entries = append(entries, entry)
+    }
+    cmd.LocalFlags().VisitAll(visit)
+    cmd.InheritedFlags().VisitAll(visit)
+    return entries
+}
+
+// configSettings merges the config files and the environment into one list, a
+// setting from a more important source replacing the same one from a file below
+func configSettings() []configEntry {
+    merged := map[string]configEntry{}
+    for i := len(config) - 1; i >= 0; i-- {
+        flattenConfig(config[i].values, "", config[i].path, merged)
+    }
+
+    var entries []configEntry
+    for _, e := range merged {
+        entries = append(entries, e)
+    }
+    sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+
+    var env []configEntry
+    for _, kv := range os.Environ() {
+        name, value, _ := strings.Cut(kv, "=")
+        if !strings.HasPrefix(name, configEnvPrefix) {
+            continue
+        }
+        for flag := range secretFlags {
+            if strings.HasSuffix(name, "_"+envWord(flag)) {
+                value = maskSetting(flag, value)
+            }
+        }
+        env = append(env, configEntry{Name: name, Value: value, Source: "environment"})
+    }
+    sort.Slice(env, func(i, j int) bool { return env[i].Name < env[j].Name })
+    return append(entries, env...)
+}
+
+// flattenConfig adds the settings of a config section to merged, named by their
+// command path and flag, e.g. mqttSend.topic
+func flattenConfig(values map[string]any, prefix, source string, merged map[string]configEntry) {
+    for key, value := range values {
+        if section, ok := value.(map[string]any); ok {
+            flattenConfig(section, prefix+key+".", source, merged)
+            continue
+        }
+        if value == nil {
+            continue
+        }
+        merged[prefix+key] = configEntry{
+            Name:   prefix + key,
+            Value:  maskSetting(key, strings.Join(settingValues(value), ",")),
+            Source: source,
+        }
+    }
+}
+
+func init() {
+    rootCmd.AddCommand(configCmd)
+    configCmd.AddCommand(configShowCmd)
+}
@@ -0,0 +1,159 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+
+    "github.com/Pranavjeet-Naidu/Mosquito/session"
+    "github.com/Pranavjeet-Naidu/Mosquito/steg"
+)
+
+// Exit statuses, documented under "Exit Status" in usage.md. Scripts branch on
+// these, so existing values must never change.
+const (
+    exitOK        = 0
+    exitFailure   = 1   // Anything not covered below
+    exitUsage     = 2   // Invalid or missing flags and arguments
+    exitIO        = 3   // A file could not be read or written, or the broker could not be reached
+    exitNoData    = 4   // The image holds no Mosquito payload
+    exitAuth      = 5   // Missing or wrong password, or a failed integrity check
+    exitCapacity  = 6   // The payload does not fit in the cover image
+    exitCorrupted = 7   // A payload was found but is damaged
+    exitCancelled = 130 // Interrupted with Ctrl+C
+)
+
+// errNoHiddenData is reported when no header is found in an image
+var errNoHiddenData = errors.New("the image does not appear to contain hidden data")
+
+// commandError is returned by a command's RunE. It records what the command was
+// doing, and the exit status when that cannot be worked out from err.
+type commandError struct {
+    what string
+    code int
+    err  error
+
+    // reported is set when the command has already told the user about err, so
+    // only the exit status is left to set
+    reported bool
+}
+
+func (e *commandError) Error() string {
+    if e.what == "" {
+        return e.err.Error()
+    }
+    return fmt.Sprintf("%s: %v", e.what, e.err)
+}
+
+func (e *commandError) Unwrap() error {
+    return e.err
+}
+
+// fail reports that the step described by what failed. The exit status is
+// derived from err.
+func fail(what string, err error) error {
+    return &commandError{what: what, err: err}
+}
+
+// failWith is fail with an explicit exit status, for errors such as file access
+// that carry no steg sentinel
+func failWith(code int, what string, err error) error {
+    return &commandError{what: what, code: code, err: err}
+}
+
+// reported marks err as already reported by the command itself. It returns nil
+// when err is nil.
+func reported(err error) error {
+    if err == nil {
+        return nil
+    }
+    var cmdErr *commandError
+    if !errors.As(err, &cmdErr) {
+        cmdErr = &commandError{err: err}
+        err = cmdErr
+    }
+    cmdErr.reported = true
+    return err
+}
+
+// usageError reports invalid flags or arguments
+func usageError(format string, args ...any) error {
+    return &commandError{code: exitUsage, err: fmt.Errorf(format, args...)}
+}
+
+// isReported reports whether err was already reported by the command
+func isReported(err error) bool {
+    var cmdErr *commandError
+    return errors.As(err, &cmdErr) && cmdErr.reported
+}
+
+// exitCode maps an error returned by a command to the process exit status
+func exitCode(err error) int {
+    var cmdErr *commandError
+    switch {
+    case err == nil:
+        return exitOK
+    case errors.Is(err, context.Canceled):
+        return exitCancelled
+    case !errors.As(err, &cmdErr):
+        // Cobra rejected the command line before a command ran
+        return exitUsage
+    case cmdErr.code != 0:
+        return cmdErr.code
+    case errors.Is(err, steg.ErrImageTooSmall):
+        return exitCapacity
+    case errors.Is(err, steg.ErrInvalidMagic), errors.Is(err, steg.ErrNoStealthPayload):
+        return exitNoData
+    case errors.Is(err, steg.ErrPasswordRequired), errors.Is(err, steg.ErrDecryptionFailed),
+        errors.Is(err, steg.ErrAuthenticationFailed), errors.Is(err, session.ErrDecryptionFailed),
+        errors.Is(err, session.ErrHandshakeFailed), errors.Is(err, session.ErrMessageKeyGone):
+        return exitAuth
+    case errors.Is(err, steg.ErrInvalidHeader), errors.Is(err, steg.ErrMessageCorrupted),
+        errors.Is(err, session.ErrInvalidFrame):
+        return exitCorrupted
+    case errors.Is(err, steg.ErrUnsupportedMode), errors.Is(err, steg.ErrUnsupportedCipher),
+        errors.Is(err, steg.ErrConflictingOptions), errors.Is(err, steg.ErrInvalidKey),
+        errors.Is(err, steg.ErrUnsupportedFormat), errors.Is(err, steg.ErrInvalidContentType),
+        errors.Is(err, steg.ErrInvalidKDFCost):
+        return exitUsage
+    }
+    return exitFailure
+}
+
+// printError reports a failed command on stderr, spelling out the details carried
+// by the steg error types. A command stopped by Ctrl+C gets a plain notice
+// instead, since nothing went wrong and no output was written.
+func printError(err error) {
+    if errors.Is(err, context.Canceled) {
+        fmt.Fprintln(os.Stderr, "\nCancelled, no output was written")
+        return
+    }
+
+    prefix := "Error"
+    var cmdErr *commandError
+    if errors.As(err, &cmdErr) {
+        if cmdErr.what != "" {
+            prefix += " " + cmdErr.what
+        }
+        err = cmdErr.err
+    }
+
+    var capErr *steg.CapacityError
+    var hdrErr *steg.HeaderError
+    switch {
+    case errors.As(err, &capErr):
+        fmt.Fprintf(os.Stderr, "%s: image too small\n", prefix)
+        fmt.Fprintf(os.Stderr, "  Required: %d bytes, Available: %d bytes\n", capErr.Required, capErr.Available)
+        fmt.Fprintf(os.Stderr, "  Try using a different mode with higher capacity (current: %s)\n", steg.ModeNames[capErr.Mode])
+    case errors.As(err, &hdrErr):
+        fmt.Fprintf(os.Stderr, "%s: damaged or missing header\n", prefix)
+        fmt.Fprintf(os.Stderr, "  Byte %d: %s\n", hdrErr.Offset, hdrErr.Reason)
+    default:
+        fmt.Fprintf(os.Stderr, "%s: %v\n", prefix, err)
+    }
+}
@@ -0,0 +1,62 @@
+package cmd
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "os"
+    "testing"
+
+    "github.com/Pranavjeet-Naidu/Mosquito/session"
+    "github.com/Pranavjeet-Naidu/Mosquito/steg"
+)
+
+func TestExitCode(t *testing.T) {
+    for _, tt := range []struct {
+        name string
+        err  error
+        want int
+    }{
+        {"success", nil, exitOK},
+        {"cobra rejected the arguments", errors.New("unknown flag: --nope"), exitUsage},
+        {"usage error", usageError("bad mode %d", 9), exitUsage},
+        {"explicit status", failWith(exitIO, "reading cover", os.ErrNotExist), exitIO},
+        {"explicit status wins", failWith(exitIO, "reading", steg.ErrDecryptionFailed), exitIO},
+        {"cancelled", fail("hiding data", context.Canceled), exitCancelled},
+        {"cancelled before a command ran", fmt.Errorf("wrapped: %w", context.Canceled), exitCancelled},
+        {"too small", fail("hiding data", &steg.CapacityError{Required: 2, Available: 1}), exitCapacity},
+        {"no header", fail("reading header", &steg.HeaderError{Err: steg.ErrInvalidMagic}), exitNoData},
+        {"no stealth payload", fail("extracting", steg.ErrNoStealthPayload), exitNoData},
+        {"password required", fail("extracting", steg.ErrPasswordRequired), exitAuth},
+        {"wrong password", fail("extracting", steg.ErrDecryptionFailed), exitAuth},
+        {"bad tag", fail("extracting", steg.ErrAuthenticationFailed), exitAuth},
+        {"session message", fail("opening", session.ErrDecryptionFailed), exitAuth},
+        {"damaged header", fail("reading header", &steg.HeaderError{}), exitCorrupted},
+        {"damaged payload", fail("extracting", steg.ErrMessageCorrupted), exitCorrupted},
+        {"damaged frame", fail("opening", session.ErrInvalidFrame), exitCorrupted},
+        {"invalid option", fail("hiding data", steg.ErrInvalidKDFCost), exitUsage},
+        {"anything else", fail("hiding data", errors.New("boom")), exitFailure},
+    } {
+        if got := exitCode(tt.err); got != tt.want {
+            t.Errorf("%s: exitCode(%v) = %d, want %d", tt.name, tt.err, got, tt.want)
+        }
+    }
+}
+
+func TestReported(t *testing.T) {
+    if reported(nil) != nil {
+        t.Error("reported(nil) is not nil")
+    }
+    err := reported(failWith(exitAuth, "extracting", steg.ErrDecryptionFailed))
+    if !isReported(err) || exitCode(err) != exitAuth {
+        t.Errorf("reported error: isReported %v, exit code %d", isReported(err), exitCode(err))
+    }
+    // A plain error is wrapped, so it is no longer mistaken for a cobra usage error
+    err = reported(errors.New("boom"))
+    if !isReported(err) || exitCode(err) != exitFailure {
+        t.Errorf("reported plain error: isReported %v, exit code %d", isReported(err), exitCode(err))
+    }
+    if isReported(fail("hiding data", errors.New("boom"))) {
+        t.Error("unreported error counts as reported")
+    }
+}
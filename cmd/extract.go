@@ -5,10 +5,11 @@ Copyright © 2025 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+    "errors"
     "fmt"
-    "os"
     "path/filepath"
 
+    "github.com/Pranavjeet-Naidu/Mosquito/session"
     "github.com/Pranavjeet-Naidu/Mosquito/steg"
     "github.com/spf13/cobra"
 )
@@ -19,8 +20,26 @@ var (
     extractShowText   bool
     extractPassword   string
     extractInfo       bool
+    extractHMACKey    string
+    extractKDFCost    int
 )
 
+// extractReport is the JSON form of the extract command. With --info only the
+// header is filled in.
+type extractReport struct {
+    reportMeta
+    Input        string       `json:"input"`
+    Header       headerReport `json:"header"`
+    PayloadBytes int          `json:"payload_bytes,omitempty"`
+    ContentType  string       `json:"content_type,omitempty"`
+    Output       string       `json:"output,omitempty"`
+    Text         *string      `json:"text,omitempty"`      // The payload, when shown as text instead of written to a file
+    Integrity    string       `json:"integrity,omitempty"` // verified, not_verified or failed
+    Session      string       `json:"session,omitempty"`
+    Peer         string       `json:"peer,omitempty"`
+    Message      *uint32      `json:"message_number,omitempty"`
+}
+
 // extractCmd represents the extract command
 var extractCmd = &cobra.Command{
     Use:   "extract",
@@ -29,115 +48,200 @@ var extractCmd = &cobra.Command{
     
 Example:
   mosquito extract -i stego.png -o extracted_data.bin
+  mosquito extract -i stego.png -o report              # Saved as report.pdf if a PDF was hidden
   mosquito extract -i stego.png -t                     # Display text message
   mosquito extract -i stego.png -o secret.jpg -p pass  # Extract with password
-  mosquito extract -i stego.png --info                 # Show steganography info`,
-    Run: func(cmd *cobra.Command, args []string) {
+  mosquito extract -i stego.png --info                 # Show steganography info
+  mosquito extract -i stego.png -t -p pass             # Also finds stealth-mode payloads
+  mosquito extract -i stego.png -t --hmac-key secret   # Verify an integrity-tagged payload
+  mosquito extract -i - -o - < stego.png | less        # Read the image from stdin, write the data to stdout`,
+    RunE: func(cmd *cobra.Command, args []string) error {
         if extractInputImage == "" {
-            fmt.Println("Error: Input image path is required")
-            cmd.Help()
-            return
+            return usageError("input image path is required")
+        }
+        if err := checkKDFCost(extractKDFCost); err != nil {
+            return err
+        }
+
+        // Messages go to stderr when the extracted data is written to stdout
+        if extractOutputFile == stdioPath {
+            reserveStdout()
         }
 
         // Load the steganographic image
-        img, err := steg.LoadImage(extractInputImage)
+        img, err := loadImage(extractInputImage)
         if err != nil {
-            fmt.Printf("Error loading image: %v\n", err)
-            return
+            return failWith(exitIO, "loading image", err)
         }
 
+        // Without a plaintext header a password lets the decoder look for a
+        // stealth header, which has no marker
+        dec := steg.NewDecoder(img,
+            steg.WithPassword(extractPassword),
+            steg.WithHMACKey(extractHMACKey),
+            steg.WithKDFCost(extractKDFCost),
+            steg.WithProgress(newProgressBar("Extracting")),
+        )
+
         // Check if this is a steganographic image
-        if !steg.IsStegImage(img) {
-            fmt.Println("Error: The image does not appear to contain hidden data")
-            return
+        header, err := dec.Header()
+        if err != nil {
+            return failWith(exitNoData, "", errNoHiddenData)
+        }
+
+        report := extractReport{
+            reportMeta: newReportMeta(cmd),
+            Input:      extractInputImage,
+            Header:     newHeaderReport(header, dec.CipherSuite),
         }
 
         // Just show info about the steganographic image if requested
         if extractInfo {
-            header, err := steg.GetImageInfo(img)
-            if err != nil {
-                fmt.Printf("Error reading steganography header: %v\n", err)
-                return
+            if jsonOutput() {
+                return writeReport(report)
             }
-            
-            fmt.Println("Steganographic Image Information:")
-            fmt.Printf("  Mode: %s\n", steg.ModeNames[header.Mode])
-            fmt.Printf("  Payload size: %d bytes\n", header.PayloadLen)
-            fmt.Printf("  Contains: %s\n", func() string {
-                if header.IsImage() {
-                    return "Image data"
-                }
-                return "Text/binary data"
-            }())
-            fmt.Printf("  Encryption: %s\n", func() string {
-                if header.IsEncrypted() {
-                    return "Encrypted (password required)"
-                }
-                return "Not encrypted"
-            }())
-            fmt.Printf("  Compression: %s\n", func() string {
-                if header.IsCompressed() {
-                    return "Compressed"
-                }
-                return "Not compressed"
-            }())
-            return
+            fmt.Fprintln(out, "Steganographic Image Information:")
+            printHeaderText(report.Header)
+            return nil
         }
 
         // Extract the hidden data
-        var data []byte
-        if extractPassword != "" {
-            data, err = steg.DecodeMessageWithPassword(img, extractPassword)
+        payload, err := dec.DecodeContext(cmd.Context())
+        if err != nil && !errors.Is(err, steg.ErrAuthenticationFailed) {
+            return fail("extracting data", err)
+        }
+        data, verified, contentType := payload.Data, payload.Verified, payload.ContentType
+
+        // Session frames are decrypted with the stored session keys
+        if header.IsSession() {
+            frame := data
+            var s *session.Session
+            var counter uint32
+            data, s, counter, err = openSessionFrame(extractInputImage, frame)
+            steg.Wipe(frame)
             if err != nil {
-                fmt.Printf("Error extracting data: %v\n", err)
-                return
+                return err
             }
-        } else {
-            data, err = steg.DecodeMessage(img)
-            if err != nil {
-                fmt.Printf("Error extracting data: %v\n", err)
-                return
+            report.Session, report.Peer, report.Message = s.ID, s.Peer, &counter
+
+            // The type of a session payload is recorded inside the encrypted message
+            if header.IsTyped() {
+                typed := data
+                contentType, data, err = steg.SplitContentType(typed)
+                if err != nil {
+                    steg.Wipe(typed)
+                    return fail("extracting data", err)
+                }
+                defer steg.Wipe(typed)
             }
         }
 
-        // Get header to check if this is an image
-        header, _ := steg.GetImageInfo(img)
-        isImage := header.IsImage()
+        // Wipe the plaintext from memory once it has been handed out
+        defer steg.Wipe(data)
+
+        isImage := header.IsImage() || steg.IsImageType(contentType)
+        isText := contentType == "" || steg.IsTextType(contentType)
+        report.PayloadBytes = len(data)
+        report.ContentType = contentType
+
+        // The payload is handed out as it is, but a failed check must not look
+        // like success to scripts
+        var result error
+        if header.IsAuthenticated() {
+            switch {
+            case verified:
+                report.Integrity = "verified"
+            case extractHMACKey == "":
+                report.Integrity = "not_verified"
+            default:
+                report.Integrity = "failed"
+                result = fail("verifying payload", steg.ErrAuthenticationFailed)
+            }
+        }
 
-        if extractShowText && !isImage {
+        // Data written to stdout is passed on as it is. Without -o only text is
+        // shown, unless -t asks for it anyway.
+        showText := !isImage && extractOutputFile != stdioPath &&
+            (extractShowText || (extractOutputFile == "" && isText))
+        outputFile := extractOutputFile
+        if !showText {
+            switch {
+            case outputFile == "" && isImage:
+                return usageError("extracted data is an image, please specify an output file with -o to save it")
+            case outputFile == "":
+                return usageError("extracted data is %s, please specify an output file with -o to save it", contentType)
+            }
+
+            // Give the file the extension of the recorded type when it has none
+            if outputFile != stdioPath && filepath.Ext(outputFile) == "" {
+                outputFile += steg.ExtensionForType(contentType)
+            }
+
+            // Don't start writing once the user has asked to stop
+            if err := cmd.Context().Err(); err != nil {
+                return err
+            }
+            
+            // Save the extracted data to a file readable only by the owner
+            if err := writeOutput(outputFile, data); err != nil {
+                return failWith(exitIO, "writing output file", err)
+            }
+            report.Output = outputFile
+        }
+
+        if jsonOutput() {
+            if showText {
+                text := string(data)
+                report.Text = &text
+            }
+            if err := writeReport(report); err != nil {
+                return err
+            }
+            return reported(result)
+        }
+
+        if report.Session != "" {
+            if report.Peer != "" {
+                fmt.Fprintf(out, "Session: %s (%s), message #%d\n", report.Session, report.Peer, *report.Message)
+            } else {
+                fmt.Fprintf(out, "Session: %s, message #%d\n", report.Session, *report.Message)
+            }
+        }
+
+        // Report the integrity check before handing out the payload
+        switch report.Integrity {
+        case "verified":
+            fmt.Fprintln(out, "Integrity: VERIFIED (HMAC-SHA256 tag matches)")
+        case "not_verified":
+            fmt.Fprintln(out, "Integrity: NOT VERIFIED (use --hmac-key to check the tag)")
+        case "failed":
+            fmt.Fprintln(out, "Integrity: FAILED (payload was modified or the key is wrong)")
+        }
+
+        if showText {
             // Display the extracted data as text
-            fmt.Println("Extracted message:")
-            fmt.Println(string(data))
-        } else if extractOutputFile != "" {
-            // Save the extracted data to a file
-            err = os.WriteFile(extractOutputFile, data, 0644)
-            if err != nil {
-                fmt.Printf("Error writing output file: %v\n", err)
-                return
+            fmt.Fprintln(out, "Extracted message:")
+            fmt.Fprintln(out, string(data))
+        } else {
+            if outputFile != stdioPath {
+                fmt.Fprintf(out, "Data successfully extracted to %s\n", outputFile)
+                if contentType != "" {
+                    fmt.Fprintf(out, "Content type: %s\n", contentType)
+                }
             }
-            fmt.Printf("Data successfully extracted to %s\n", extractOutputFile)
             
-            // If extracted data is an image, try to determine format
-            if isImage {
-                fmt.Println("Extracted data appears to be an image")
+            // Payloads hidden without a type only say whether they are an image
+            if isImage && contentType == "" && outputFile != stdioPath {
+                fmt.Fprintln(out, "Extracted data appears to be an image")
                 
                 // Check file extension
-                ext := filepath.Ext(extractOutputFile)
+                ext := filepath.Ext(outputFile)
                 if ext == "" || ext == ".bin" || ext == ".dat" {
-                    fmt.Println("Note: You may need to rename the file with an appropriate image extension (.png, .jpg, etc.)")
+                    fmt.Fprintln(out, "Note: You may need to rename the file with an appropriate image extension (.png, .jpg, etc.)")
                 }
             }
-        } else {
-            // If no output file specified and text display not requested,
-            // or if it's an image and text display was requested
-            if isImage {
-                fmt.Println("Extracted data is an image. Please specify an output file with -o to save it.")
-            } else {
-                // Default to showing the data as text
-                fmt.Println("Extracted message:")
-                fmt.Println(string(data))
-            }
         }
+        return result
     },
 }
 
@@ -145,10 +249,13 @@ func init() {
     rootCmd.AddCommand(extractCmd)
 
     // Add flags
-    extractCmd.Flags().StringVarP(&extractInputImage, "input", "i", "", "Steganographic image path (required)")
-    extractCmd.Flags().StringVarP(&extractOutputFile, "output", "o", "", "Output file for extracted data")
+    extractCmd.Flags().StringVarP(&extractInputImage, "input", "i", "", "Steganographic image path, or - for stdin (required)")
+    extractCmd.Flags().StringVarP(&extractOutputFile, "output", "o", "", "Output file for extracted data, or - for stdout")
     extractCmd.Flags().BoolVarP(&extractShowText, "text", "t", false, "Display extracted data as text")
     extractCmd.Flags().StringVarP(&extractPassword, "password", "p", "", "Password for decrypting the data")
+    extractCmd.Flags().StringVar(&extractHMACKey, "hmac-key", "", "Shared secret for verifying an HMAC integrity tag")
+    addMaxKDFCostFlag(extractCmd, &extractKDFCost)
+    extractCmd.Flags().StringVar(&sessionDir, "session-dir", "", "Directory holding session state (default ~/.config/mosquito/sessions)")
     extractCmd.Flags().BoolVar(&extractInfo, "info", false, "Show information about the steganographic image")
 
     // Mark required flags
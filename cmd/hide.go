@@ -0,0 +1,144 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+    "fmt"
+
+    "github.com/Pranavjeet-Naidu/Mosquito/steg"
+    "github.com/spf13/cobra"
+)
+
+var (
+    hideInputImage  string
+    hideOutputImage string
+    hideSecret      string
+    hideFormat      string
+    hidePayload     payloadFlags
+)
+
+// hideCmd represents the hide command. hideMsg and hideImg are kept as aliases
+// from when text and images had separate commands.
+var hideCmd = &cobra.Command{
+    Use:     "hide",
+    Aliases: []string{"hideMsg", "hideImg"},
+    Short:   "Hide a message, image or any file inside an image",
+    Long: `Hide a text message, an image or any other file inside an image using
+steganography. The payload type is detected from its contents and recorded, so
+'mosquito extract' can show text directly and name extracted files correctly.
+
+Example:
+  mosquito hide -i input.png -o output.png -m "Secret message"
+  mosquito hide -i input.png -o output.png -f report.pdf
+  mosquito hide -i cover.png -o output.png -f secret.png -p mypassword -M 3
+  mosquito hide -i input.png -o output.png -m "Secret message" -p mypassword --stealth
+  cat notes.txt | mosquito hide -i input.png -f - -o - | mosquito mqttSend -b tcp://broker:1883 -t stego -i -`,
+    RunE: func(cmd *cobra.Command, args []string) error {
+        if hideInputImage == "" || hideOutputImage == "" {
+            return usageError("input and output image paths are required")
+        }
+
+        // -s is the payload flag of the old hideImg command
+        if hideSecret != "" {
+            if hidePayload.file != "" {
+                return usageError("-f and -s both name the file to hide, use only one")
+            }
+            hidePayload.file = hideSecret
+        }
+
+        if err := hidePayload.check(); err != nil {
+            return err
+        }
+        if err := checkStdin(hideInputImage, hidePayload.file); err != nil {
+            return err
+        }
+        if err := checkImageOutput(hideOutputImage, hideFormat); err != nil {
+            return err
+        }
+
+        payload, contentType, err := hidePayload.read()
+        if err != nil {
+            return err
+        }
+        // Don't leave the plaintext lying around in memory once it is hidden
+        defer steg.Wipe(payload)
+
+        // Load the input image
+        img, err := loadImage(hideInputImage)
+        if err != nil {
+            return failWith(exitIO, "loading image", err)
+        }
+
+        report := hideReport{
+            reportMeta: newReportMeta(cmd),
+            Input:      hideInputImage,
+            Output:     hideOutputImage,
+        }
+        protected, err := hidePayload.protect(payload, contentType, &report)
+        if err != nil {
+            return err
+        }
+        defer protected.wipe()
+
+        // A session message is only sealed once it is known to fit
+        if err := protected.fits(img); err != nil {
+            return fail("encoding payload", err)
+        }
+        if err := protected.seal(); err != nil {
+            return err
+        }
+        opts := append(protected.opts, steg.WithProgress(newProgressBar("Embedding")))
+        encoded, err := steg.NewEncoder(img, opts...).EncodeBytesContext(cmd.Context(), protected.data)
+        if err != nil {
+            return fail("encoding payload", err)
+        }
+
+        // Save the output image
+        err = saveImage(cmd.Context(), encoded, hideOutputImage, hideFormat)
+        if err != nil {
+            return failWith(exitIO, "saving image", err)
+        }
+
+        // Remove the plaintext file now that it is safely hidden
+        if hidePayload.shred {
+            if err := steg.ShredFile(hidePayload.file); err != nil {
+                return failWith(exitIO, "shredding file", err)
+            }
+            report.Shredded = true
+        }
+
+        // Calculate detection metrics
+        report.Difference.Percent = steg.MeasureImageDifference(img, encoded) * 100
+
+        if jsonOutput() {
+            return writeReport(report)
+        }
+        if protected.notice != "" {
+            fmt.Fprintln(out, protected.notice)
+        }
+        fmt.Fprintf(out, "%s (%s) successfully hidden in %s using %s\n", payloadNoun(contentType), contentType, displayPath(hideOutputImage), steg.ModeNames[report.Mode])
+        if report.Shredded {
+            fmt.Fprintf(out, "File %s overwritten and deleted\n", hidePayload.file)
+        }
+        fmt.Fprintf(out, "Image difference: %.2f%% (lower is better)\n", report.Difference.Percent)
+        return nil
+    },
+}
+
+func init() {
+    rootCmd.AddCommand(hideCmd)
+
+    // Add flags
+    hideCmd.Flags().StringVarP(&hideInputImage, "input", "i", "", "Cover image path, or - for stdin (required)")
+    hideCmd.Flags().StringVarP(&hideOutputImage, "output", "o", "", "Output image path, or - for stdout (required)")
+    addPayloadFlags(hideCmd, &hidePayload)
+    hideCmd.Flags().StringVarP(&hideSecret, "secret", "s", "", "Same as --file, kept for hideImg")
+    hideCmd.Flags().StringVar(&hideFormat, "format", "", "Output image format (png, bmp, tiff), instead of the one matching the extension")
+    hideCmd.Flags().MarkHidden("secret")
+
+    // Mark required flags
+    hideCmd.MarkFlagRequired("input")
+    hideCmd.MarkFlagRequired("output")
+}
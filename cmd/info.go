@@ -15,6 +15,16 @@ var (
     infoImagePath string
 )
 
+// infoReport is the JSON form of the info command. Header is set for stego
+// images, and Capacity for any other image.
+type infoReport struct {
+    reportMeta
+    Image       imageReport      `json:"image"`
+    Header      *headerReport    `json:"header,omitempty"`
+    HeaderError string           `json:"header_error,omitempty"`
+    Capacity    []capacityReport `json:"capacity,omitempty"`
+}
+
 // infoCmd represents the info command
 var infoCmd = &cobra.Command{
     Use:   "info",
@@ -23,78 +33,74 @@ var infoCmd = &cobra.Command{
     
 Example:
   mosquito info -i image.png`,
-    Run: func(cmd *cobra.Command, args []string) {
+    RunE: func(cmd *cobra.Command, args []string) error {
         if infoImagePath == "" {
-            fmt.Println("Error: Image path is required")
-            cmd.Help()
-            return
+            return usageError("image path is required")
         }
 
         // Load the image
-        img, err := steg.LoadImage(infoImagePath)
+        img, err := loadImage(infoImagePath)
         if err != nil {
-            fmt.Printf("Error loading image: %v\n", err)
-            return
+            return failWith(exitIO, "loading image", err)
+        }
+
+        report := infoReport{
+            reportMeta: newReportMeta(cmd),
+            Image:      newImageReport(infoImagePath, img),
         }
 
-        // Get basic image info
-        width, height, _ := steg.ImageInfo(img)
-        
-        fmt.Println("Image Information:")
-        fmt.Printf("  File: %s\n", infoImagePath)
-        fmt.Printf("  Dimensions: %d x %d pixels\n", width, height)
-        fmt.Printf("  Total pixels: %d\n", width*height)
-        fmt.Printf("  Type: %s\n", func() string {
-            if steg.IsGrayscale(img) {
-                return "Grayscale"
-            }
-            return "Color"
-        }())
-        
         // Check if it's a steganography image
         if steg.IsStegImage(img) {
-            fmt.Println("\nSteganography Information:")
             header, err := steg.GetImageInfo(img)
             if err != nil {
-                fmt.Printf("  Error reading steganography header: %v\n", err)
+                report.HeaderError = err.Error()
             } else {
-                fmt.Printf("  Mode: %s\n", steg.ModeNames[header.Mode])
-                fmt.Printf("  Payload size: %d bytes\n", header.PayloadLen)
-                fmt.Printf("  Contains: %s\n", func() string {
-                    if header.IsImage() {
-                        return "Image data"
-                    }
-                    return "Text/binary data"
-                }())
-                fmt.Printf("  Encryption: %s\n", func() string {
-                    if header.IsEncrypted() {
-                        return "Encrypted (password required)"
-                    }
-                    return "Not encrypted"
-                }())
+                h := newHeaderReport(header, func() (steg.CipherSuite, error) {
+                    return steg.GetCipherSuite(img, header)
+                })
+                report.Header = &h
             }
         } else {
-            fmt.Println("\nSteganography Capacity:")
-            
-            for _, mode := range steg.GetAvailableModes() {
-                maxBytes := steg.CalculateMaxPayloadSize(img, mode)
-                
-                fmt.Printf("  %s: %d bytes (%.1f KB)\n", 
-                    steg.ModeNames[mode], 
-                    maxBytes, 
-                    float64(maxBytes)/1024.0,
-                )
+            report.Capacity = newCapacityReports(img)
+        }
+
+        if jsonOutput() {
+            return writeReport(report)
+        }
+
+        fmt.Fprintln(out, "Image Information:")
+        fmt.Fprintf(out, "  File: %s\n", report.Image.Path)
+        fmt.Fprintf(out, "  Dimensions: %d x %d pixels\n", report.Image.Width, report.Image.Height)
+        fmt.Fprintf(out, "  Total pixels: %d\n", report.Image.Pixels)
+        if report.Image.Grayscale {
+            fmt.Fprintln(out, "  Type: Grayscale")
+        } else {
+            fmt.Fprintln(out, "  Type: Color")
+        }
+
+        switch {
+        case report.HeaderError != "":
+            fmt.Fprintln(out, "\nSteganography Information:")
+            fmt.Fprintf(out, "  Error reading steganography header: %s\n", report.HeaderError)
+        case report.Header != nil:
+            fmt.Fprintln(out, "\nSteganography Information:")
+            printHeaderText(*report.Header)
+        default:
+            fmt.Fprintln(out, "\nSteganography Capacity:")
+            for _, c := range report.Capacity {
+                fmt.Fprintf(out, "  %s: %d bytes (%.1f KB)\n", c.Description, c.Bytes, float64(c.Bytes)/1024.0)
             }
-            
-            fmt.Println("\nRecommended Mode:")
-            if width*height < 1000 {
-                fmt.Println("  This image is very small. Use LSB8 for maximum capacity.")
-            } else if width*height < 10000 {
-                fmt.Println("  This image is small. LSB3 or LSB4 recommended for balance.")
+
+            fmt.Fprintln(out, "\nRecommended Mode:")
+            if pixels := report.Image.Pixels; pixels < 1000 {
+                fmt.Fprintln(out, "  This image is very small. Use LSB8 for maximum capacity.")
+            } else if pixels < 10000 {
+                fmt.Fprintln(out, "  This image is small. LSB3 or LSB4 recommended for balance.")
             } else {
-                fmt.Println("  This image is large enough for LSB1 to hide most messages securely.")
+                fmt.Fprintln(out, "  This image is large enough for LSB1 to hide most messages securely.")
             }
         }
+        return nil
     },
 }
 
@@ -102,7 +108,7 @@ func init() {
     rootCmd.AddCommand(infoCmd)
 
     // Add flags
-    infoCmd.Flags().StringVarP(&infoImagePath, "image", "i", "", "Image path (required)")
+    infoCmd.Flags().StringVarP(&infoImagePath, "image", "i", "", "Image path, or - for stdin (required)")
 
     // Mark required flags
     infoCmd.MarkFlagRequired("image")
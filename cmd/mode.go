@@ -0,0 +1,34 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+    "fmt"
+    "strings"
+
+    "github.com/Pranavjeet-Naidu/Mosquito/steg"
+)
+
+// modeFlagUsage describes the -M flag, listing every registered mode
+func modeFlagUsage() string {
+    var names []string
+    for _, e := range steg.Embedders() {
+        names = append(names, fmt.Sprintf("%d=%s", e.ID(), e.Name()))
+    }
+    return fmt.Sprintf("Steganography mode, by number or name (%s)", strings.Join(names, ", "))
+}
+
+// parseModeFlag resolves the -M flag, listing the valid modes when it is wrong
+func parseModeFlag(value string) (steg.StegMode, error) {
+    mode, err := steg.ParseMode(value)
+    if err != nil {
+        var valid []string
+        for _, e := range steg.Embedders() {
+            valid = append(valid, fmt.Sprintf("  %d: %s", e.ID(), steg.ModeNames[e.ID()]))
+        }
+        return 0, usageError("invalid mode %q. Valid modes are:\n%s", value, strings.Join(valid, "\n"))
+    }
+    return mode, nil
+}
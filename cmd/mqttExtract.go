@@ -0,0 +1,172 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/Pranavjeet-Naidu/Mosquito/mqtt"
+    "github.com/Pranavjeet-Naidu/Mosquito/steg"
+)
+
+// mqttExtractor decodes the payload of every image mqttRecv saves, with the
+// secrets extract takes. Session messages are opened with the stored sessions.
+type mqttExtractor struct {
+    ctx      context.Context
+    password string
+    hmacKey  string
+    kdfCost  int           // highest scrypt cost accepted from an image
+    receipts *receiptSender // nil unless receipts were asked for
+}
+
+// handle extracts the payload of a received image, and answers with a receipt
+// if the sender asked for one. Failures are only reported, so one bad message
+// does not stop the subscriber.
+func (x *mqttExtractor) handle(r mqtt.Received) {
+    err := x.extract(r)
+    switch {
+    case errors.Is(err, errHandshakeFrame):
+        // Already explained by openSessionFrame, and not for the sender to track
+        return
+    case errors.Is(err, errNoHiddenData):
+        fmt.Fprintf(out, "No hidden data found in %s\n", r.Path)
+    case err != nil:
+        fmt.Fprintf(out, "Could not extract %s: %v\n", r.Path, err)
+    }
+
+    if x.receipts == nil || r.Metadata == nil || r.Metadata.ReplyTo == "" {
+        return
+    }
+    failed := func(err error) {
+        if err != nil {
+            fmt.Fprintf(out, "Could not send receipt for %s: %v\n", r.Path, err)
+        }
+    }
+    if err := x.receipts.send(r, err, failed); err != nil {
+        failed(err)
+        return
+    }
+    fmt.Fprintf(out, "  Receipt sent on %s\n", r.Metadata.ReplyTo)
+}
+
+func (x *mqttExtractor) extract(r mqtt.Received) error {
+    img, err := steg.ReadImage(bytes.NewReader(r.Data))
+    if err != nil {
+        return err
+    }
+
+    dec := steg.NewDecoder(img, steg.WithPassword(x.password), steg.WithHMACKey(x.hmacKey), steg.WithKDFCost(x.kdfCost))
+    header, err := dec.Header()
+    if err != nil {
+        return errNoHiddenData
+    }
+
+    // Unlike extract, a payload that fails its integrity check is not handed out
+    payload, err := dec.DecodeContext(x.ctx)
+    if err != nil {
+        return err
+    }
+    data, contentType := payload.Data, payload.ContentType
+
+    var from string
+    if r.Metadata != nil && r.Metadata.Sender != "" {
+        from = " from " + r.Metadata.Sender
+    }
+
+    if header.IsSession() {
+        frame := data
+        plaintext, s, counter, err := openSessionFrame(r.Path, frame)
+        steg.Wipe(frame)
+        if err != nil {
+            return err
+        }
+        data = plaintext
+
+        // The type of a session payload is recorded inside the encrypted message
+        if header.IsTyped() {
+            typed := data
+            contentType, data, err = steg.SplitContentType(typed)
+            if err != nil {
+                steg.Wipe(typed)
+                return err
+            }
+            defer steg.Wipe(typed)
+        }
+        if s.Peer != "" {
+            from = fmt.Sprintf(" from %s (session %s, message #%d)", s.Peer, s.ID, counter)
+        } else {
+            from = fmt.Sprintf(" (session %s, message #%d)", s.ID, counter)
+        }
+    }
+    defer steg.Wipe(data)
+
+    if header.IsAuthenticated() {
+        if payload.Verified {
+            fmt.Fprintf(out, "Integrity of %s: VERIFIED\n", r.Path)
+        } else {
+            fmt.Fprintf(out, "Integrity of %s: NOT VERIFIED (use --hmac-key to check the tag)\n", r.Path)
+        }
+    }
+
+    isImage := header.IsImage() || steg.IsImageType(contentType)
+    if !isImage && (contentType == "" || steg.IsTextType(contentType)) {
+        fmt.Fprintf(out, "Hidden message in %s%s:\n", r.Path, from)
+        fmt.Fprintln(out, string(data))
+        return nil
+    }
+
+    // Payloads hidden without a type are named after what they look like
+    if contentType == "" {
+        contentType = steg.DetectContentType(data)
+    }
+    path, err := writePayloadBeside(r.Path, contentType, data)
+    if err != nil {
+        return err
+    }
+    fmt.Fprintf(out, "Hidden %s%s extracted to %s\n", contentType, from, path)
+    return nil
+}
+
+// writePayloadBeside saves an extracted payload next to the image it came from,
+// as <image>-payload with the extension for its type. Like the images
+// themselves, an existing file is never replaced. The file is readable only by
+// the owner.
+func writePayloadBeside(imagePath, contentType string, data []byte) (string, error) {
+    base := strings.TrimSuffix(imagePath, filepath.Ext(imagePath)) + "-payload"
+    ext := steg.ExtensionForType(contentType)
+    if ext == "" || strings.HasPrefix(contentType, "application/octet-stream") {
+        ext = ".bin"
+    }
+
+    for i := 0; ; i++ {
+        path := base + ext
+        if i > 0 {
+            path = fmt.Sprintf("%s-%d%s", base, i, ext)
+        }
+        f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+        if errors.Is(err, fs.ErrExist) {
+            continue
+        }
+        if err != nil {
+            return "", err
+        }
+        _, err = f.Write(data)
+        if closeErr := f.Close(); err == nil {
+            err = closeErr
+        }
+        if err != nil {
+            os.Remove(path)
+            return "", err
+        }
+        return path, nil
+    }
+}
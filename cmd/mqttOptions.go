@@ -0,0 +1,302 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "time"
+
+    "github.com/Pranavjeet-Naidu/Mosquito/mqtt"
+    "github.com/spf13/cobra"
+)
+
+// brokerFlags holds the connection flags shared by mqttSend and mqttRecv
+type brokerFlags struct {
+    tls          mqtt.TLSOptions
+    username     string
+    password     string
+    passwordFile string
+    passwordEnv  string
+    clientID     string
+    qos          int
+    cleanSession bool
+    storeDir     string
+
+    keepAlive            time.Duration
+    connectTimeout       time.Duration
+    maxReconnectInterval time.Duration
+}
+
+// addBrokerFlags adds the connection flags shared by mqttSend and mqttRecv
+func addBrokerFlags(cmd *cobra.Command, f *brokerFlags) {
+    cmd.Flags().StringVarP(&f.username, "username", "u", "", "User name to log in to the broker with")
+    cmd.Flags().StringVar(&f.password, "broker-password", "", "Broker password or access token (visible to other users in the process list, prefer the file or env forms)")
+    cmd.Flags().StringVar(&f.passwordFile, "broker-password-file", "", "File holding the broker password or access token")
+    cmd.Flags().StringVar(&f.passwordEnv, "broker-password-env", "", "Environment variable holding the broker password or access token")
+    cmd.Flags().StringVar(&f.clientID, "client-id", "", "Fixed MQTT client ID (default: a random mosquito-sender-… or mosquito-receiver-… ID)")
+    cmd.Flags().IntVarP(&f.qos, "qos", "q", 0, "MQTT quality of service: 0 at most once, 1 at least once, 2 exactly once")
+    cmd.Flags().BoolVar(&f.cleanSession, "clean-session", true, "Start a new broker session; false keeps it across runs (needs --client-id)")
+    cmd.Flags().StringVar(&f.storeDir, "store", "", "Directory to keep unacknowledged QoS 1/2 messages in across restarts")
+    cmd.Flags().DurationVar(&f.keepAlive, "keepalive", mqtt.DefaultKeepAlive, "How often to ping an idle broker, so a dead connection is noticed")
+    cmd.Flags().DurationVar(&f.connectTimeout, "connect-timeout", mqtt.DefaultConnectTimeout, "How long connecting, and each attempt to reconnect, may take")
+    cmd.Flags().DurationVar(&f.maxReconnectInterval, "max-reconnect-interval", mqtt.DefaultMaxReconnectInterval, "Longest wait between attempts to reconnect, which double from 1s")
+
+    cmd.Flags().StringVar(&f.tls.CAFile, "tls-ca", "", "PEM bundle of CAs to verify the broker with, instead of the system roots")
+    cmd.Flags().StringVar(&f.tls.CertFile, "tls-cert", "", "Client certificate for mutual TLS (PEM)")
+    cmd.Flags().StringVar(&f.tls.KeyFile, "tls-key", "", "Key of the client certificate (PEM)")
+    cmd.Flags().StringVar(&f.tls.ServerName, "tls-server-name", "", "Name expected in the broker certificate, if not the broker host")
+    cmd.Flags().BoolVar(&f.tls.InsecureSkipVerify, "tls-insecure", false, "Accept any broker certificate (test setups only)")
+    cmd.Flags().StringVar(&f.tls.MinVersion, "tls-min-version", "1.2", "Lowest TLS version to accept (1.0, 1.1, 1.2, 1.3)")
+}
+
+// brokerPassword returns the broker password from whichever of the password
+// flags was given
+func (f *brokerFlags) brokerPassword() (string, error) {
+    given := 0
+    for _, s := range []string{f.password, f.passwordFile, f.passwordEnv} {
+        if s != "" {
+            given++
+        }
+    }
+    if given > 1 {
+        return "", usageError("use only one of --broker-password, --broker-password-file and --broker-password-env")
+    }
+
+    switch {
+    case f.passwordFile != "":
+        return readSecretFile(f.passwordFile, "broker password")
+    case f.passwordEnv != "":
+        password := os.Getenv(f.passwordEnv)
+        if password == "" {
+            return "", usageError("environment variable %s is not set", f.passwordEnv)
+        }
+        return password, nil
+    }
+    return f.password, nil
+}
+
+// mqttOptions returns the connection options for broker. TLS is used for
+// ssl://, tls://, mqtts://, tcps:// and wss:// brokers.
+func mqttOptions(cmd *cobra.Command, broker string, f *brokerFlags) ([]mqtt.Option, error) {
+    var opts []mqtt.Option
+
+    password, err := f.brokerPassword()
+    if err != nil {
+        return nil, err
+    }
+    if password != "" && f.username == "" {
+        return nil, usageError("%v, set it with --username", mqtt.ErrPasswordWithoutUsername)
+    }
+    if f.username != "" {
+        opts = append(opts, mqtt.WithCredentials(f.username, password))
+    }
+    if f.clientID != "" {
+        opts = append(opts, mqtt.WithClientID(f.clientID))
+    }
+
+    if f.qos < 0 || f.qos > 2 {
+        return nil, usageError("%v, got %d", mqtt.ErrInvalidQoS, f.qos)
+    }
+    opts = append(opts, mqtt.WithQoS(byte(f.qos)))
+    if !f.cleanSession {
+        if f.clientID == "" {
+            return nil, usageError("--clean-session=false: %v, set it with --client-id", mqtt.ErrPersistentSessionNeedsClientID)
+        }
+        opts = append(opts, mqtt.WithPersistentSession())
+    }
+    if f.storeDir != "" {
+        opts = append(opts, mqtt.WithFileStore(f.storeDir))
+    }
+
+    // The broker counts keepalive in whole seconds
+    if f.keepAlive < time.Second {
+        return nil, usageError("--keepalive must be at least 1s")
+    }
+    if f.connectTimeout <= 0 {
+        return nil, usageError("--connect-timeout must be positive")
+    }
+    if f.maxReconnectInterval < time.Second {
+        return nil, usageError("--max-reconnect-interval must be at least 1s")
+    }
+    opts = append(opts,
+        mqtt.WithKeepAlive(f.keepAlive),
+        mqtt.WithConnectTimeout(f.connectTimeout),
+        mqtt.WithMaxReconnectInterval(f.maxReconnectInterval),
+        mqtt.WithConnectionHandler(func(e mqtt.ConnectionEvent) {
+            if notice := connectionNotice(broker, e); notice != "" {
+                fmt.Fprintf(os.Stderr, "[%s] %s\n", time.Now().Format(time.TimeOnly), notice)
+            }
+        }),
+    )
+
+    if !mqtt.IsTLSBroker(broker) {
+        for _, name := range []string{"tls-ca", "tls-cert", "tls-key", "tls-server-name", "tls-insecure", "tls-min-version"} {
+            if cmd.Flags().Changed(name) {
+                return nil, usageError("--%s: %v, got %s", name, mqtt.ErrInsecureBroker, broker)
+            }
+        }
+        return opts, nil
+    }
+
+    cfg, err := f.tls.Config()
+    switch {
+    case errors.Is(err, mqtt.ErrInvalidTLSVersion), errors.Is(err, mqtt.ErrIncompleteKeyPair):
+        return nil, usageError("%v", err)
+    case err != nil:
+        return nil, failWith(exitIO, "loading TLS files", err)
+    }
+    return append(opts, mqtt.WithTLS(cfg)), nil
+}
+
+// connectionNotice describes a change in the connection to broker, or returns
+// "" for the first connection, which the commands report themselves
+func connectionNotice(broker string, e mqtt.ConnectionEvent) string {
+    switch e.State {
+    case mqtt.ConnectionLost:
+        return fmt.Sprintf("Connection to %s lost: %v, reconnecting", broker, e.Err)
+    case mqtt.Reconnecting:
+        return fmt.Sprintf("Reconnecting to %s (attempt %d)", broker, e.Attempt)
+    case mqtt.Reconnected:
+        if e.Err != nil {
+            return fmt.Sprintf("Reconnected to %s, but subscribing again failed: %v", broker, e.Err)
+        }
+        return fmt.Sprintf("Reconnected to %s", broker)
+    }
+    return ""
+}
+
+// publishFlags holds the flags shared by mqttSend and send
+type publishFlags struct {
+    broker       string
+    topic        string
+    conn         brokerFlags
+    retain       bool
+    raw          bool
+    chunkSize    int
+    resendWindow time.Duration
+    waitReceipt  time.Duration
+    replyTopic   string
+    receiptKey   string
+}
+
+// published describes an image that was published
+type published struct {
+    id     string // Message ID from the envelope, "" with --raw
+    chunks int    // Number of chunks, 0 when sent as one message
+
+    receipt *receiptReport // nil unless --wait-receipt was given
+}
+
+// addPublishFlags adds the flags shared by mqttSend and send, including the
+// broker connection flags
+func addPublishFlags(cmd *cobra.Command, f *publishFlags) {
+    cmd.Flags().StringVarP(&f.broker, "broker", "b", "", "MQTT broker URL (required)")
+    cmd.Flags().StringVarP(&f.topic, "topic", "t", "", "MQTT topic (required)")
+    cmd.Flags().BoolVar(&f.retain, "retain", false, "Have the broker keep the image for clients that subscribe later")
+    cmd.Flags().IntVar(&f.chunkSize, "chunk-size", 0, "Split images larger than this many KiB into chunks, to fit the broker's packet limit (0, the default, sends them whole so older receivers can read them)")
+    cmd.Flags().DurationVar(&f.resendWindow, "resend-window", 0, "Stay connected this long after a chunked image to resend chunks receivers ask for")
+    cmd.Flags().DurationVar(&f.waitReceipt, "wait-receipt", 0, "Wait this long for the receiver to confirm it extracted the payload (needs mqttRecv --receipts)")
+    cmd.Flags().StringVar(&f.replyTopic, "reply-topic", "", "Topic to wait for the receipt on (default mosquito/receipts/<message id>)")
+    cmd.Flags().StringVar(&f.receiptKey, "receipt-key", "", "Shared secret that authenticates receipts")
+    addBrokerFlags(cmd, &f.conn)
+}
+
+// options validates the publishing flags and returns the options for
+// PublishImageData, before any work is done
+func (f *publishFlags) options(cmd *cobra.Command) ([]mqtt.Option, error) {
+    opts, err := mqttOptions(cmd, f.broker, &f.conn)
+    if err != nil {
+        return nil, err
+    }
+    if f.retain {
+        opts = append(opts, mqtt.WithRetain())
+    }
+
+    chunkSize := f.chunkSize * 1024
+    if chunkSize < 0 || (chunkSize > 0 && chunkSize < mqtt.MinChunkSize) {
+        return nil, usageError("--chunk-size must be 0 or at least %d KiB", mqtt.MinChunkSize/1024)
+    }
+
+    // The reply topic and message ID travel in the envelope
+    if f.waitReceipt < 0 {
+        return nil, usageError("--wait-receipt must not be negative")
+    }
+    if f.waitReceipt > 0 && f.raw {
+        return nil, usageError("--wait-receipt needs the envelope, drop --raw")
+    }
+    return append(opts, mqtt.WithChunkSize(chunkSize), mqtt.WithResendWindow(f.resendWindow)), nil
+}
+
+// publish sends an image with the options from options. The image is sealed in
+// an envelope naming filename, which may be empty, unless --raw was given.
+func (f *publishFlags) publish(opts []mqtt.Option, data []byte, filename string) (published, error) {
+    var p published
+
+    // Seal the envelope here rather than in PublishImageData, to know the size
+    // of what is sent
+    payload := data
+    var receipts *receiptCollector
+    if f.raw {
+        opts = append(opts, mqtt.WithRawPayload())
+    } else {
+        sender := f.conn.clientID
+        if sender == "" {
+            sender = mqtt.NewClientID("sender")
+            opts = append(opts, mqtt.WithClientID(sender))
+        }
+        meta := mqtt.Metadata{ID: mqtt.NewMessageID(), Filename: filename, Sender: sender, Timestamp: time.Now().UTC()}
+        if f.waitReceipt > 0 {
+            meta.ReplyTo = f.replyTopic
+            if meta.ReplyTo == "" {
+                meta.ReplyTo = mqtt.ReplyTopic(meta.ID)
+            }
+            receipts = &receiptCollector{id: meta.ID, key: f.receiptKey}
+            opts = append(opts, mqtt.WithReceipt(meta.ReplyTo, f.waitReceipt, receipts.accept))
+        }
+        var err error
+        if payload, err = mqtt.Seal(meta, data); err != nil {
+            return p, fail("sealing image", err)
+        }
+        p.id = meta.ID
+    }
+
+    chunkSize := f.chunkSize * 1024
+    if chunkSize > 0 && len(payload) > chunkSize {
+        if f.retain {
+            return p, usageError("%v; raise --chunk-size or drop --retain", mqtt.ErrRetainChunked)
+        }
+        p.chunks = mqtt.ChunkCount(len(payload), chunkSize)
+    }
+
+    // No receipt is not a failure to send, see receiptError
+    err := mqtt.PublishImageData(f.broker, f.topic, payload, opts...)
+    if err != nil && !errors.Is(err, mqtt.ErrNoReceipt) {
+        return p, failWith(exitIO, "sending image", err)
+    }
+    if receipts != nil {
+        p.receipt = receipts.result()
+    }
+    return p, nil
+}
+
+// printPublished reports a published image
+func (f *publishFlags) printPublished(p published) {
+    if p.chunks > 0 {
+        fmt.Fprintf(out, "Image sent in %d chunks of up to %d KiB\n", p.chunks, f.chunkSize)
+    }
+    if f.conn.qos > 0 {
+        fmt.Fprintf(out, "Image delivered to %s on topic %s (QoS %d, acknowledged by the broker)\n", f.broker, f.topic, f.conn.qos)
+    } else {
+        fmt.Fprintf(out, "Image successfully sent to %s on topic %s\n", f.broker, f.topic)
+    }
+    if p.id != "" {
+        fmt.Fprintf(out, "Message ID: %s\n", p.id)
+    }
+    if p.receipt != nil {
+        printReceipt(p.receipt, f.waitReceipt)
+    }
+}
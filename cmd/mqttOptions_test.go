@@ -0,0 +1,44 @@
+package cmd
+
+import (
+    "path/filepath"
+    "testing"
+
+    "github.com/spf13/cobra"
+)
+
+// brokerCommand returns a command with the broker flags, parsed from args
+func brokerCommand(t *testing.T, args ...string) (*cobra.Command, *brokerFlags) {
+    t.Helper()
+    f := &brokerFlags{}
+    cmd := &cobra.Command{Use: "test"}
+    addBrokerFlags(cmd, f)
+    if err := cmd.ParseFlags(args); err != nil {
+        t.Fatal(err)
+    }
+    return cmd, f
+}
+
+func TestMQTTOptionsTLSFlags(t *testing.T) {
+    missing := filepath.Join(t.TempDir(), "none.pem")
+    for _, tt := range []struct {
+        name   string
+        broker string
+        args   []string
+        code   int
+    }{
+        {"TLS flag with plain broker", "tcp://h:1883", []string{"--tls-insecure"}, exitUsage},
+        {"CA with plain broker", "tcp://h:1883", []string{"--tls-ca", missing}, exitUsage},
+        {"bad TLS version", "ssl://h:8883", []string{"--tls-min-version", "1.4"}, exitUsage},
+        {"certificate without key", "ssl://h:8883", []string{"--tls-cert", "c.pem"}, exitUsage},
+        {"missing CA file", "ssl://h:8883", []string{"--tls-ca", missing}, exitIO},
+        {"TLS broker", "ssl://h:8883", nil, exitOK},
+        {"plain broker", "tcp://h:1883", nil, exitOK},
+    } {
+        cmd, f := brokerCommand(t, tt.args...)
+        _, err := mqttOptions(cmd, tt.broker, f)
+        if code := exitCode(err); code != tt.code {
+            t.Errorf("%s: exit status %d (%v), want %d", tt.name, code, err, tt.code)
+        }
+    }
+}
@@ -9,6 +9,7 @@ import (
     "os"
     "os/signal"
     "syscall"
+    "time"
 
     "github.com/Pranavjeet-Naidu/Mosquito/mqtt"
     "github.com/spf13/cobra"
@@ -18,6 +19,20 @@ var (
     mqttRecvBroker    string
     mqttRecvTopic     string
     mqttRecvOutputDir string
+    mqttRecvConn      brokerFlags
+
+    mqttRecvChunkTimeout  time.Duration
+    mqttRecvRequestResend bool
+
+    mqttRecvExtract      bool
+    mqttRecvPassword     string
+    mqttRecvPasswordFile string
+    mqttRecvHMACKey      string
+    mqttRecvKDFCost      int
+
+    mqttRecvReceipts     bool
+    mqttRecvReceiptKey   string
+    mqttRecvReceiptCover string
 )
 
 // mqttRecvCmd represents the mqttRecv command
@@ -25,21 +40,74 @@ var mqttRecvCmd = &cobra.Command{
     Use:   "mqttRecv",
     Short: "Receive steganographic images via MQTT",
     Long: `Subscribe to an MQTT topic and receive steganographic images.
-Images will be saved to the specified output directory.
+Images will be saved to the specified output directory, under the name the
+sender gave them or received-<time> with an extension for their content.
+Existing files are never replaced.
     
 Example:
-  mosquito mqttRecv -b tcp://broker.example.com:1883 -t stego/images -o ./received`,
-    Run: func(cmd *cobra.Command, args []string) {
+  mosquito mqttRecv -b tcp://broker.example.com:1883 -t stego/images -o ./received
+  mosquito mqttRecv -b ssl://broker.example.com:8883 --tls-ca ca.pem -t stego/images -o ./received
+  mosquito mqttRecv -b ssl://broker.example.com:8883 -u bob --broker-password-env MQTT_PASSWORD --client-id bob-laptop -t stego/images -o ./received
+  mosquito mqttRecv -b tcp://broker.example.com:1883 --client-id bob-laptop --clean-session=false -q 1 -t stego/images -o ./received
+  mosquito mqttRecv -b tcp://broker.example.com:1883 -t stego/images -o ./received --extract --password-file ~/.stego-pass
+  mosquito mqttRecv -b tcp://broker.example.com:1883 -t stego/images -o ./received --extract -p mypassword --receipts --receipt-key "shared secret" --receipt-cover small.png`,
+    RunE: func(cmd *cobra.Command, args []string) error {
         if mqttRecvBroker == "" || mqttRecvTopic == "" || mqttRecvOutputDir == "" {
-            fmt.Println("Error: Broker URL, topic, and output directory are required")
-            cmd.Help()
-            return
+            return usageError("broker URL, topic, and output directory are required")
+        }
+
+        opts, err := mqttOptions(cmd, mqttRecvBroker, &mqttRecvConn)
+        if err != nil {
+            return err
+        }
+        if mqttRecvReceipts && !mqttRecvExtract {
+            return usageError("--receipts needs --extract, a receipt confirms the payload was extracted")
+        }
+        // An image hiding an unencrypted receipt would only look private
+        if mqttRecvReceipts && mqttRecvReceiptCover != "" && mqttRecvReceiptKey == "" {
+            return usageError("--receipt-cover needs --receipt-key, the receipt is encrypted with it")
+        }
+        opts = append(opts, mqtt.WithChunkTimeout(mqttRecvChunkTimeout))
+        if mqttRecvRequestResend {
+            opts = append(opts, mqtt.WithResendRequests())
+        }
+
+        // The secrets may come from a config file shared with extract, so they
+        // are ignored rather than refused without --extract
+        if mqttRecvExtract {
+            if mqttRecvPassword != "" && mqttRecvPasswordFile != "" {
+                return usageError("use only one of -p and --password-file")
+            }
+            if err := checkKDFCost(mqttRecvKDFCost); err != nil {
+                return err
+            }
+            password := mqttRecvPassword
+            if mqttRecvPasswordFile != "" {
+                if password, err = readSecretFile(mqttRecvPasswordFile, "password"); err != nil {
+                    return err
+                }
+            }
+            x := &mqttExtractor{ctx: cmd.Context(), password: password, hmacKey: mqttRecvHMACKey, kdfCost: mqttRecvKDFCost}
+            if mqttRecvReceipts {
+                // Receipts name the receiver, so its client ID is fixed here
+                receiver := mqttRecvConn.clientID
+                if receiver == "" {
+                    receiver = mqtt.NewClientID("receiver")
+                    opts = append(opts, mqtt.WithClientID(receiver))
+                }
+                x.receipts = &receiptSender{receiver: receiver, key: mqttRecvReceiptKey}
+                if mqttRecvReceiptCover != "" {
+                    if x.receipts.cover, err = loadImage(mqttRecvReceiptCover); err != nil {
+                        return failWith(exitIO, "loading receipt cover", err)
+                    }
+                }
+            }
+            opts = append(opts, mqtt.WithImageHandler(x.handle))
         }
 
         // Ensure output directory exists
         if err := os.MkdirAll(mqttRecvOutputDir, 0755); err != nil {
-            fmt.Printf("Error creating output directory: %v\n", err)
-            return
+            return failWith(exitIO, "creating output directory", err)
         }
 
         // Setup signal handling for graceful shutdown
@@ -47,19 +115,28 @@ Example:
         signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
         // Start receiving messages
-        client, err := mqtt.SubscribeForImages(mqttRecvBroker, mqttRecvTopic, mqttRecvOutputDir)
+        client, err := mqtt.SubscribeForImages(mqttRecvBroker, mqttRecvTopic, mqttRecvOutputDir, opts...)
         if err != nil {
-            fmt.Printf("Error subscribing: %v\n", err)
-            return
+            return failWith(exitIO, "subscribing", err)
         }
 
-        fmt.Printf("Subscribed to %s on topic %s\n", mqttRecvBroker, mqttRecvTopic)
-        fmt.Println("Waiting for images... (Press Ctrl+C to stop)")
+        fmt.Fprintf(out, "Subscribed to %s on topic %s (QoS %d)\n", mqttRecvBroker, mqttRecvTopic, mqttRecvConn.qos)
+        if !mqttRecvConn.cleanSession {
+            fmt.Fprintf(out, "Persistent session %s: images queued while offline are delivered now\n", mqttRecvConn.clientID)
+        }
+        if mqttRecvExtract {
+            fmt.Fprintln(out, "Hidden payloads are extracted as images arrive")
+        }
+        if mqttRecvReceipts {
+            fmt.Fprintln(out, "Senders that ask for a receipt are told whether extracting worked")
+        }
+        fmt.Fprintln(out, "Waiting for images... (Press Ctrl+C to stop)")
 
         // Wait for termination signal
         <-sigChan
-        fmt.Println("\nShutting down...")
+        fmt.Fprintln(out, "\nShutting down...")
         client.Disconnect(250)
+        return nil
     },
 }
 
@@ -70,6 +147,18 @@ func init() {
     mqttRecvCmd.Flags().StringVarP(&mqttRecvBroker, "broker", "b", "", "MQTT broker URL (required)")
     mqttRecvCmd.Flags().StringVarP(&mqttRecvTopic, "topic", "t", "", "MQTT topic to subscribe to (required)")
     mqttRecvCmd.Flags().StringVarP(&mqttRecvOutputDir, "output", "o", "", "Directory to save received images (required)")
+    mqttRecvCmd.Flags().DurationVar(&mqttRecvChunkTimeout, "chunk-timeout", time.Minute, "How long to wait for the next chunk of a chunked image")
+    mqttRecvCmd.Flags().BoolVar(&mqttRecvRequestResend, "request-resend", false, "Ask the sender for missing chunks (needs mqttSend --resend-window)")
+    mqttRecvCmd.Flags().BoolVar(&mqttRecvExtract, "extract", false, "Extract the hidden payload of each image as it arrives: text is printed, files are saved next to the image")
+    mqttRecvCmd.Flags().StringVarP(&mqttRecvPassword, "password", "p", "", "Password for decrypting extracted payloads")
+    mqttRecvCmd.Flags().StringVar(&mqttRecvPasswordFile, "password-file", "", "File holding the password for decrypting extracted payloads")
+    mqttRecvCmd.Flags().StringVar(&mqttRecvHMACKey, "hmac-key", "", "Shared secret for verifying the integrity tag of extracted payloads")
+    addMaxKDFCostFlag(mqttRecvCmd, &mqttRecvKDFCost)
+    mqttRecvCmd.Flags().BoolVar(&mqttRecvReceipts, "receipts", false, "Answer senders waiting for a receipt (mqttSend --wait-receipt), needs --extract")
+    mqttRecvCmd.Flags().StringVar(&mqttRecvReceiptKey, "receipt-key", "", "Shared secret that authenticates receipts, give the sender the same one")
+    mqttRecvCmd.Flags().StringVar(&mqttRecvReceiptCover, "receipt-cover", "", "Hide receipts in this image, encrypted with the receipt key, instead of sending them plainly")
+    mqttRecvCmd.Flags().StringVar(&sessionDir, "session-dir", "", "Directory holding session state (default ~/.config/mosquito/sessions)")
+    addBrokerFlags(mqttRecvCmd, &mqttRecvConn)
 
     // Mark required flags
     mqttRecvCmd.MarkFlagRequired("broker")
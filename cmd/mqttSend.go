@@ -5,16 +5,12 @@ Copyright © 2025 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
-    "fmt"
-
-    "github.com/Pranavjeet-Naidu/Mosquito/mqtt"
     "github.com/spf13/cobra"
 )
 
 var (
-    mqttSendBroker string
-    mqttSendTopic  string
-    mqttSendImage  string
+    mqttSendImage string
+    mqttSendPub   publishFlags
 )
 
 // mqttSendCmd represents the mqttSend command
@@ -24,21 +20,38 @@ var mqttSendCmd = &cobra.Command{
     Long: `Send a steganographic image to an MQTT broker.
     
 Example:
-  mosquito mqttSend -b tcp://broker.example.com:1883 -t stego/images -i stego.png`,
-    Run: func(cmd *cobra.Command, args []string) {
-        if mqttSendBroker == "" || mqttSendTopic == "" || mqttSendImage == "" {
-            fmt.Println("Error: Broker URL, topic, and image path are required")
-            cmd.Help()
-            return
+  mosquito mqttSend -b tcp://broker.example.com:1883 -t stego/images -i stego.png
+  mosquito mqttSend -b ssl://broker.example.com:8883 --tls-ca ca.pem --tls-cert me.pem --tls-key me.key -t stego/images -i stego.png
+  mosquito mqttSend -b ssl://broker.example.com:8883 -u alice --broker-password-file ~/.mqtt-pass -t stego/images -i stego.png
+  mosquito mqttSend -b tcp://broker.example.com:1883 -q 1 -t stego/images -i stego.png   # Wait for the broker to confirm
+  mosquito mqttSend -b tcp://broker.example.com:1883 --chunk-size 64 --resend-window 30s -t stego/images -i large.png
+  mosquito mqttSend -b tcp://broker.example.com:1883 --wait-receipt 30s --receipt-key "shared secret" -t stego/images -i stego.png
+  mosquito hide -i cover.png -m "hi" -o - | mosquito mqttSend -b tcp://broker.example.com:1883 -t stego/images -i -`,
+    RunE: func(cmd *cobra.Command, args []string) error {
+        if mqttSendPub.broker == "" || mqttSendPub.topic == "" || mqttSendImage == "" {
+            return usageError("broker URL, topic, and image path are required")
+        }
+
+        opts, err := mqttSendPub.options(cmd)
+        if err != nil {
+            return err
         }
 
-        err := mqtt.PublishImage(mqttSendBroker, mqttSendTopic, mqttSendImage)
+        data, err := readInput(mqttSendImage)
         if err != nil {
-            fmt.Printf("Error sending image: %v\n", err)
-            return
+            return failWith(exitIO, "reading image", err)
         }
 
-        fmt.Printf("Image successfully sent to %s on topic %s\n", mqttSendBroker, mqttSendTopic)
+        var filename string
+        if mqttSendImage != stdioPath {
+            filename = mqttSendImage
+        }
+        p, err := mqttSendPub.publish(opts, data, filename)
+        if err != nil {
+            return err
+        }
+        mqttSendPub.printPublished(p)
+        return receiptError(p.receipt)
     },
 }
 
@@ -46,12 +59,12 @@ func init() {
     rootCmd.AddCommand(mqttSendCmd)
 
     // Add flags
-    mqttSendCmd.Flags().StringVarP(&mqttSendBroker, "broker", "b", "", "MQTT broker URL (required)")
-    mqttSendCmd.Flags().StringVarP(&mqttSendTopic, "topic", "t", "", "MQTT topic (required)")
-    mqttSendCmd.Flags().StringVarP(&mqttSendImage, "image", "i", "", "Image path to send (required)")
+    mqttSendCmd.Flags().StringVarP(&mqttSendImage, "image", "i", "", "Image path to send, or - for stdin (required)")
+    mqttSendCmd.Flags().BoolVar(&mqttSendPub.raw, "raw", false, "Send the image bytes alone, without the envelope naming the file and sender (for older receivers)")
+    addPublishFlags(mqttSendCmd, &mqttSendPub)
 
     // Mark required flags
     mqttSendCmd.MarkFlagRequired("broker")
     mqttSendCmd.MarkFlagRequired("topic")
     mqttSendCmd.MarkFlagRequired("image")
-}
\ No newline at end of file
+}
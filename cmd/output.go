@@ -0,0 +1,232 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+    "encoding/json"
+    "errors"
+    "fmt"
+    "image"
+    "strings"
+
+    "github.com/Pranavjeet-Naidu/Mosquito/steg"
+    "github.com/spf13/cobra"
+)
+
+// Formats accepted by --output-format
+const (
+    formatText = "text"
+    formatJSON = "json"
+)
+
+// reportSchemaVersion is the version of the JSON reports. Fields may be added
+// within a version; removing a field or changing its meaning needs a new version.
+const reportSchemaVersion = 1
+
+var outputFormat string
+
+// jsonOutput reports whether results should be written as JSON
+func jsonOutput() bool {
+    return outputFormat == formatJSON
+}
+
+// checkOutputFormat validates --output-format before a command runs
+func checkOutputFormat(cmd *cobra.Command, args []string) error {
+    switch outputFormat {
+    case formatText, formatJSON:
+        return nil
+    }
+    return usageError("invalid output format %q, expected %s or %s", outputFormat, formatText, formatJSON)
+}
+
+// reportMeta starts every JSON report
+type reportMeta struct {
+    SchemaVersion int    `json:"schema_version"`
+    Command       string `json:"command"`
+}
+
+func newReportMeta(cmd *cobra.Command) reportMeta {
+    return reportMeta{
+        SchemaVersion: reportSchemaVersion,
+        Command:       strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" "),
+    }
+}
+
+// imageReport describes an image file
+type imageReport struct {
+    Path      string `json:"path"`
+    Width     int    `json:"width"`
+    Height    int    `json:"height"`
+    Pixels    int    `json:"pixels"`
+    Grayscale bool   `json:"grayscale"`
+}
+
+func newImageReport(path string, img image.Image) imageReport {
+    width, height, _ := steg.ImageInfo(img)
+    return imageReport{
+        Path:      path,
+        Width:     width,
+        Height:    height,
+        Pixels:    width * height,
+        Grayscale: steg.IsGrayscale(img),
+    }
+}
+
+// capacityReport is the payload capacity of an image in one mode
+type capacityReport struct {
+    Mode        steg.StegMode `json:"mode"`
+    ModeName    string        `json:"mode_name"`
+    Description string        `json:"description"`
+    Bytes       int           `json:"bytes"`
+}
+
+func newCapacityReports(img image.Image) []capacityReport {
+    var reports []capacityReport
+    for _, e := range steg.Embedders() {
+        reports = append(reports, capacityReport{
+            Mode:        e.ID(),
+            ModeName:    e.Name(),
+            Description: steg.ModeNames[e.ID()],
+            Bytes:       steg.CalculateMaxPayloadSize(img, e.ID()),
+        })
+    }
+    return reports
+}
+
+// headerReport describes a detected Mosquito header
+type headerReport struct {
+    Version       uint8         `json:"version"`
+    Mode          steg.StegMode `json:"mode"`
+    ModeName      string        `json:"mode_name"`
+    PayloadLength uint32        `json:"payload_length"`
+    Image         bool          `json:"image"`
+    Encrypted     bool          `json:"encrypted"`
+    Cipher        string        `json:"cipher,omitempty"`
+    Compressed    bool          `json:"compressed"`
+    Authenticated bool          `json:"authenticated"`
+    Stealth       bool          `json:"stealth"`
+    Session       bool          `json:"session"`
+}
+
+// newHeaderReport describes header. cipherSuite is asked for the cipher suite of
+// encrypted payloads, and may be nil when it cannot be found.
+func newHeaderReport(header steg.Header, cipherSuite func() (steg.CipherSuite, error)) headerReport {
+    h := headerReport{
+        Version:       header.Version,
+        Mode:          header.Mode,
+        ModeName:      header.Mode.String(),
+        PayloadLength: header.PayloadLen,
+        Image:         header.IsImage(),
+        Encrypted:     header.IsEncrypted(),
+        Compressed:    header.IsCompressed(),
+        Authenticated: header.IsAuthenticated(),
+        Stealth:       header.IsStealth(),
+        Session:       header.IsSession(),
+    }
+    if h.Encrypted && !h.Session && cipherSuite != nil {
+        if suite, err := cipherSuite(); err == nil {
+            h.Cipher = suite.String()
+        }
+    }
+    return h
+}
+
+// printHeaderText prints a header report for people
+func printHeaderText(h headerReport) {
+    fmt.Fprintf(out, "  Mode: %s\n", steg.ModeNames[h.Mode])
+    fmt.Fprintf(out, "  Payload size: %d bytes\n", h.PayloadLength)
+    if h.Image {
+        fmt.Fprintln(out, "  Contains: Image data")
+    } else {
+        fmt.Fprintln(out, "  Contains: Text/binary data")
+    }
+    switch {
+    case h.Session:
+        fmt.Fprintln(out, "  Encryption: Session keys (forward-secret ratchet)")
+    case !h.Encrypted:
+        fmt.Fprintln(out, "  Encryption: Not encrypted")
+    case h.Cipher != "":
+        fmt.Fprintf(out, "  Encryption: Encrypted with %s (password required)\n", h.Cipher)
+    default:
+        fmt.Fprintln(out, "  Encryption: Encrypted (password required)")
+    }
+    if h.Compressed {
+        fmt.Fprintln(out, "  Compression: Compressed")
+    } else {
+        fmt.Fprintln(out, "  Compression: Not compressed")
+    }
+    if h.Authenticated {
+        fmt.Fprintln(out, "  Integrity: HMAC-SHA256 tag (verify with --hmac-key)")
+    }
+    if h.Stealth {
+        fmt.Fprintln(out, "  Header: Encrypted (stealth mode)")
+    }
+}
+
+// hideReport is the result of hiding a payload
+type hideReport struct {
+    reportMeta
+    Input        string        `json:"input"`
+    Output       string        `json:"output,omitempty"` // Not set by send, which publishes the image instead
+    Mode         steg.StegMode `json:"mode"`
+    ModeName     string        `json:"mode_name"`
+    PayloadBytes int           `json:"payload_bytes"`
+    ContentType  string        `json:"content_type"`
+    Image        bool          `json:"image"`
+    Protection   string        `json:"protection"` // none, password, stealth, hmac or session
+    Cipher       string        `json:"cipher,omitempty"`
+    Session      string        `json:"session,omitempty"`
+    Message      *uint32       `json:"message_number,omitempty"`
+    Shredded     bool          `json:"shredded"`
+    Difference   struct {
+        Percent float64 `json:"percent"`
+    } `json:"difference"`
+}
+
+// errorReport is written in place of a result when a command fails
+type errorReport struct {
+    reportMeta
+    Error struct {
+        ExitCode int                `json:"exit_code"`
+        Kind     string             `json:"kind"`
+        Message  string             `json:"message"`
+        Capacity *steg.CapacityError `json:"capacity,omitempty"`
+        Header   *steg.HeaderError   `json:"header,omitempty"`
+    } `json:"error"`
+}
+
+// errorKinds names the exit statuses in error reports
+var errorKinds = map[int]string{
+    exitFailure:   "failure",
+    exitUsage:     "usage",
+    exitIO:        "io",
+    exitNoData:    "no_data",
+    exitAuth:      "auth",
+    exitCapacity:  "capacity",
+    exitCorrupted: "corrupted",
+    exitCancelled: "cancelled",
+}
+
+// writeReport writes a JSON report to stdout
+func writeReport(report any) error {
+    enc := json.NewEncoder(out)
+    enc.SetIndent("", "  ")
+    enc.SetEscapeHTML(false)
+    if err := enc.Encode(report); err != nil {
+        return failWith(exitIO, "writing report", err)
+    }
+    return nil
+}
+
+// writeErrorReport writes err as a JSON error report to stdout
+func writeErrorReport(cmd *cobra.Command, err error) {
+    r := errorReport{reportMeta: newReportMeta(cmd)}
+    r.Error.ExitCode = exitCode(err)
+    r.Error.Kind = errorKinds[r.Error.ExitCode]
+    r.Error.Message = err.Error()
+    errors.As(err, &r.Error.Capacity)
+    errors.As(err, &r.Error.Header)
+    writeReport(r)
+}
@@ -0,0 +1,57 @@
+package cmd
+
+import (
+    "bytes"
+    "encoding/json"
+    "testing"
+
+    "github.com/Pranavjeet-Naidu/Mosquito/steg"
+)
+
+// decodeErrorReport writes err as a JSON error report and decodes the result
+func decodeErrorReport(t *testing.T, err error) map[string]any {
+    t.Helper()
+    var buf bytes.Buffer
+    saved := out
+    out = &buf
+    defer func() { out = saved }()
+
+    writeErrorReport(hideCmd, err)
+    var report map[string]any
+    if err := json.Unmarshal(buf.Bytes(), &report); err != nil {
+        t.Fatalf("invalid report %q: %v", buf.String(), err)
+    }
+    return report
+}
+
+func TestErrorReport(t *testing.T) {
+    capErr := &steg.CapacityError{Required: 100, Available: 40, Mode: steg.LSB3}
+    report := decodeErrorReport(t, fail("hiding data", capErr))
+    if report["command"] != "hide" || report["schema_version"] != float64(reportSchemaVersion) {
+        t.Errorf("report starts with command %v, schema %v", report["command"], report["schema_version"])
+    }
+    e := report["error"].(map[string]any)
+    if e["exit_code"] != float64(exitCapacity) || e["kind"] != "capacity" {
+        t.Errorf("exit code %v, kind %v", e["exit_code"], e["kind"])
+    }
+    if e["message"] != "hiding data: "+capErr.Error() {
+        t.Errorf("message %q", e["message"])
+    }
+    capacity, ok := e["capacity"].(map[string]any)
+    if !ok || capacity["required"] != float64(100) || capacity["available"] != float64(40) {
+        t.Errorf("capacity %v", e["capacity"])
+    }
+    if _, ok := e["header"]; ok {
+        t.Error("a capacity error reported a header")
+    }
+
+    hdrErr := &steg.HeaderError{Offset: 4, Reason: "damaged", Err: steg.ErrMessageCorrupted}
+    e = decodeErrorReport(t, fail("reading header", hdrErr))["error"].(map[string]any)
+    if e["kind"] != "corrupted" {
+        t.Errorf("kind %v, want corrupted", e["kind"])
+    }
+    header, ok := e["header"].(map[string]any)
+    if !ok || header["offset"] != float64(4) || header["reason"] != "damaged" {
+        t.Errorf("header %v", e["header"])
+    }
+}
@@ -0,0 +1,249 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+    "fmt"
+    "image"
+
+    "github.com/Pranavjeet-Naidu/Mosquito/session"
+    "github.com/Pranavjeet-Naidu/Mosquito/steg"
+    "github.com/spf13/cobra"
+)
+
+// payloadFlags holds the flags shared by hide and send that choose the payload,
+// the steganography mode and how the payload is protected
+type payloadFlags struct {
+    text        string
+    file        string
+    contentType string
+    password    string
+    stealth     bool
+    cipher      string
+    kdfCost     int
+    hmacKey     string
+    session     string
+    shred       bool
+    mode        string
+}
+
+// addPayloadFlags adds the payload flags shared by hide and send
+func addPayloadFlags(cmd *cobra.Command, f *payloadFlags) {
+    cmd.Flags().StringVarP(&f.text, "message", "m", "", "Text message to hide")
+    cmd.Flags().StringVarP(&f.file, "file", "f", "", "File to hide (text, image or anything else), or - for stdin")
+    cmd.Flags().StringVar(&f.contentType, "content-type", "", "MIME type to record instead of the detected one")
+    cmd.Flags().StringVarP(&f.password, "password", "p", "", "Password for encrypting the payload")
+    cmd.Flags().BoolVar(&f.stealth, "stealth", false, "Encrypt the header too so no plaintext marker is left (requires -p)")
+    cmd.Flags().StringVar(&f.cipher, "cipher", "aes-gcm", "Cipher suite for encryption (aes-gcm, chacha20, xchacha20, aes-gcm-siv)")
+    addKDFCostFlag(cmd, f)
+    cmd.Flags().StringVar(&f.hmacKey, "hmac-key", "", "Shared secret for an HMAC-SHA256 integrity tag (payload stays unencrypted)")
+    cmd.Flags().StringVar(&f.session, "session", "", "Encrypt with the next key of an established session (see 'mosquito session')")
+    cmd.Flags().StringVar(&sessionDir, "session-dir", "", "Directory holding session state (default ~/.config/mosquito/sessions)")
+    cmd.Flags().BoolVar(&f.shred, "shred", false, "Overwrite and delete the file after it has been hidden")
+    cmd.Flags().StringVarP(&f.mode, "mode", "M", "0", modeFlagUsage())
+}
+
+// addKDFCostFlag adds --kdf-cost, the cost of stretching the password
+func addKDFCostFlag(cmd *cobra.Command, f *payloadFlags) {
+    cmd.Flags().IntVar(&f.kdfCost, "kdf-cost", steg.DefaultKDFCost, fmt.Sprintf("Cost of stretching the password with scrypt, %d to %d (each step doubles memory and time)", steg.MinKDFCost, steg.MaxKDFCost))
+}
+
+// addMaxKDFCostFlag adds --kdf-cost to a command that only decodes, where it
+// is the highest cost an image may ask for
+func addMaxKDFCostFlag(cmd *cobra.Command, cost *int) {
+    cmd.Flags().IntVar(cost, "kdf-cost", steg.DefaultKDFCost, fmt.Sprintf("Highest scrypt cost accepted from an image, %d to %d (each step doubles memory and time)", steg.MinKDFCost, steg.MaxKDFCost))
+}
+
+// checkKDFCost validates --kdf-cost
+func checkKDFCost(cost int) error {
+    if cost < steg.MinKDFCost || cost > steg.MaxKDFCost {
+        return usageError("--kdf-cost must be between %d and %d", steg.MinKDFCost, steg.MaxKDFCost)
+    }
+    return nil
+}
+
+// checkKDFCost validates --kdf-cost
+func (f *payloadFlags) checkKDFCost() error {
+    return checkKDFCost(f.kdfCost)
+}
+
+// check validates the payload flags before any work is done
+func (f *payloadFlags) check() error {
+    if f.text == "" && f.file == "" {
+        return usageError("either a message (-m) or a file (-f) must be provided")
+    }
+
+    if f.stealth && f.password == "" {
+        return usageError("stealth mode requires a password (-p)")
+    }
+
+    if f.hmacKey != "" && f.password != "" {
+        return usageError("--hmac-key cannot be combined with -p, authenticated payloads are stored unencrypted")
+    }
+
+    if f.session != "" && (f.password != "" || f.hmacKey != "") {
+        return usageError("--session cannot be combined with -p or --hmac-key, the session provides the keys")
+    }
+
+    if _, err := steg.ParseCipherSuite(f.cipher); err != nil {
+        return usageError("%v", err)
+    }
+
+    if err := f.checkKDFCost(); err != nil {
+        return err
+    }
+
+    if f.shred && (f.file == "" || f.file == stdioPath) {
+        return usageError("--shred requires a file to hide (-f)")
+    }
+    return nil
+}
+
+// read returns the payload and its content type, which is detected unless the
+// user gave one
+func (f *payloadFlags) read() ([]byte, string, error) {
+    var payload []byte
+    if f.file != "" {
+        var err error
+        payload, err = readInput(f.file)
+        if err != nil {
+            return nil, "", failWith(exitIO, "reading file to hide", err)
+        }
+    } else {
+        payload = []byte(f.text)
+    }
+
+    contentType := f.contentType
+    if contentType == "" {
+        if f.file != "" {
+            contentType = steg.DetectContentType(payload)
+        } else {
+            contentType = "text/plain; charset=utf-8"
+        }
+    }
+    return payload, contentType, nil
+}
+
+// protectedPayload is a payload ready to be embedded with opts. A session
+// payload is only encrypted by seal, once a cover that can hold it has been
+// chosen, so a cover that is never sent does not use up a message key.
+type protectedPayload struct {
+    data   []byte
+    opts   []steg.Option
+    notice string // Describes the protection, once sealed
+
+    store   *session.Store // Set for session payloads until they are sealed
+    session string
+    noun    string
+    report  *hideReport
+}
+
+// protect prepares payload for embedding. Anything but a session payload is
+// protected by the encoder with the options returned. The report is filled in
+// with what was done, and the notice describes it.
+func (f *payloadFlags) protect(payload []byte, contentType string, report *hideReport) (*protectedPayload, error) {
+    mode, err := parseModeFlag(f.mode)
+    if err != nil {
+        return nil, err
+    }
+    suite, err := steg.ParseCipherSuite(f.cipher)
+    if err != nil {
+        return nil, usageError("%v", err)
+    }
+
+    isImage := steg.IsImageType(contentType)
+    opts := []steg.Option{steg.WithMode(mode)}
+    if isImage {
+        opts = append(opts, steg.WithFlags(steg.FlagImage))
+    }
+    report.Mode, report.ModeName = mode, mode.String()
+    report.PayloadBytes = len(payload)
+    report.ContentType, report.Image = contentType, isImage
+    report.Protection = "none"
+
+    noun := payloadNoun(contentType)
+    if f.session != "" {
+        store, err := session.NewStore(sessionDir)
+        if err != nil {
+            return nil, failWith(exitIO, "opening session store", err)
+        }
+        // Fail before any cover is looked at, without advancing the chain
+        s, err := store.Load(f.session)
+        if err != nil {
+            return nil, fail("encrypting payload for session", err)
+        }
+        if s.State != session.StateEstablished {
+            return nil, fail("encrypting payload for session", fmt.Errorf("%w: %s", session.ErrNotEstablished, f.session))
+        }
+        // The type goes inside the session message so it is encrypted too
+        typed, err := steg.AddContentType(contentType, payload)
+        if err != nil {
+            return nil, fail("encoding payload", err)
+        }
+        opts = append(opts, steg.WithFlags(steg.FlagSession|steg.FlagTyped))
+        return &protectedPayload{data: typed, opts: opts, store: store, session: f.session, noun: noun, report: report}, nil
+    }
+
+    p := &protectedPayload{data: payload}
+    opts = append(opts, steg.WithContentType(contentType))
+    if f.hmacKey != "" {
+        opts = append(opts, steg.WithHMACKey(f.hmacKey))
+        p.notice = fmt.Sprintf("%s signed with HMAC-SHA256 (stored unencrypted)", noun)
+        report.Protection = "hmac"
+    } else if f.stealth {
+        opts = append(opts, steg.WithPassword(f.password), steg.WithCipher(suite), steg.WithKDFCost(f.kdfCost), steg.WithStealth())
+        p.notice = fmt.Sprintf("%s and header encrypted with provided password (stealth mode, %s)", noun, suite)
+        report.Protection, report.Cipher = "stealth", suite.String()
+    } else if f.password != "" {
+        opts = append(opts, steg.WithPassword(f.password), steg.WithCipher(suite), steg.WithKDFCost(f.kdfCost))
+        p.notice = fmt.Sprintf("%s encrypted with provided password (%s)", noun, suite)
+        report.Protection, report.Cipher = "password", suite.String()
+    }
+    p.opts = opts
+    return p, nil
+}
+
+// fits returns a *steg.CapacityError when cover cannot hold the payload, without
+// encoding it or sealing a session message
+func (p *protectedPayload) fits(cover image.Image) error {
+    size := len(p.data)
+    if p.store != nil {
+        size = session.MessageFrameSize(size)
+    }
+    return steg.NewEncoder(cover, p.opts...).CheckCapacity(size)
+}
+
+// seal encrypts a session payload with the next message key of the session.
+// Other payloads are left to the encoder.
+func (p *protectedPayload) seal() error {
+    if p.store == nil {
+        return nil
+    }
+    frame, counter, err := p.store.Seal(p.session, p.data)
+    if err != nil {
+        return fail("encrypting payload for session", err)
+    }
+    steg.Wipe(p.data)
+    p.data, p.store = frame, nil
+    p.notice = fmt.Sprintf("%s encrypted for session %s (message #%d)", p.noun, p.session, counter)
+    p.report.Protection, p.report.Session, p.report.Message = "session", p.session, &counter
+    return nil
+}
+
+// wipe clears the payload from memory
+func (p *protectedPayload) wipe() {
+    steg.Wipe(p.data)
+}
+
+// payloadNoun names a payload of the given type in messages
+func payloadNoun(contentType string) string {
+    switch {
+    case steg.IsImageType(contentType):
+        return "Image"
+    case steg.IsTextType(contentType):
+        return "Message"
+    }
+    return "File"
+}
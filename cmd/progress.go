@@ -0,0 +1,59 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+    "fmt"
+    "os"
+    "strings"
+
+    "github.com/Pranavjeet-Naidu/Mosquito/steg"
+)
+
+// progressBarWidth is the number of characters between the brackets
+const progressBarWidth = 30
+
+// newProgressBar returns a progress callback that draws a bar on stderr, or nil
+// when stderr is not a terminal. Operations that finish within their first report
+// never draw anything, so small payloads stay quiet.
+func newProgressBar(label string) steg.ProgressFunc {
+    if !isTerminal(os.Stderr) {
+        return nil
+    }
+
+    started := false
+    lastPercent := -1
+    return func(done, total int64) {
+        if total <= 0 {
+            return
+        }
+        if !started && done >= total {
+            return
+        }
+        started = true
+
+        percent := int(done * 100 / total)
+        if percent == lastPercent {
+            return
+        }
+        lastPercent = percent
+
+        filled := progressBarWidth * percent / 100
+        bar := strings.Repeat("=", filled) + strings.Repeat(" ", progressBarWidth-filled)
+        fmt.Fprintf(os.Stderr, "\r%s [%s] %3d%%", label, bar, percent)
+        if done >= total {
+            fmt.Fprintln(os.Stderr)
+        }
+    }
+}
+
+// isTerminal reports whether f is attached to a terminal
+func isTerminal(f *os.File) bool {
+    info, err := f.Stat()
+    if err != nil {
+        return false
+    }
+    return info.Mode()&os.ModeCharDevice != 0
+}
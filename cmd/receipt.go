@@ -0,0 +1,198 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+    "bytes"
+    "errors"
+    "fmt"
+    "image"
+    "sync"
+    "time"
+
+    "github.com/Pranavjeet-Naidu/Mosquito/mqtt"
+    "github.com/Pranavjeet-Naidu/Mosquito/session"
+    "github.com/Pranavjeet-Naidu/Mosquito/steg"
+)
+
+// Delivery as reported to the sender
+const (
+    deliveryDelivered = "delivered"
+    deliveryFailed    = "failed to decrypt"
+    deliveryNone      = "no response"
+)
+
+// receiptReport is what the sender learnt from waiting for a receipt, and its
+// JSON form
+type receiptReport struct {
+    Status        string     `json:"status"` // deliveryDelivered, deliveryFailed or deliveryNone
+    Receiver      string     `json:"receiver,omitempty"`
+    Reason        string     `json:"reason,omitempty"`
+    Timestamp     *time.Time `json:"timestamp,omitempty"`
+    Authenticated bool       `json:"authenticated"`
+}
+
+// receiptCollector picks the receipt for one message out of everything that
+// arrives on the reply topic. With a key, a receipt is only believed when its
+// tag checks out, whatever it reports: an untagged failure could be forged to
+// make the sender give up, or resend, just as easily as a delivery.
+type receiptCollector struct {
+    id  string
+    key string
+
+    mu     sync.Mutex
+    report *receiptReport
+}
+
+func (c *receiptCollector) accept(payload []byte) bool {
+    data, err := openReceipt(payload, c.key)
+    if err != nil {
+        return false
+    }
+    r, verified, err := mqtt.ParseReceipt(data, c.key)
+    if err != nil || r.ID != c.id {
+        return false
+    }
+    if c.key != "" && !verified {
+        return false
+    }
+
+    report := &receiptReport{Status: deliveryDelivered, Receiver: r.Receiver, Timestamp: &r.Timestamp, Authenticated: verified}
+    if r.Status == mqtt.ReceiptFailed {
+        report.Status, report.Reason = deliveryFailed, r.Reason
+    }
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.report == nil {
+        c.report = report
+    }
+    return true
+}
+
+// result returns the receipt, or a report of no response when none was taken
+func (c *receiptCollector) result() *receiptReport {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.report == nil {
+        return &receiptReport{Status: deliveryNone}
+    }
+    return c.report
+}
+
+// openReceipt returns the receipt in a reply, which is either the receipt itself
+// or an image hiding it, encrypted with the receipt key if there is one
+func openReceipt(payload []byte, key string) ([]byte, error) {
+    if mqtt.IsReceipt(payload) {
+        return payload, nil
+    }
+    _, body, err := mqtt.Open(payload)
+    if err != nil {
+        return nil, err
+    }
+    img, err := steg.ReadImage(bytes.NewReader(body))
+    if err != nil {
+        return nil, err
+    }
+    p, err := steg.NewDecoder(img, steg.WithPassword(key)).Decode()
+    if err != nil {
+        return nil, err
+    }
+    return p.Data, nil
+}
+
+// receiptSender answers the images mqttRecv extracts, when their sender asked
+type receiptSender struct {
+    receiver string      // Client ID of the receiver
+    key      string      // Secret to tag receipts with, may be empty
+    cover    image.Image // Image to hide receipts in, nil to send them as they are
+}
+
+// send publishes the receipt for an image on the reply topic of its envelope.
+// err is the result of extracting it. done is told whether the broker took the
+// receipt, see mqtt.Received.Reply.
+func (s *receiptSender) send(r mqtt.Received, err error, done func(error)) error {
+    receipt := mqtt.Receipt{ID: r.Metadata.ID, Status: mqtt.ReceiptDelivered, Receiver: s.receiver, Timestamp: time.Now().UTC()}
+    if err != nil {
+        receipt.Status, receipt.Reason = mqtt.ReceiptFailed, receiptReason(err)
+    }
+    payload, err := mqtt.MarshalReceipt(receipt, s.key)
+    if err != nil {
+        return err
+    }
+
+    if s.cover != nil {
+        var opts []steg.Option
+        if s.key != "" {
+            opts = append(opts, steg.WithPassword(s.key))
+        }
+        img, err := steg.NewEncoder(s.cover, opts...).EncodeBytes(payload)
+        if err != nil {
+            return err
+        }
+        var buf bytes.Buffer
+        if err := steg.WriteImage(&buf, img, "png"); err != nil {
+            return err
+        }
+        payload, err = mqtt.Seal(mqtt.Metadata{Sender: s.receiver, Timestamp: receipt.Timestamp}, buf.Bytes())
+        if err != nil {
+            return err
+        }
+    }
+    return r.Reply(payload, done)
+}
+
+// receiptReason describes a failed extraction to the sender, without the local
+// details in err
+func receiptReason(err error) string {
+    switch {
+    case errors.Is(err, errNoHiddenData):
+        return "no hidden data found"
+    case errors.Is(err, steg.ErrPasswordRequired), errors.Is(err, steg.ErrDecryptionFailed),
+        errors.Is(err, steg.ErrNoStealthPayload), errors.Is(err, session.ErrDecryptionFailed),
+        errors.Is(err, session.ErrMessageKeyGone):
+        return "could not decrypt the payload"
+    case errors.Is(err, steg.ErrAuthenticationFailed), errors.Is(err, steg.ErrNotAuthenticated):
+        return "integrity check failed"
+    }
+    return "could not extract the payload"
+}
+
+// printReceipt reports what came of waiting for a receipt
+func printReceipt(r *receiptReport, timeout time.Duration) {
+    var auth string
+    if r.Authenticated {
+        auth = " (authenticated)"
+    } else if r.Status != deliveryNone {
+        auth = " (not authenticated, give both sides the same --receipt-key)"
+    }
+
+    switch r.Status {
+    case deliveryDelivered:
+        fmt.Fprintf(out, "Receipt: delivered, extracted by %s at %s%s\n", receiverName(r), r.Timestamp.Local().Format(time.DateTime), auth)
+    case deliveryFailed:
+        fmt.Fprintf(out, "Receipt: failed to decrypt, %s reports: %s%s\n", receiverName(r), r.Reason, auth)
+    default:
+        fmt.Fprintf(out, "Receipt: no response within %s\n", timeout)
+    }
+}
+
+func receiverName(r *receiptReport) string {
+    if r.Receiver == "" {
+        return "an unnamed receiver"
+    }
+    return r.Receiver
+}
+
+// receiptError turns a receipt other than a delivery into the error the command
+// exits with. It has already been printed or written to the report.
+func receiptError(r *receiptReport) error {
+    switch {
+    case r == nil || r.Status == deliveryDelivered:
+        return nil
+    case r.Status == deliveryFailed:
+        return reported(failWith(exitAuth, "delivery", fmt.Errorf("the receiver could not extract the payload: %s", r.Reason)))
+    }
+    return reported(failWith(exitFailure, "delivery", mqtt.ErrNoReceipt))
+}
@@ -0,0 +1,44 @@
+package cmd
+
+import (
+    "testing"
+    "time"
+
+    "github.com/Pranavjeet-Naidu/Mosquito/mqtt"
+)
+
+func TestReceiptCollectorRequiresTag(t *testing.T) {
+    receipt := func(status, secret string) []byte {
+        t.Helper()
+        payload, err := mqtt.MarshalReceipt(mqtt.Receipt{ID: "m1", Status: status, Receiver: "r", Timestamp: time.Now().UTC()}, secret)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return payload
+    }
+
+    for _, tt := range []struct {
+        name    string
+        key     string
+        payload []byte
+        want    bool
+    }{
+        {"tagged delivery", "k", receipt(mqtt.ReceiptDelivered, "k"), true},
+        {"tagged failure", "k", receipt(mqtt.ReceiptFailed, "k"), true},
+        {"untagged delivery", "k", receipt(mqtt.ReceiptDelivered, ""), false},
+        {"untagged failure", "k", receipt(mqtt.ReceiptFailed, ""), false},
+        {"failure tagged with another key", "k", receipt(mqtt.ReceiptFailed, "other"), false},
+        {"no key", "", receipt(mqtt.ReceiptFailed, ""), true},
+    } {
+        c := &receiptCollector{id: "m1", key: tt.key}
+        if got := c.accept(tt.payload); got != tt.want {
+            t.Errorf("%s: accept = %v, want %v", tt.name, got, tt.want)
+        }
+    }
+}
+
+func TestMaskSettingReceiptKey(t *testing.T) {
+    if got := maskSetting("receipt-key", "shared secret"); got != "********" {
+        t.Errorf("receipt-key shown as %q", got)
+    }
+}
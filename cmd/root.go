@@ -5,7 +5,11 @@ Copyright © 2025 NAME HERE <EMAIL ADDRESS>
 package cmd
 
 import (
+    "context"
+    "fmt"
     "os"
+    "os/signal"
+    "syscall"
 
     "github.com/spf13/cobra"
 )
@@ -22,18 +26,55 @@ It allows you to:
 - Send and receive steganographic images via MQTT
 
 For detailed usage information, use the --help flag with any command.`,
+
+    // Flags left out on the command line are read from the config files and
+    // environment before anything else looks at them
+    PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+        if err := applyConfig(cmd); err != nil {
+            return err
+        }
+        return checkOutputFormat(cmd, args)
+    },
+
+    // Errors are printed by Execute, which also picks the exit status
+    SilenceErrors: true,
+    SilenceUsage:  true,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 // This is called by main.main(). It only needs to happen once to the rootCmd.
 func Execute() {
-    err := rootCmd.Execute()
+    // Ctrl+C cancels the running command so it can stop cleanly; a second Ctrl+C
+    // kills the process as usual
+    ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+    go func() {
+        <-ctx.Done()
+        stop()
+    }()
+
+    cmd, err := rootCmd.ExecuteContextC(ctx)
+    stop()
     if err != nil {
-        os.Exit(1)
+        if isReported(err) {
+            os.Exit(exitCode(err))
+        }
+        if jsonOutput() {
+            writeErrorReport(cmd, err)
+            os.Exit(exitCode(err))
+        }
+        printError(err)
+        if exitCode(err) == exitUsage {
+            fmt.Fprintf(os.Stderr, "Run '%s --help' for usage.\n", cmd.CommandPath())
+        }
+        os.Exit(exitCode(err))
     }
 }
 
 func init() {
     // Remove the toggle flag as it's not useful
     // rootCmd.Flags().BoolP("toggle", "t", false, "Help message for toggle")
+
+    // -o/--output already names the output file of most commands
+    rootCmd.PersistentFlags().StringVar(&outputFormat, "output-format", formatText, "Format of results and errors printed to stdout (text, json)")
+    rootCmd.PersistentFlags().StringVar(&configPath, "config", "", "Config file to use instead of .mosquito.yaml and ~/.config/mosquito/config.yaml")
 }
\ No newline at end of file
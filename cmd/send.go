@@ -0,0 +1,312 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+    "bufio"
+    "bytes"
+    "crypto/sha256"
+    "encoding/hex"
+    "errors"
+    "fmt"
+    "image"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/Pranavjeet-Naidu/Mosquito/steg"
+    "github.com/spf13/cobra"
+)
+
+// usedCoversFile records, in a cover directory, the SHA-256 of every cover that
+// has been sent, so a copy or renamed file is not used again either
+const usedCoversFile = ".mosquito-used"
+
+// coverExtensions are the files in a cover directory that are tried as covers
+var coverExtensions = map[string]bool{
+    ".png": true, ".bmp": true, ".tif": true, ".tiff": true,
+    ".jpg": true, ".jpeg": true, ".gif": true, ".webp": true,
+}
+
+var errCoversUsedUp = errors.New("every cover has been used, add new ones")
+
+var (
+    sendInputImage string
+    sendCoverDir   string
+    sendPayload    payloadFlags
+    sendPub        publishFlags
+)
+
+// sendReport is the JSON form of the send command
+type sendReport struct {
+    hideReport
+    Broker     string `json:"broker"`
+    Topic      string `json:"topic"`
+    MessageID  string `json:"message_id"`
+    Chunks     int    `json:"chunks,omitempty"`
+    CoversLeft *int   `json:"covers_left,omitempty"` // Unused covers left with --cover-dir
+
+    Receipt *receiptReport `json:"receipt,omitempty"` // With --wait-receipt
+}
+
+// sendCmd represents the send command
+var sendCmd = &cobra.Command{
+    Use:   "send",
+    Short: "Hide a payload and publish the image via MQTT in one step",
+    Long: `Hide a text message or file in a cover image and publish the result to an
+MQTT broker, without writing the image to disk. It takes the payload flags of
+'mosquito hide' and the broker flags of 'mosquito mqttSend'.
+
+With --cover-dir, the first cover in the directory that has not been sent yet
+and can hold the payload is used, so the same cover never carries two messages.
+Sent covers are recorded in ` + usedCoversFile + ` in that directory.
+
+Example:
+  mosquito send -i cover.png -m "Meet at 5pm" -p mypassword -b tcp://broker.example.com:1883 -t stego/images
+  mosquito send --cover-dir ./covers -f report.pdf --session 13b5b8de4b0aa6176fef8a769ed72d10 -b tcp://broker.example.com:1883 -t stego/images
+  mosquito send --cover-dir ./covers -m "hi" -q 1 -b ssl://broker.example.com:8883 --tls-ca ca.pem -t stego/images
+  mosquito send -i cover.png -m "Meet at 5pm" -p mypassword --wait-receipt 30s --receipt-key "shared secret" -b tcp://broker.example.com:1883 -t stego/images`,
+    RunE: func(cmd *cobra.Command, args []string) error {
+        if sendPub.broker == "" || sendPub.topic == "" {
+            return usageError("broker URL and topic are required")
+        }
+        if (sendInputImage == "") == (sendCoverDir == "") {
+            return usageError("give either a cover image (-i) or a cover directory (--cover-dir)")
+        }
+        if err := sendPayload.check(); err != nil {
+            return err
+        }
+        if err := checkStdin(sendInputImage, sendPayload.file); err != nil {
+            return err
+        }
+        pubOpts, err := sendPub.options(cmd)
+        if err != nil {
+            return err
+        }
+
+        covers := []string{sendInputImage}
+        var used *usedCovers
+        if sendCoverDir != "" {
+            used, err = loadUsedCovers(sendCoverDir)
+            if err != nil {
+                return failWith(exitIO, "reading cover directory", err)
+            }
+            covers, err = used.unused()
+            if err != nil {
+                return failWith(exitIO, "reading cover directory", err)
+            }
+            if len(covers) == 0 {
+                return failWith(exitCapacity, "choosing cover", fmt.Errorf("%s: %w", sendCoverDir, errCoversUsedUp))
+            }
+        }
+
+        payload, contentType, err := sendPayload.read()
+        if err != nil {
+            return err
+        }
+        defer steg.Wipe(payload)
+
+        report := sendReport{
+            hideReport: hideReport{reportMeta: newReportMeta(cmd)},
+            Broker:     sendPub.broker,
+            Topic:      sendPub.topic,
+        }
+        protected, err := sendPayload.protect(payload, contentType, &report.hideReport)
+        if err != nil {
+            return err
+        }
+        defer protected.wipe()
+
+        // Covers too small for the payload are skipped, and stay unused. They
+        // are only measured, so the password is stretched and a session key
+        // used up just once, for the cover that is sent.
+        var cover string
+        var coverImg image.Image
+        for _, path := range covers {
+            img, err := loadImage(path)
+            if err != nil {
+                return failWith(exitIO, "loading image", err)
+            }
+            err = protected.fits(img)
+            if errors.Is(err, steg.ErrImageTooSmall) && len(covers) > 1 {
+                continue
+            }
+            if err != nil {
+                return fail("encoding payload", err)
+            }
+            cover, coverImg = path, img
+            break
+        }
+        if coverImg == nil {
+            return fail("encoding payload", fmt.Errorf("%w: none of the %d unused covers in %s can hold %d bytes", steg.ErrImageTooSmall, len(covers), sendCoverDir, len(payload)))
+        }
+
+        if err := protected.seal(); err != nil {
+            return err
+        }
+        opts := append(protected.opts, steg.WithProgress(newProgressBar("Embedding")))
+        stego, err := steg.NewEncoder(coverImg, opts...).EncodeBytesContext(cmd.Context(), protected.data)
+        if err != nil {
+            return fail("encoding payload", err)
+        }
+        encoded := &bytes.Buffer{}
+        if err := steg.WriteImage(encoded, stego, "png"); err != nil {
+            return fail("encoding image", err)
+        }
+        report.Input = cover
+        report.Difference.Percent = steg.MeasureImageDifference(coverImg, stego) * 100
+
+        // Name the image after the cover, as the PNG it now is
+        var filename string
+        if cover != stdioPath {
+            filename = strings.TrimSuffix(filepath.Base(cover), filepath.Ext(cover)) + ".png"
+        }
+        if err := cmd.Context().Err(); err != nil {
+            return err
+        }
+        p, err := sendPub.publish(pubOpts, encoded.Bytes(), filename)
+        if err != nil {
+            return err
+        }
+        report.MessageID, report.Chunks, report.Receipt = p.id, p.chunks, p.receipt
+
+        // The image is out, so from here on failures must not hide that
+        var result error
+        if used != nil {
+            if err := used.add(cover); err != nil {
+                result = failWith(exitIO, "recording used cover", err)
+            } else {
+                left := len(covers) - 1
+                report.CoversLeft = &left
+            }
+        }
+        if result == nil {
+            result = receiptError(p.receipt)
+        }
+        if sendPayload.shred && result == nil {
+            if err := steg.ShredFile(sendPayload.file); err != nil {
+                result = failWith(exitIO, "shredding file", err)
+            } else {
+                report.Shredded = true
+            }
+        }
+
+        if jsonOutput() {
+            if err := writeReport(report); err != nil {
+                return err
+            }
+            return reported(result)
+        }
+        if protected.notice != "" {
+            fmt.Fprintln(out, protected.notice)
+        }
+        fmt.Fprintf(out, "%s (%s) hidden in %s using %s\n", payloadNoun(contentType), contentType, displayPath(cover), steg.ModeNames[report.Mode])
+        sendPub.printPublished(p)
+        if report.Shredded {
+            fmt.Fprintf(out, "File %s overwritten and deleted\n", sendPayload.file)
+        }
+        if report.CoversLeft != nil {
+            fmt.Fprintf(out, "Unused covers left in %s: %d\n", sendCoverDir, *report.CoversLeft)
+        }
+        return result
+    },
+}
+
+// usedCovers tracks which covers of a cover directory have been sent
+type usedCovers struct {
+    dir    string
+    hashes map[string]bool
+}
+
+func loadUsedCovers(dir string) (*usedCovers, error) {
+    u := &usedCovers{dir: dir, hashes: map[string]bool{}}
+    f, err := os.Open(filepath.Join(dir, usedCoversFile))
+    if errors.Is(err, fs.ErrNotExist) {
+        return u, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    defer f.Close()
+
+    scanner := bufio.NewScanner(f)
+    for scanner.Scan() {
+        if line := strings.TrimSpace(scanner.Text()); line != "" {
+            u.hashes[line] = true
+        }
+    }
+    return u, scanner.Err()
+}
+
+// unused returns the covers in the directory that have not been sent, sorted by
+// name
+func (u *usedCovers) unused() ([]string, error) {
+    entries, err := os.ReadDir(u.dir)
+    if err != nil {
+        return nil, err
+    }
+
+    var covers []string
+    seen := map[string]bool{}
+    for _, e := range entries {
+        name := e.Name()
+        if !e.Type().IsRegular() || strings.HasPrefix(name, ".") || !coverExtensions[strings.ToLower(filepath.Ext(name))] {
+            continue
+        }
+        path := filepath.Join(u.dir, name)
+        sum, err := fileHash(path)
+        if err != nil {
+            return nil, err
+        }
+        // Copies of one cover count as one
+        if !u.hashes[sum] && !seen[sum] {
+            covers = append(covers, path)
+        }
+        seen[sum] = true
+    }
+    return covers, nil
+}
+
+// add records a cover as sent
+func (u *usedCovers) add(path string) error {
+    sum, err := fileHash(path)
+    if err != nil {
+        return err
+    }
+    f, err := os.OpenFile(filepath.Join(u.dir, usedCoversFile), os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+    if err != nil {
+        return err
+    }
+    if _, err := fmt.Fprintln(f, sum); err != nil {
+        f.Close()
+        return err
+    }
+    u.hashes[sum] = true
+    return f.Close()
+}
+
+func fileHash(path string) (string, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return "", err
+    }
+    sum := sha256.Sum256(data)
+    return hex.EncodeToString(sum[:]), nil
+}
+
+func init() {
+    rootCmd.AddCommand(sendCmd)
+
+    // Add flags
+    sendCmd.Flags().StringVarP(&sendInputImage, "input", "i", "", "Cover image path, or - for stdin")
+    sendCmd.Flags().StringVar(&sendCoverDir, "cover-dir", "", "Directory of cover images to use each only once, instead of -i")
+    addPayloadFlags(sendCmd, &sendPayload)
+    addPublishFlags(sendCmd, &sendPub)
+
+    // Mark required flags
+    sendCmd.MarkFlagRequired("broker")
+    sendCmd.MarkFlagRequired("topic")
+}
@@ -0,0 +1,313 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+    "errors"
+    "fmt"
+    "image"
+
+    "github.com/Pranavjeet-Naidu/Mosquito/session"
+    "github.com/Pranavjeet-Naidu/Mosquito/steg"
+    "github.com/spf13/cobra"
+)
+
+// Errors for images that hold the wrong kind of session frame
+var (
+    errNoSessionFrame = errors.New("the image does not contain a session frame")
+    errHandshakeFrame = errors.New("the image holds a session handshake, not a message")
+)
+
+var (
+    sessionDir string
+
+    sessionInitCover  string
+    sessionInitOutput string
+    sessionInitPeer   string
+    sessionInitMode   string
+
+    sessionAcceptInput    string
+    sessionAcceptCover    string
+    sessionAcceptOutput   string
+    sessionAcceptPassword string
+    sessionAcceptPeer     string
+    sessionAcceptMode     string
+
+    sessionCompleteInput    string
+    sessionCompletePassword string
+)
+
+// sessionCmd represents the session command
+var sessionCmd = &cobra.Command{
+    Use:   "session",
+    Short: "Manage forward-secret sessions for ongoing conversations",
+    Long: `Manage forward-secret sessions for ongoing conversations.
+
+A session starts with an X25519 handshake carried in two stego images. After
+that, every message is encrypted with a fresh key from a ratcheting chain, and
+used keys are deleted, so compromising the stored state later does not expose
+earlier messages. Session state is kept in ~/.config/mosquito/sessions.
+
+Example:
+  # Alice starts a session and sends handshake.png to Bob
+  mosquito session init -i cover.png -o handshake.png --peer bob
+
+  # Bob answers with reply.png
+  mosquito session accept -i handshake.png -c cover2.png -o reply.png --peer alice
+
+  # Alice completes the handshake
+  mosquito session complete -i reply.png
+
+  # Either side can now send messages
+  mosquito hide -i cover3.png -o msg.png -m "hello" --session <id>
+  mosquito extract -i msg.png -t`,
+}
+
+// sessionInitCmd starts a handshake
+var sessionInitCmd = &cobra.Command{
+    Use:   "init",
+    Short: "Start a new session and write the handshake image",
+    RunE: func(cmd *cobra.Command, args []string) error {
+        mode, err := parseModeFlag(sessionInitMode)
+        if err != nil {
+            return err
+        }
+
+        img, err := steg.LoadImage(sessionInitCover)
+        if err != nil {
+            return failWith(exitIO, "loading image", err)
+        }
+
+        store, err := session.NewStore(sessionDir)
+        if err != nil {
+            return failWith(exitIO, "opening session store", err)
+        }
+
+        s, frame, err := store.Initiate(sessionInitPeer)
+        if err != nil {
+            return failWith(exitIO, "starting session", err)
+        }
+
+        if err := saveSessionImage(img, frame, mode, sessionInitOutput); err != nil {
+            store.Delete(s.ID)
+            return err
+        }
+
+        fmt.Fprintf(out, "Session %s started, handshake written to %s\n", s.ID, sessionInitOutput)
+        fmt.Fprintln(out, "Send it to your peer and run 'mosquito session complete' on their reply")
+        return nil
+    },
+}
+
+// sessionAcceptCmd answers a handshake
+var sessionAcceptCmd = &cobra.Command{
+    Use:   "accept",
+    Short: "Accept a handshake image and write the reply image",
+    RunE: func(cmd *cobra.Command, args []string) error {
+        mode, err := parseModeFlag(sessionAcceptMode)
+        if err != nil {
+            return err
+        }
+
+        frame, err := loadSessionFrame(sessionAcceptInput)
+        if err != nil {
+            return err
+        }
+
+        cover, err := steg.LoadImage(sessionAcceptCover)
+        if err != nil {
+            return failWith(exitIO, "loading cover image", err)
+        }
+
+        store, err := session.NewStore(sessionDir)
+        if err != nil {
+            return failWith(exitIO, "opening session store", err)
+        }
+
+        s, reply, err := store.Accept(frame, sessionAcceptPassword, sessionAcceptPeer)
+        if err != nil {
+            return fail("accepting session", err)
+        }
+
+        if err := saveSessionImage(cover, reply, mode, sessionAcceptOutput); err != nil {
+            store.Delete(s.ID)
+            return err
+        }
+
+        fmt.Fprintf(out, "Session %s established, reply written to %s\n", s.ID, sessionAcceptOutput)
+        return nil
+    },
+}
+
+// sessionCompleteCmd finishes a handshake on the initiator side
+var sessionCompleteCmd = &cobra.Command{
+    Use:   "complete",
+    Short: "Complete a session using the peer's reply image",
+    RunE: func(cmd *cobra.Command, args []string) error {
+        frame, err := loadSessionFrame(sessionCompleteInput)
+        if err != nil {
+            return err
+        }
+
+        store, err := session.NewStore(sessionDir)
+        if err != nil {
+            return failWith(exitIO, "opening session store", err)
+        }
+
+        s, err := store.Complete(frame, sessionCompletePassword)
+        if err != nil {
+            return fail("completing session", err)
+        }
+
+        fmt.Fprintf(out, "Session %s established\n", s.ID)
+        return nil
+    },
+}
+
+// sessionListCmd lists stored sessions
+var sessionListCmd = &cobra.Command{
+    Use:   "list",
+    Short: "List stored sessions",
+    RunE: func(cmd *cobra.Command, args []string) error {
+        store, err := session.NewStore(sessionDir)
+        if err != nil {
+            return failWith(exitIO, "opening session store", err)
+        }
+
+        sessions, err := store.List()
+        if err != nil {
+            return failWith(exitIO, "listing sessions", err)
+        }
+        if len(sessions) == 0 {
+            fmt.Fprintln(out, "No sessions")
+            return nil
+        }
+
+        for _, s := range sessions {
+            peer := s.Peer
+            if peer == "" {
+                peer = "-"
+            }
+            fmt.Fprintf(out, "%s  %-11s  peer: %-12s  sent: %d  received: %d\n",
+                s.ID, s.State, peer, s.SendCounter, s.RecvCounter)
+        }
+        return nil
+    },
+}
+
+// sessionDeleteCmd deletes a session
+var sessionDeleteCmd = &cobra.Command{
+    Use:   "delete <session-id>",
+    Short: "Delete a stored session and its keys",
+    Args:  cobra.ExactArgs(1),
+    RunE: func(cmd *cobra.Command, args []string) error {
+        store, err := session.NewStore(sessionDir)
+        if err != nil {
+            return failWith(exitIO, "opening session store", err)
+        }
+
+        if err := store.Delete(args[0]); err != nil {
+            return fail("deleting session", err)
+        }
+        fmt.Fprintf(out, "Session %s deleted\n", args[0])
+        return nil
+    },
+}
+
+// loadSessionFrame reads the session frame hidden in an image
+func loadSessionFrame(path string) ([]byte, error) {
+    img, err := steg.LoadImage(path)
+    if err != nil {
+        return nil, failWith(exitIO, "loading image", err)
+    }
+
+    header, err := steg.GetImageInfo(img)
+    if err != nil || !header.IsSession() {
+        return nil, failWith(exitNoData, "", errNoSessionFrame)
+    }
+
+    frame, err := steg.DecodeMessage(img)
+    if err != nil {
+        return nil, fail("extracting data", err)
+    }
+    return frame, nil
+}
+
+// openSessionFrame decrypts a session message frame with the stored session keys,
+// returning the session it belongs to and the message number. Handshake frames
+// are not messages, so it explains how to answer them instead.
+func openSessionFrame(path string, frame []byte) ([]byte, *session.Session, uint32, error) {
+    f, err := session.ParseFrame(frame)
+    if err != nil {
+        return nil, nil, 0, fail("reading session frame", err)
+    }
+
+    switch f.Type {
+    case session.FrameInit:
+        if !jsonOutput() {
+            fmt.Fprintf(out, "This image starts session %s. Accept it with:\n", f.ID)
+            fmt.Fprintf(out, "  mosquito session accept -i %s -c <cover> -o <reply>\n", path)
+        }
+        return nil, nil, 0, failWith(exitNoData, "", errHandshakeFrame)
+    case session.FrameAccept:
+        if !jsonOutput() {
+            fmt.Fprintf(out, "This image answers session %s. Complete it with:\n", f.ID)
+            fmt.Fprintf(out, "  mosquito session complete -i %s\n", path)
+        }
+        return nil, nil, 0, failWith(exitNoData, "", errHandshakeFrame)
+    }
+
+    store, err := session.NewStore(sessionDir)
+    if err != nil {
+        return nil, nil, 0, failWith(exitIO, "opening session store", err)
+    }
+
+    s, counter, plaintext, err := store.Open(frame)
+    if err != nil {
+        return nil, nil, 0, fail("decrypting session message", err)
+    }
+    return plaintext, s, counter, nil
+}
+
+// saveSessionImage hides a session frame in a cover image and saves it
+func saveSessionImage(cover image.Image, frame []byte, mode steg.StegMode, path string) error {
+    encoded, err := steg.EncodeMessageWithFlags(cover, frame, mode, steg.FlagSession)
+    if err != nil {
+        return fail("encoding session frame", err)
+    }
+
+    if err := steg.SaveImage(encoded, path); err != nil {
+        return failWith(exitIO, "saving image", err)
+    }
+    return nil
+}
+
+func init() {
+    rootCmd.AddCommand(sessionCmd)
+    sessionCmd.AddCommand(sessionInitCmd, sessionAcceptCmd, sessionCompleteCmd, sessionListCmd, sessionDeleteCmd)
+
+    sessionCmd.PersistentFlags().StringVar(&sessionDir, "session-dir", "", "Directory holding session state (default ~/.config/mosquito/sessions)")
+
+    sessionInitCmd.Flags().StringVarP(&sessionInitCover, "input", "i", "", "Cover image path (required)")
+    sessionInitCmd.Flags().StringVarP(&sessionInitOutput, "output", "o", "", "Output handshake image path (required)")
+    sessionInitCmd.Flags().StringVar(&sessionInitPeer, "peer", "", "Name of the peer, for your own reference")
+    sessionInitCmd.Flags().StringVarP(&sessionInitMode, "mode", "M", "0", modeFlagUsage())
+    sessionInitCmd.MarkFlagRequired("input")
+    sessionInitCmd.MarkFlagRequired("output")
+
+    sessionAcceptCmd.Flags().StringVarP(&sessionAcceptInput, "input", "i", "", "Received handshake image (required)")
+    sessionAcceptCmd.Flags().StringVarP(&sessionAcceptCover, "cover", "c", "", "Cover image for the reply (required)")
+    sessionAcceptCmd.Flags().StringVarP(&sessionAcceptOutput, "output", "o", "", "Output reply image path (required)")
+    sessionAcceptCmd.Flags().StringVarP(&sessionAcceptPassword, "password", "p", "", "Pre-shared password to authenticate the handshake")
+    sessionAcceptCmd.Flags().StringVar(&sessionAcceptPeer, "peer", "", "Name of the peer, for your own reference")
+    sessionAcceptCmd.Flags().StringVarP(&sessionAcceptMode, "mode", "M", "0", modeFlagUsage())
+    sessionAcceptCmd.MarkFlagRequired("input")
+    sessionAcceptCmd.MarkFlagRequired("cover")
+    sessionAcceptCmd.MarkFlagRequired("output")
+
+    sessionCompleteCmd.Flags().StringVarP(&sessionCompleteInput, "input", "i", "", "Received reply image (required)")
+    sessionCompleteCmd.Flags().StringVarP(&sessionCompletePassword, "password", "p", "", "Pre-shared password used by the peer")
+    sessionCompleteCmd.MarkFlagRequired("input")
+}
@@ -0,0 +1,153 @@
+/*
+Copyright © 2025 NAME HERE <EMAIL ADDRESS>
+
+*/
+package cmd
+
+import (
+    "bufio"
+    "context"
+    "image"
+    "io"
+    "os"
+    "path/filepath"
+    "strings"
+
+    "github.com/Pranavjeet-Naidu/Mosquito/steg"
+)
+
+// stdioPath is the path that stands for stdin or stdout
+const stdioPath = "-"
+
+// out receives the results and messages of a command. Commands that write an
+// image or payload to stdout switch it to stderr so the two never mix.
+var out io.Writer = os.Stdout
+
+// reserveStdout keeps stdout for data written by the command
+func reserveStdout() {
+    out = os.Stderr
+}
+
+// displayPath names a path in messages
+func displayPath(path string) string {
+    if path == stdioPath {
+        return "stdout"
+    }
+    return path
+}
+
+// checkStdin fails when more than one of the given paths reads from stdin
+func checkStdin(paths ...string) error {
+    n := 0
+    for _, path := range paths {
+        if path == stdioPath {
+            n++
+        }
+    }
+    if n > 1 {
+        return usageError("only one input can be read from stdin (-)")
+    }
+    return nil
+}
+
+// loadImage loads an image from a file, or from stdin for "-"
+func loadImage(path string) (image.Image, error) {
+    if path == stdioPath {
+        return steg.ReadImage(bufio.NewReader(os.Stdin))
+    }
+    return steg.LoadImage(path)
+}
+
+// readInput reads a whole file, or stdin for "-"
+func readInput(path string) ([]byte, error) {
+    if path == stdioPath {
+        return io.ReadAll(os.Stdin)
+    }
+    return os.ReadFile(path)
+}
+
+// readSecretFile reads a password or key kept in a file, such as the broker
+// password. what names it in errors.
+func readSecretFile(path, what string) (string, error) {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return "", failWith(exitIO, "reading "+what, err)
+    }
+    // Files written by editors and echo end with a newline
+    secret := strings.TrimRight(string(data), "\r\n")
+    if secret == "" {
+        return "", usageError("%s file %s is empty", what, path)
+    }
+    return secret, nil
+}
+
+// saveImage saves an image to a file, or writes it to stdout for "-". An empty
+// format picks one from the file extension, or png on stdout.
+func saveImage(ctx context.Context, img image.Image, path, format string) error {
+    if path != stdioPath {
+        return steg.SaveImageAs(ctx, img, path, format)
+    }
+
+    if format == "" {
+        format = "png"
+    }
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    w := bufio.NewWriter(os.Stdout)
+    if err := steg.WriteImage(w, img, format); err != nil {
+        return err
+    }
+    return w.Flush()
+}
+
+// writeOutput writes extracted data to a file readable only by the owner, or to
+// stdout for "-". An existing file is replaced rather than written over, since
+// it would keep its mode and could leave the data readable by others.
+func writeOutput(path string, data []byte) error {
+    if path == stdioPath {
+        _, err := os.Stdout.Write(data)
+        return err
+    }
+
+    // Devices and pipes such as /dev/null are written to, not replaced
+    if info, err := os.Stat(path); err == nil && !info.Mode().IsRegular() {
+        return os.WriteFile(path, data, 0600)
+    }
+
+    // CreateTemp makes the file with mode 0600 in the same directory, so the
+    // rename cannot cross filesystems
+    f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+    if err != nil {
+        return err
+    }
+    tmp := f.Name()
+    if _, err := f.Write(data); err != nil {
+        f.Close()
+        os.Remove(tmp)
+        return err
+    }
+    if err := f.Close(); err != nil {
+        os.Remove(tmp)
+        return err
+    }
+    if err := os.Rename(tmp, path); err != nil {
+        os.Remove(tmp)
+        return err
+    }
+    return nil
+}
+
+// checkImageOutput validates --format before any work is done, and keeps stdout
+// for the image when it is written there
+func checkImageOutput(path, format string) error {
+    if format != "" {
+        if err := steg.CheckFormat(format); err != nil {
+            return usageError("%v (expected png, bmp or tiff)", err)
+        }
+    }
+    if path == stdioPath {
+        reserveStdout()
+    }
+    return nil
+}
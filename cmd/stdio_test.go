@@ -0,0 +1,46 @@
+package cmd
+
+import (
+    "os"
+    "path/filepath"
+    "testing"
+)
+
+func TestWriteOutputReplacesReadableFile(t *testing.T) {
+    dir := t.TempDir()
+    path := filepath.Join(dir, "secret.txt")
+    if err := os.WriteFile(path, []byte("an older, longer file"), 0644); err != nil {
+        t.Fatal(err)
+    }
+    if err := os.Chmod(path, 0644); err != nil {
+        t.Fatal(err)
+    }
+
+    if err := writeOutput(path, []byte("extracted")); err != nil {
+        t.Fatalf("writeOutput: %v", err)
+    }
+    info, err := os.Stat(path)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if mode := info.Mode().Perm(); mode != 0600 {
+        t.Errorf("mode %v, want 0600", mode)
+    }
+    if data, _ := os.ReadFile(path); string(data) != "extracted" {
+        t.Errorf("file holds %q", data)
+    }
+
+    // No temporary file is left behind
+    if entries, _ := os.ReadDir(dir); len(entries) != 1 {
+        t.Errorf("%d files in the directory, want 1", len(entries))
+    }
+}
+
+func TestWriteOutputToDevice(t *testing.T) {
+    if _, err := os.Stat(os.DevNull); err != nil {
+        t.Skip(err)
+    }
+    if err := writeOutput(os.DevNull, []byte("discarded")); err != nil {
+        t.Errorf("writeOutput(%s): %v", os.DevNull, err)
+    }
+}
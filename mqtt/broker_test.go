@@ -0,0 +1,194 @@
+package mqtt
+
+import (
+    "crypto/tls"
+    "net"
+    "strings"
+    "sync"
+    "testing"
+
+    "github.com/eclipse/paho.mqtt.golang/packets"
+)
+
+// testBroker is a minimal in-process MQTT 3.1.1 broker: it accepts every
+// client, keeps subscriptions per connection only, and forwards messages at QoS
+// 0. It can be stopped and started again on the same address.
+type testBroker struct {
+    t    *testing.T
+    addr string
+    tls  *tls.Config // Serve TLS with this configuration, if not nil
+
+    // Topic whose QoS 1 messages are forwarded but never acknowledged, as by a
+    // broker that has stalled
+    withholdAcks string
+
+    mu    sync.Mutex
+    ln    net.Listener
+    conns map[*brokerConn]bool
+}
+
+type brokerConn struct {
+    net.Conn
+    mu      sync.Mutex // Serialises writes
+    filters map[string]bool
+}
+
+func startTestBroker(t *testing.T) *testBroker {
+    return startTLSTestBroker(t, nil)
+}
+
+// startTLSTestBroker starts a broker that serves TLS with cfg, or plain TCP if
+// cfg is nil
+func startTLSTestBroker(t *testing.T, cfg *tls.Config) *testBroker {
+    t.Helper()
+    b := &testBroker{t: t, addr: "127.0.0.1:0", tls: cfg, conns: map[*brokerConn]bool{}}
+    b.start()
+    t.Cleanup(b.stop)
+    return b
+}
+
+// URL returns the broker address for paho
+func (b *testBroker) URL() string {
+    if b.tls != nil {
+        return "ssl://" + b.addr
+    }
+    return "tcp://" + b.addr
+}
+
+func (b *testBroker) start() {
+    b.t.Helper()
+    var ln net.Listener
+    var err error
+    if b.tls != nil {
+        ln, err = tls.Listen("tcp", b.addr, b.tls)
+    } else {
+        ln, err = net.Listen("tcp", b.addr)
+    }
+    if err != nil {
+        b.t.Fatalf("starting broker: %v", err)
+    }
+    b.mu.Lock()
+    b.ln, b.addr = ln, ln.Addr().String()
+    b.mu.Unlock()
+    go b.accept(ln)
+}
+
+// stop closes the listener and drops every client, as a broker restart would
+func (b *testBroker) stop() {
+    b.mu.Lock()
+    defer b.mu.Unlock()
+    if b.ln != nil {
+        b.ln.Close()
+        b.ln = nil
+    }
+    for c := range b.conns {
+        c.Close()
+        delete(b.conns, c)
+    }
+}
+
+func (b *testBroker) accept(ln net.Listener) {
+    for {
+        nc, err := ln.Accept()
+        if err != nil {
+            return
+        }
+        c := &brokerConn{Conn: nc, filters: map[string]bool{}}
+        b.mu.Lock()
+        b.conns[c] = true
+        b.mu.Unlock()
+        go b.serve(c)
+    }
+}
+
+func (b *testBroker) serve(c *brokerConn) {
+    defer func() {
+        c.Close()
+        b.mu.Lock()
+        delete(b.conns, c)
+        b.mu.Unlock()
+    }()
+
+    for {
+        p, err := packets.ReadPacket(c)
+        if err != nil {
+            return
+        }
+        switch p := p.(type) {
+        case *packets.ConnectPacket:
+            c.send(packets.NewControlPacket(packets.Connack))
+        case *packets.SubscribePacket:
+            ack := packets.NewControlPacket(packets.Suback).(*packets.SubackPacket)
+            ack.MessageID = p.MessageID
+            b.mu.Lock()
+            for i, topic := range p.Topics {
+                c.filters[topic] = true
+                ack.ReturnCodes = append(ack.ReturnCodes, min(p.Qoss[i], 1))
+            }
+            b.mu.Unlock()
+            c.send(ack)
+        case *packets.UnsubscribePacket:
+            b.mu.Lock()
+            for _, topic := range p.Topics {
+                delete(c.filters, topic)
+            }
+            b.mu.Unlock()
+            ack := packets.NewControlPacket(packets.Unsuback).(*packets.UnsubackPacket)
+            ack.MessageID = p.MessageID
+            c.send(ack)
+        case *packets.PublishPacket:
+            if p.Qos > 0 && p.TopicName != b.withholdAcks {
+                ack := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+                ack.MessageID = p.MessageID
+                c.send(ack)
+            }
+            b.forward(p.TopicName, p.Payload)
+        case *packets.PingreqPacket:
+            c.send(packets.NewControlPacket(packets.Pingresp))
+        case *packets.DisconnectPacket:
+            return
+        }
+    }
+}
+
+// forward delivers a message to every client subscribed to its topic
+func (b *testBroker) forward(topic string, payload []byte) {
+    b.mu.Lock()
+    var to []*brokerConn
+    for c := range b.conns {
+        for filter := range c.filters {
+            if topicMatches(filter, topic) {
+                to = append(to, c)
+                break
+            }
+        }
+    }
+    b.mu.Unlock()
+
+    for _, c := range to {
+        p := packets.NewControlPacket(packets.Publish).(*packets.PublishPacket)
+        p.TopicName, p.Payload = topic, payload
+        c.send(p)
+    }
+}
+
+func (c *brokerConn) send(p packets.ControlPacket) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    p.Write(c)
+}
+
+// topicMatches reports whether an MQTT topic filter, with + and # wildcards,
+// matches topic
+func topicMatches(filter, topic string) bool {
+    f, t := strings.Split(filter, "/"), strings.Split(topic, "/")
+    for i, part := range f {
+        if part == "#" {
+            return true
+        }
+        if i >= len(t) || (part != "+" && part != t[i]) {
+            return false
+        }
+    }
+    return len(f) == len(t)
+}
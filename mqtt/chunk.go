@@ -0,0 +1,230 @@
+package mqtt
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/hex"
+    "fmt"
+)
+
+// Images larger than the chunk size are published as a series of chunk messages
+// followed by a manifest, so they fit brokers that limit the packet size. Every
+// message starts with the same prefix:
+//
+// Prefix:   [magic(4) | version(1) | kind(1) | transfer ID(16)]
+// Chunk:    [prefix | index(4) | count(4) | SHA-256 of data(32) | data]
+// Manifest: [prefix | count(4) | size(8) | chunk size(4) | SHA-256 of image(32)]
+// Resend:   [prefix | n(2) | n × index(4)]
+//
+// Resend requests go to the control topic, see ControlTopic. The transfer ID is
+// derived from the image, so sending the same image again resumes a transfer the
+// receiver only got part of.
+
+const (
+    chunkVersion    = 1
+    chunkPrefixLen  = 4 + 1 + 1 + TransferIDLen
+    chunkHeaderLen  = chunkPrefixLen + 4 + 4 + sha256.Size
+    manifestLen     = chunkPrefixLen + 4 + 8 + 4 + sha256.Size
+    maxResendChunks = 0xffff
+
+    // maxChunkCount is the most chunks a transfer within maxTransferSize can
+    // have, with the smallest chunks allowed
+    maxChunkCount = maxTransferSize / (MinChunkSize - chunkHeaderLen)
+
+    // TransferIDLen is the length of a transfer ID in bytes
+    TransferIDLen = 16
+
+    // MinChunkSize is the smallest chunk message size accepted by WithChunkSize
+    MinChunkSize = 1024
+)
+
+var chunkMagic = []byte("MQCH")
+
+// Kinds of chunk protocol messages
+const (
+    kindChunk    byte = 1
+    kindManifest byte = 2
+    kindResend   byte = 3
+)
+
+// TransferID identifies a chunked transfer
+type TransferID [TransferIDLen]byte
+
+func (id TransferID) String() string {
+    return hex.EncodeToString(id[:])
+}
+
+// newTransferID derives the transfer ID of an image from its hash
+func newTransferID(sum [sha256.Size]byte) TransferID {
+    var id TransferID
+    copy(id[:], sum[:])
+    return id
+}
+
+// chunk is one piece of a chunked image
+type chunk struct {
+    id    TransferID
+    index int
+    count int
+    data  []byte
+}
+
+// manifest ends a chunked transfer and describes the whole image
+type manifest struct {
+    id        TransferID
+    count     int
+    size      int64
+    chunkSize int
+    sum       [sha256.Size]byte
+}
+
+// resendRequest asks the sender for chunks the receiver is missing. The sender
+// always publishes the manifest again after them.
+type resendRequest struct {
+    id      TransferID
+    indices []int
+}
+
+// ControlTopic returns the topic resend requests for images published on topic
+// are sent to
+func ControlTopic(topic string) string {
+    return topic + "/control"
+}
+
+// IsChunkMessage reports whether an MQTT payload belongs to the chunk protocol
+// rather than being an image
+func IsChunkMessage(payload []byte) bool {
+    return len(payload) >= chunkPrefixLen && bytes.Equal(payload[:4], chunkMagic)
+}
+
+func appendPrefix(buf []byte, kind byte, id TransferID) []byte {
+    buf = append(buf, chunkMagic...)
+    buf = append(buf, chunkVersion, kind)
+    return append(buf, id[:]...)
+}
+
+// ChunkCount returns the number of chunks an image of size bytes is split into
+// with the given chunk size
+func ChunkCount(size, chunkSize int) int {
+    perChunk := chunkSize - chunkHeaderLen
+    return (size + perChunk - 1) / perChunk
+}
+
+// splitImage cuts data into chunk messages of at most chunkSize bytes each, and
+// returns them with the manifest that ends the transfer
+func splitImage(data []byte, chunkSize int) ([][]byte, []byte) {
+    perChunk := chunkSize - chunkHeaderLen
+    count := ChunkCount(len(data), chunkSize)
+    sum := sha256.Sum256(data)
+    id := newTransferID(sum)
+
+    chunks := make([][]byte, 0, count)
+    for i := 0; i < count; i++ {
+        part := data[i*perChunk : min((i+1)*perChunk, len(data))]
+        partSum := sha256.Sum256(part)
+
+        msg := make([]byte, 0, chunkHeaderLen+len(part))
+        msg = appendPrefix(msg, kindChunk, id)
+        msg = binary.BigEndian.AppendUint32(msg, uint32(i))
+        msg = binary.BigEndian.AppendUint32(msg, uint32(count))
+        msg = append(msg, partSum[:]...)
+        chunks = append(chunks, append(msg, part...))
+    }
+
+    m := make([]byte, 0, manifestLen)
+    m = appendPrefix(m, kindManifest, id)
+    m = binary.BigEndian.AppendUint32(m, uint32(count))
+    m = binary.BigEndian.AppendUint64(m, uint64(len(data)))
+    m = binary.BigEndian.AppendUint32(m, uint32(chunkSize))
+    m = append(m, sum[:]...)
+    return chunks, m
+}
+
+// marshalResend encodes a resend request
+func marshalResend(r resendRequest) []byte {
+    indices := r.indices
+    if len(indices) > maxResendChunks {
+        indices = indices[:maxResendChunks]
+    }
+    msg := make([]byte, 0, chunkPrefixLen+2+4*len(indices))
+    msg = appendPrefix(msg, kindResend, r.id)
+    msg = binary.BigEndian.AppendUint16(msg, uint16(len(indices)))
+    for _, i := range indices {
+        msg = binary.BigEndian.AppendUint32(msg, uint32(i))
+    }
+    return msg
+}
+
+// parseChunkMessage decodes a chunk protocol message into a *chunk, *manifest or
+// *resendRequest
+func parseChunkMessage(payload []byte) (any, error) {
+    if !IsChunkMessage(payload) {
+        return nil, ErrInvalidChunk
+    }
+    if payload[4] != chunkVersion {
+        return nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidChunk, payload[4])
+    }
+    kind := payload[5]
+    var id TransferID
+    copy(id[:], payload[6:chunkPrefixLen])
+    body := payload[chunkPrefixLen:]
+
+    switch kind {
+    case kindChunk:
+        if len(payload) < chunkHeaderLen {
+            return nil, fmt.Errorf("%w: chunk header truncated", ErrInvalidChunk)
+        }
+        c := &chunk{
+            id:    id,
+            index: int(binary.BigEndian.Uint32(body[0:4])),
+            count: int(binary.BigEndian.Uint32(body[4:8])),
+            data:  body[8+sha256.Size:],
+        }
+        if c.count == 0 || c.count > maxChunkCount || c.index >= c.count {
+            return nil, fmt.Errorf("%w: chunk %d of %d", ErrInvalidChunk, c.index, c.count)
+        }
+        if sha256.Sum256(c.data) != [sha256.Size]byte(body[8:8+sha256.Size]) {
+            return nil, fmt.Errorf("%w: chunk %d of transfer %s", ErrChunkCorrupted, c.index, id)
+        }
+        return c, nil
+
+    case kindManifest:
+        if len(payload) != manifestLen {
+            return nil, fmt.Errorf("%w: manifest is %d bytes", ErrInvalidChunk, len(payload))
+        }
+        m := &manifest{
+            id:        id,
+            count:     int(binary.BigEndian.Uint32(body[0:4])),
+            size:      int64(binary.BigEndian.Uint64(body[4:12])),
+            chunkSize: int(binary.BigEndian.Uint32(body[12:16])),
+        }
+        copy(m.sum[:], body[16:])
+        if m.size <= 0 || m.size > maxTransferSize {
+            return nil, fmt.Errorf("%w: manifest for %d bytes", ErrInvalidChunk, m.size)
+        }
+        if m.chunkSize < MinChunkSize {
+            return nil, fmt.Errorf("%w: manifest chunk size %d", ErrInvalidChunk, m.chunkSize)
+        }
+        // The count follows from the size, so it cannot be inflated on its own
+        if m.count != ChunkCount(int(m.size), m.chunkSize) {
+            return nil, fmt.Errorf("%w: manifest has %d chunks for %d bytes in chunks of %d", ErrInvalidChunk, m.count, m.size, m.chunkSize)
+        }
+        return m, nil
+
+    case kindResend:
+        if len(body) < 2 {
+            return nil, fmt.Errorf("%w: resend request truncated", ErrInvalidChunk)
+        }
+        n := int(binary.BigEndian.Uint16(body[0:2]))
+        if len(body) != 2+4*n {
+            return nil, fmt.Errorf("%w: resend request truncated", ErrInvalidChunk)
+        }
+        r := &resendRequest{id: id, indices: make([]int, n)}
+        for i := range r.indices {
+            r.indices[i] = int(binary.BigEndian.Uint32(body[2+4*i:]))
+        }
+        return r, nil
+    }
+    return nil, fmt.Errorf("%w: unknown kind %d", ErrInvalidChunk, kind)
+}
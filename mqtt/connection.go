@@ -0,0 +1,95 @@
+package mqtt
+
+import (
+    "sync"
+    "time"
+
+    MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Connection defaults, see WithKeepAlive, WithConnectTimeout and
+// WithMaxReconnectInterval
+const (
+    DefaultKeepAlive            = 30 * time.Second
+    DefaultConnectTimeout       = 30 * time.Second
+    DefaultMaxReconnectInterval = time.Minute
+)
+
+// ConnectionState is a change in the connection to the broker
+type ConnectionState int
+
+const (
+    Connected      ConnectionState = iota // The first connection was made
+    ConnectionLost                        // The connection dropped, reconnecting starts
+    Reconnecting                          // An attempt to reconnect is about to be made
+    Reconnected                           // The connection is back and subscriptions are renewed
+)
+
+func (s ConnectionState) String() string {
+    switch s {
+    case Connected:
+        return "connected"
+    case ConnectionLost:
+        return "connection lost"
+    case Reconnecting:
+        return "reconnecting"
+    case Reconnected:
+        return "reconnected"
+    }
+    return "unknown"
+}
+
+// ConnectionEvent reports a change in the connection to the broker
+type ConnectionEvent struct {
+    State   ConnectionState
+    Attempt int   // Reconnect attempts so far, for Reconnecting and Reconnected
+    Err     error // Why the connection was lost, or why resubscribing failed
+}
+
+// watchConnection reconnects the client with exponential backoff whenever the
+// connection drops, starting at a second and doubling up to the maximum
+// interval, and reports every change to the handler set with
+// WithConnectionHandler. resubscribe, if not nil, renews the subscriptions once
+// the connection is back; a clean session loses them with the connection.
+func watchConnection(clientOpts *MQTT.ClientOptions, o *options, resubscribe func(MQTT.Client) error) {
+    clientOpts.SetAutoReconnect(true)
+    clientOpts.SetKeepAlive(o.keepAlive)
+    clientOpts.SetConnectTimeout(o.connectTimeout)
+    clientOpts.SetMaxReconnectInterval(o.maxReconnectInterval)
+
+    notify := func(e ConnectionEvent) {
+        if o.onConnection != nil {
+            o.onConnection(e)
+        }
+    }
+
+    var mu sync.Mutex
+    connected := false
+    attempts := 0
+    clientOpts.SetOnConnectHandler(func(client MQTT.Client) {
+        mu.Lock()
+        first, n := !connected, attempts
+        connected, attempts = true, 0
+        mu.Unlock()
+
+        if first {
+            notify(ConnectionEvent{State: Connected})
+            return
+        }
+        var err error
+        if resubscribe != nil {
+            err = resubscribe(client)
+        }
+        notify(ConnectionEvent{State: Reconnected, Attempt: n, Err: err})
+    })
+    clientOpts.SetConnectionLostHandler(func(_ MQTT.Client, err error) {
+        notify(ConnectionEvent{State: ConnectionLost, Err: err})
+    })
+    clientOpts.SetReconnectingHandler(func(MQTT.Client, *MQTT.ClientOptions) {
+        mu.Lock()
+        attempts++
+        n := attempts
+        mu.Unlock()
+        notify(ConnectionEvent{State: Reconnecting, Attempt: n})
+    })
+}
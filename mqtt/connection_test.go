@@ -0,0 +1,154 @@
+package mqtt
+
+import (
+    "bytes"
+    "testing"
+    "time"
+)
+
+// waitForState returns the next event in state, failing the test if it does
+// not come in time. Events in other states are skipped.
+func waitForState(t *testing.T, events <-chan ConnectionEvent, state ConnectionState) ConnectionEvent {
+    t.Helper()
+    timeout := time.After(15 * time.Second)
+    for {
+        select {
+        case e := <-events:
+            if e.State == state {
+                return e
+            }
+        case <-timeout:
+            t.Fatalf("no %s event", state)
+        }
+    }
+}
+
+func waitForImage(t *testing.T, images <-chan Received, want []byte) {
+    t.Helper()
+    select {
+    case r := <-images:
+        if !bytes.Equal(r.Data, want) {
+            t.Fatalf("received %q, want %q", r.Data, want)
+        }
+    case <-time.After(10 * time.Second):
+        t.Fatalf("image %q not received", want)
+    }
+}
+
+func TestSubscribeForImagesReconnectsAfterBrokerRestart(t *testing.T) {
+    broker := startTestBroker(t)
+    const topic = "test/images"
+
+    events := make(chan ConnectionEvent, 64)
+    images := make(chan Received, 4)
+    client, err := SubscribeForImages(broker.URL(), topic, "",
+        WithImageHandler(func(r Received) { images <- r }),
+        WithConnectionHandler(func(e ConnectionEvent) {
+            select {
+            case events <- e:
+            default:
+            }
+        }),
+        WithKeepAlive(time.Second),
+        WithMaxReconnectInterval(time.Second),
+    )
+    if err != nil {
+        t.Fatalf("SubscribeForImages: %v", err)
+    }
+    defer client.Disconnect(0)
+    waitForState(t, events, Connected)
+
+    before := []byte("sent before the restart")
+    if err := PublishImageData(broker.URL(), topic, before); err != nil {
+        t.Fatalf("publishing: %v", err)
+    }
+    waitForImage(t, images, before)
+
+    broker.stop()
+    if e := waitForState(t, events, ConnectionLost); e.Err == nil {
+        t.Error("ConnectionLost without a reason")
+    }
+    waitForState(t, events, Reconnecting)
+    broker.start()
+
+    e := waitForState(t, events, Reconnected)
+    if e.Err != nil {
+        t.Fatalf("resubscribing: %v", e.Err)
+    }
+    if e.Attempt < 1 {
+        t.Errorf("Reconnected after %d attempts, want at least 1", e.Attempt)
+    }
+
+    // The restarted broker knows nothing of the old subscription, so this
+    // only arrives if the client subscribed again
+    after := []byte("sent after the restart")
+    if err := PublishImageData(broker.URL(), topic, after); err != nil {
+        t.Fatalf("publishing: %v", err)
+    }
+    waitForImage(t, images, after)
+}
+
+// A sender waiting for a receipt must subscribe to the reply topic again when
+// the broker comes back, or the receipt is never seen
+func TestPublishImageDataResubscribesForReceipt(t *testing.T) {
+    broker := startTestBroker(t)
+    const topic, replyTopic = "test/images", "test/receipts"
+
+    images := make(chan Received, 4)
+    watcher, err := SubscribeForImages(broker.URL(), topic, "", WithImageHandler(func(r Received) { images <- r }))
+    if err != nil {
+        t.Fatalf("SubscribeForImages: %v", err)
+    }
+    defer watcher.Disconnect(0)
+
+    events := make(chan ConnectionEvent, 64)
+    result := make(chan error, 1)
+    go func() {
+        result <- PublishImageData(broker.URL(), topic, []byte("image"),
+            WithReceipt(replyTopic, 30*time.Second, func(payload []byte) bool { return string(payload) == "receipt" }),
+            WithConnectionHandler(func(e ConnectionEvent) {
+                select {
+                case events <- e:
+                default:
+                }
+            }),
+            WithKeepAlive(time.Second),
+            WithMaxReconnectInterval(time.Second),
+        )
+    }()
+    // The sender subscribes for the receipt before it publishes
+    waitForImage(t, images, []byte("image"))
+
+    broker.stop()
+    waitForState(t, events, ConnectionLost)
+    broker.start()
+    if e := waitForState(t, events, Reconnected); e.Err != nil {
+        t.Fatalf("resubscribing: %v", e.Err)
+    }
+
+    if err := PublishImageData(broker.URL(), replyTopic, []byte("receipt"), WithRawPayload()); err != nil {
+        t.Fatalf("publishing the receipt: %v", err)
+    }
+    select {
+    case err := <-result:
+        if err != nil {
+            t.Errorf("PublishImageData: %v", err)
+        }
+    case <-time.After(15 * time.Second):
+        t.Fatal("the receipt sent after the reconnect was not seen")
+    }
+}
+
+func TestConnectionStateString(t *testing.T) {
+    for state, want := range map[ConnectionState]string{
+        Connected:          "connected",
+        ConnectionLost:     "connection lost",
+        Reconnecting:       "reconnecting",
+        Reconnected:        "reconnected",
+        ConnectionState(9): "unknown",
+    } {
+        if got := state.String(); got != want {
+            t.Errorf("%d.String() = %q, want %q", state, got, want)
+        }
+    }
+}
@@ -0,0 +1,189 @@
+package mqtt
+
+import (
+    "bytes"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/hex"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io/fs"
+    "os"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "github.com/Pranavjeet-Naidu/Mosquito/steg"
+)
+
+// Images are published in an envelope that tells the receiver what it got:
+//
+// Layout: [magic(4) | version(1) | header length(4) | JSON header | body]
+//
+// The header is a Metadata. Chunked transfers split the whole envelope. Payloads
+// without the magic are legacy raw images.
+
+const (
+    envelopeVersion   = 1
+    envelopePrefixLen = 4 + 1 + 4
+    maxHeaderLen      = 64 << 10
+)
+
+var envelopeMagic = []byte("MQEN")
+
+// Metadata describes the body of an envelope
+type Metadata struct {
+    ID          string    `json:"id"`                 // Random message ID, see NewMessageID
+    Filename    string    `json:"filename,omitempty"` // Base name of the file the sender published
+    ContentType string    `json:"content_type"`       // MIME type of the body
+    Size        int64     `json:"size"`               // Length of the body in bytes
+    SHA256      string    `json:"sha256"`             // Hex SHA-256 of the body
+    Sender      string    `json:"sender,omitempty"`   // Client ID of the sender
+    Timestamp   time.Time `json:"timestamp"`          // When the body was published
+    ReplyTo     string    `json:"reply_to,omitempty"` // Topic the sender waits for a receipt on, see Receipt
+}
+
+// IsEnvelope reports whether an MQTT payload is an envelope rather than a raw
+// image
+func IsEnvelope(payload []byte) bool {
+    return len(payload) >= envelopePrefixLen && bytes.Equal(payload[:4], envelopeMagic)
+}
+
+// NewMessageID returns a random ID for a message, such as 5d0c2e91a4b7f36e
+func NewMessageID() string {
+    b := make([]byte, 8)
+    rand.Read(b)
+    return hex.EncodeToString(b)
+}
+
+// Seal wraps body in an envelope. Size and SHA256 are filled in from body, and
+// the ID and content type when m does not set them.
+func Seal(m Metadata, body []byte) ([]byte, error) {
+    if m.ID == "" {
+        m.ID = NewMessageID()
+    }
+    sum := sha256.Sum256(body)
+    m.Size = int64(len(body))
+    m.SHA256 = hex.EncodeToString(sum[:])
+    m.Filename = filepath.Base(m.Filename)
+    if m.Filename == "." || m.Filename == string(filepath.Separator) {
+        m.Filename = ""
+    }
+    if m.ContentType == "" {
+        m.ContentType = steg.DetectContentType(body)
+    }
+
+    header, err := json.Marshal(m)
+    if err != nil {
+        return nil, err
+    }
+    if len(header) > maxHeaderLen {
+        return nil, fmt.Errorf("%w: header is %d bytes, the limit is %d", ErrInvalidEnvelope, len(header), maxHeaderLen)
+    }
+
+    payload := make([]byte, 0, envelopePrefixLen+len(header)+len(body))
+    payload = append(payload, envelopeMagic...)
+    payload = append(payload, envelopeVersion)
+    payload = binary.BigEndian.AppendUint32(payload, uint32(len(header)))
+    payload = append(payload, header...)
+    return append(payload, body...), nil
+}
+
+// Open unwraps an envelope and checks the body against its size and hash. A raw
+// legacy payload is returned as it is with nil metadata. The body shares memory
+// with payload.
+func Open(payload []byte) (*Metadata, []byte, error) {
+    if !IsEnvelope(payload) {
+        return nil, payload, nil
+    }
+    if payload[4] != envelopeVersion {
+        return nil, nil, fmt.Errorf("%w: unsupported version %d", ErrInvalidEnvelope, payload[4])
+    }
+    n := int64(binary.BigEndian.Uint32(payload[5:envelopePrefixLen]))
+    if n > maxHeaderLen || int64(len(payload)) < envelopePrefixLen+n {
+        return nil, nil, fmt.Errorf("%w: header truncated", ErrInvalidEnvelope)
+    }
+
+    var m Metadata
+    if err := json.Unmarshal(payload[envelopePrefixLen:envelopePrefixLen+n], &m); err != nil {
+        return nil, nil, fmt.Errorf("%w: %v", ErrInvalidEnvelope, err)
+    }
+    body := payload[envelopePrefixLen+n:]
+
+    if int64(len(body)) != m.Size {
+        return nil, nil, fmt.Errorf("%w: got %d bytes, the header says %d", ErrEnvelopeCorrupted, len(body), m.Size)
+    }
+    sum := sha256.Sum256(body)
+    if !strings.EqualFold(hex.EncodeToString(sum[:]), m.SHA256) {
+        return nil, nil, fmt.Errorf("%w: the body does not match the header hash", ErrEnvelopeCorrupted)
+    }
+    return &m, body, nil
+}
+
+// saveReceived writes a received image to dir under the name its envelope gives,
+// or received-<time>, adding the extension for its content type when the name
+// has none. An existing file is never replaced; -1, -2 … is added to the name
+// instead. It returns the path written.
+func saveReceived(dir string, m *Metadata, data []byte) (string, error) {
+    var name, contentType string
+    if m != nil {
+        name = safeFilename(m.Filename)
+        contentType = m.ContentType
+    }
+    if name == "" {
+        name = "received-" + time.Now().Format("20060102-150405")
+    }
+    if contentType == "" {
+        contentType = steg.DetectContentType(data)
+    }
+
+    ext := filepath.Ext(name)
+    base := strings.TrimSuffix(name, ext)
+    if ext == "" {
+        ext = steg.ExtensionForType(contentType)
+        if ext == "" || strings.HasPrefix(contentType, "application/octet-stream") {
+            ext = ".bin"
+        }
+    }
+
+    for i := 0; ; i++ {
+        path := filepath.Join(dir, base+ext)
+        if i > 0 {
+            path = filepath.Join(dir, fmt.Sprintf("%s-%d%s", base, i, ext))
+        }
+        f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+        if errors.Is(err, fs.ErrExist) {
+            continue
+        }
+        if err != nil {
+            return "", err
+        }
+        _, err = f.Write(data)
+        if closeErr := f.Close(); err == nil {
+            err = closeErr
+        }
+        if err != nil {
+            os.Remove(path)
+            return "", err
+        }
+        return path, nil
+    }
+}
+
+// safeFilename reduces a name chosen by the sender to a plain file name inside
+// the output directory, or "" when nothing usable is left
+func safeFilename(name string) string {
+    if i := strings.LastIndexAny(name, `/\`); i >= 0 {
+        name = name[i+1:]
+    }
+    // No hidden files, which also keeps senders out of .partial
+    name = strings.TrimLeft(name, ".")
+    return strings.Map(func(r rune) rune {
+        if r < 0x20 || r == 0x7f || strings.ContainsRune(`:*?"<>|`, r) {
+            return '_'
+        }
+        return r
+    }, name)
+}
@@ -0,0 +1,104 @@
+package mqtt
+
+import (
+    "bytes"
+    "encoding/binary"
+    "errors"
+    "testing"
+)
+
+func TestEnvelopeRoundTrip(t *testing.T) {
+    body := []byte("%PDF-1.4 not really a pdf")
+    payload, err := Seal(Metadata{Filename: "../../etc/report.pdf", Sender: "alice", ReplyTo: "r/1"}, body)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !IsEnvelope(payload) {
+        t.Fatal("sealed payload is not an envelope")
+    }
+
+    m, got, err := Open(payload)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(got, body) {
+        t.Errorf("body %q, want %q", got, body)
+    }
+    if m.ID == "" || m.Size != int64(len(body)) || m.SHA256 == "" {
+        t.Errorf("ID, size and hash not filled in: %+v", *m)
+    }
+    if m.Filename != "report.pdf" || m.Sender != "alice" || m.ReplyTo != "r/1" {
+        t.Errorf("metadata %+v", *m)
+    }
+    if m.ContentType != "application/pdf" {
+        t.Errorf("content type %q, want application/pdf", m.ContentType)
+    }
+}
+
+// Payloads without the magic are legacy raw images and pass through untouched
+func TestOpenRawPayload(t *testing.T) {
+    for _, payload := range [][]byte{
+        []byte("\x89PNG\r\n\x1a\n raw image"),
+        []byte("MQEX\x01\x00\x00\x00\x02{}body"),
+        []byte("MQE"),
+        nil,
+    } {
+        m, body, err := Open(payload)
+        if err != nil || m != nil || !bytes.Equal(body, payload) {
+            t.Errorf("Open(%q) = %v, %q, %v", payload, m, body, err)
+        }
+    }
+}
+
+func TestOpenInvalidEnvelope(t *testing.T) {
+    payload, err := Seal(Metadata{}, []byte("body"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    headerEnd := envelopePrefixLen + int(binary.BigEndian.Uint32(payload[5:envelopePrefixLen]))
+    modified := func(change func(p []byte) []byte) []byte {
+        return change(append([]byte{}, payload...))
+    }
+
+    for _, tt := range []struct {
+        name    string
+        payload []byte
+        want    error
+    }{
+        {"truncated header", payload[:headerEnd-1], ErrInvalidEnvelope},
+        {"prefix only", payload[:envelopePrefixLen], ErrInvalidEnvelope},
+        {"unknown version", modified(func(p []byte) []byte {
+            p[4] = envelopeVersion + 1
+            return p
+        }), ErrInvalidEnvelope},
+        {"oversized header length", modified(func(p []byte) []byte {
+            binary.BigEndian.PutUint32(p[5:], maxHeaderLen+1)
+            return p
+        }), ErrInvalidEnvelope},
+        {"header length past the end", modified(func(p []byte) []byte {
+            binary.BigEndian.PutUint32(p[5:], 0xFFFFFFFF)
+            return p
+        }), ErrInvalidEnvelope},
+        {"header not JSON", modified(func(p []byte) []byte {
+            p[envelopePrefixLen] = '['
+            return p
+        }), ErrInvalidEnvelope},
+        {"truncated body", payload[:len(payload)-1], ErrEnvelopeCorrupted},
+        {"tampered body", modified(func(p []byte) []byte {
+            p[len(p)-1] ^= 1
+            return p
+        }), ErrEnvelopeCorrupted},
+    } {
+        m, body, err := Open(tt.payload)
+        if !errors.Is(err, tt.want) || m != nil || body != nil {
+            t.Errorf("%s: Open = %v, %q, %v, want %v", tt.name, m, body, err, tt.want)
+        }
+    }
+}
+
+func TestSealRejectsOversizedHeader(t *testing.T) {
+    _, err := Seal(Metadata{Filename: string(bytes.Repeat([]byte("a"), maxHeaderLen))}, []byte("body"))
+    if !errors.Is(err, ErrInvalidEnvelope) {
+        t.Errorf("got %v, want ErrInvalidEnvelope", err)
+    }
+}
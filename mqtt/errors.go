@@ -0,0 +1,40 @@
+package mqtt
+
+import "errors"
+
+// Errors for connection settings that cannot work
+var (
+    ErrInvalidTLSVersion              = errors.New("invalid TLS version")
+    ErrIncompleteKeyPair              = errors.New("a client certificate needs both a certificate and a key file")
+    ErrInsecureBroker                 = errors.New("TLS settings need an ssl://, tls://, mqtts://, tcps:// or wss:// broker")
+    ErrPasswordWithoutUsername        = errors.New("a broker password needs a user name")
+    ErrInvalidQoS                     = errors.New("invalid QoS, expected 0, 1 or 2")
+    ErrPersistentSessionNeedsClientID = errors.New("a persistent session needs a fixed client ID")
+)
+
+// Errors for chunked transfers
+var (
+    ErrInvalidChunk      = errors.New("invalid chunk message")
+    ErrChunkCorrupted    = errors.New("chunked image does not match its hash")
+    ErrInvalidChunkSize  = errors.New("chunk size too small")
+    ErrRetainChunked     = errors.New("a chunked image cannot be retained, the broker only keeps the last message")
+    ErrTooManyTransfers  = errors.New("too many chunked transfers in progress")
+    ErrReceiveBufferFull = errors.New("too many chunks held in memory")
+)
+
+// Errors for message envelopes
+var (
+    ErrInvalidEnvelope   = errors.New("invalid message envelope")
+    ErrEnvelopeCorrupted = errors.New("message does not match its envelope")
+)
+
+// ErrNotAcknowledged is returned when the broker does not confirm a QoS 1 or 2
+// message in time
+var ErrNotAcknowledged = errors.New("the broker did not acknowledge the message in time")
+
+// Errors for delivery receipts
+var (
+    ErrInvalidReceipt = errors.New("invalid delivery receipt")
+    ErrNoReceipt      = errors.New("no receipt arrived in time")
+    ErrNoReplyTopic   = errors.New("the sender did not ask for a receipt")
+)
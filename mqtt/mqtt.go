@@ -1,64 +1,385 @@
 package mqtt
 
 import (
+    "crypto/sha256"
     "fmt"
     "os"
     "path/filepath"
+    "sync"
     "time"
 
     MQTT "github.com/eclipse/paho.mqtt.golang"
 )
 
-func PublishImage(broker, topic, imgPath string) error {
-    opts := MQTT.NewClientOptions().AddBroker(broker)
-    client := MQTT.NewClient(opts)
+// publishTimeout is how long publishing waits for the broker to acknowledge a
+// QoS 1 or 2 message
+const publishTimeout = 30 * time.Second
+
+func PublishImage(broker, topic, imgPath string, opts ...Option) error {
+    data, err := os.ReadFile(imgPath)
+    if err != nil {
+        return err
+    }
+    return PublishImageData(broker, topic, data, append([]Option{WithFilename(imgPath)}, opts...)...)
+}
+
+// PublishImageData publishes an encoded image that is already in memory, on a
+// connection of its own, see Publish
+func PublishImageData(broker, topic string, data []byte, opts ...Option) error {
+    clientOpts, o, err := newClientOptions(broker, "sender", opts)
+    if err != nil {
+        return err
+    }
+
+    // A clean session loses the receipt subscription with the connection, so
+    // it is renewed on every reconnect while the receipt is awaited
+    answered := make(chan struct{})
+    var subscribe func(MQTT.Client) error
+    if o.receiptTopic != "" {
+        var once sync.Once
+        subscribe = func(c MQTT.Client) error {
+            token := c.Subscribe(o.receiptTopic, o.qos, func(_ MQTT.Client, msg MQTT.Message) {
+                if o.acceptReceipt(msg.Payload()) {
+                    once.Do(func() { close(answered) })
+                }
+            })
+            token.Wait()
+            return token.Error()
+        }
+    }
+    watchConnection(clientOpts, o, subscribe)
+    client := MQTT.NewClient(clientOpts)
     if token := client.Connect(); token.Wait() && token.Error() != nil {
         return token.Error()
     }
+    defer client.Disconnect(250)
 
-    data, err := os.ReadFile(imgPath)
-    if err != nil {
+    if subscribe == nil {
+        return publishImage(client, topic, data, o)
+    }
+
+    // Listen before publishing, a fast receiver may answer at once
+    if err := subscribe(client); err != nil {
+        return err
+    }
+    defer client.Unsubscribe(o.receiptTopic)
+
+    if err := publishImage(client, topic, data, o); err != nil {
+        return err
+    }
+    select {
+    case <-answered:
+        return nil
+    case <-time.After(o.receiptTimeout):
+        return ErrNoReceipt
+    }
+}
+
+// Publish publishes an encoded image on a client that is already connected,
+// such as the one SubscribeForImages returns. Only the options for the message
+// itself, such as WithQoS and WithChunkSize, apply. The image is sealed in an
+// envelope naming the client as the sender unless it already is one or
+// WithRawPayload is given. With QoS 1 or 2 it returns once the broker has
+// acknowledged the message.
+func Publish(client MQTT.Client, topic string, data []byte, opts ...Option) error {
+    o := &options{}
+    for _, opt := range opts {
+        opt(o)
+    }
+    if err := o.checkMessage(); err != nil {
         return err
     }
+    reader := client.OptionsReader()
+    o.clientID = reader.ClientID()
+    return publishImage(client, topic, data, o)
+}
+
+func publishImage(client MQTT.Client, topic string, data []byte, o *options) error {
+    if !o.raw && !IsEnvelope(data) {
+        var err error
+        data, err = Seal(Metadata{Filename: o.filename, Sender: o.clientID, Timestamp: time.Now().UTC()}, data)
+        if err != nil {
+            return err
+        }
+    }
+
+    if o.chunkSize > 0 && len(data) > o.chunkSize {
+        if o.retain {
+            return ErrRetainChunked
+        }
+        return publishChunked(client, topic, data, o)
+    }
+    return publish(client, topic, data, o.qos, o.retain)
+}
+
+// publish sends one message and waits for the broker to acknowledge it when the
+// QoS asks for that
+func publish(client MQTT.Client, topic string, payload []byte, qos byte, retain bool) error {
+    token := client.Publish(topic, qos, retain, payload)
+    if !token.WaitTimeout(publishTimeout) {
+        return ErrNotAcknowledged
+    }
+    return token.Error()
+}
+
+// publishChunked sends data as chunks followed by the manifest, then answers
+// resend requests until the resend window has passed without one
+func publishChunked(client MQTT.Client, topic string, data []byte, o *options) error {
+    chunks, manifest := splitImage(data, o.chunkSize)
+    id := newTransferID(sha256.Sum256(data))
+
+    // Listen before publishing so no request is missed
+    requests := make(chan *resendRequest, 16)
+    if o.resendWindow > 0 {
+        token := client.Subscribe(ControlTopic(topic), o.qos, func(_ MQTT.Client, msg MQTT.Message) {
+            if m, err := parseChunkMessage(msg.Payload()); err == nil {
+                if r, ok := m.(*resendRequest); ok && r.id == id {
+                    select {
+                    case requests <- r:
+                    default:
+                    }
+                }
+            }
+        })
+        if token.Wait() && token.Error() != nil {
+            return token.Error()
+        }
+        defer client.Unsubscribe(ControlTopic(topic))
+    }
+
+    for _, c := range chunks {
+        if err := publish(client, topic, c, o.qos, false); err != nil {
+            return err
+        }
+    }
+    if err := publish(client, topic, manifest, o.qos, false); err != nil {
+        return err
+    }
+    if o.resendWindow <= 0 {
+        return nil
+    }
+
+    window := time.NewTimer(o.resendWindow)
+    defer window.Stop()
+    for {
+        select {
+        case r := <-requests:
+            for _, i := range r.indices {
+                if i < len(chunks) {
+                    if err := publish(client, topic, chunks[i], o.qos, false); err != nil {
+                        return err
+                    }
+                }
+            }
+            if err := publish(client, topic, manifest, o.qos, false); err != nil {
+                return err
+            }
+            window.Reset(o.resendWindow)
+        case <-window.C:
+            return nil
+        }
+    }
+}
+
+// Received is an image saved by SubscribeForImages
+type Received struct {
+    Path     string    // "" when SubscribeForImages was given no output directory
+    Metadata *Metadata // nil for payloads sent without an envelope
+    Data     []byte
+
+    client MQTT.Client
+    qos    byte
+}
 
-    token := client.Publish(topic, 0, false, data)
-    token.Wait()
-    client.Disconnect(250)
+// Reply publishes payload, such as a receipt from MarshalReceipt, on the reply
+// topic the sender named in the envelope. It is sent as one message, so an
+// image holding a receipt should be small. Reply does not wait for the broker
+// to acknowledge it: only errors known at once are returned. When Reply returns
+// nil, done, if not nil, is called once with the outcome, from a goroutine of
+// its own if the broker has not answered yet.
+func (r Received) Reply(payload []byte, done func(error)) error {
+    if r.Metadata == nil || r.Metadata.ReplyTo == "" {
+        return ErrNoReplyTopic
+    }
+    token := r.client.Publish(r.Metadata.ReplyTo, r.qos, false, payload)
+    select {
+    case <-token.Done():
+        if err := token.Error(); err != nil {
+            return err
+        }
+        if done != nil {
+            done(nil)
+        }
+        return nil
+    default:
+    }
+
+    // Waiting here would hold up the next message for up to publishTimeout
+    go func() {
+        err := ErrNotAcknowledged
+        if token.WaitTimeout(publishTimeout) {
+            err = token.Error()
+        }
+        if done != nil {
+            done(err)
+        }
+    }()
     return nil
 }
 
-func SubscribeForImages(broker, topic, outputDir string) (MQTT.Client, error) {
-    opts := MQTT.NewClientOptions().AddBroker(broker)
-    
-    // Create a unique client ID
-    opts.SetClientID(fmt.Sprintf("mosquito-receiver-%d", time.Now().Unix()))
-    
-    // Set the message handler
-    opts.SetDefaultPublishHandler(func(client MQTT.Client, msg MQTT.Message) {
-        // Generate a filename with timestamp
-        timestamp := time.Now().Format("20060102-150405")
-        filename := filepath.Join(outputDir, fmt.Sprintf("received-%s.png", timestamp))
-        
-        // Save the received data as an image
-        err := os.WriteFile(filename, msg.Payload(), 0644)
+// maxQueuedImages is how many received images may wait to be saved and
+// handled before paho's message handling waits as well
+const maxQueuedImages = 16
+
+// imageQueue saves received images and calls the image handler on a goroutine of
+// its own, so a slow disk or handler does not stall paho's message handling,
+// keepalives and acknowledgements. Images are handled one at a time in the
+// order they arrived, and the goroutine exits whenever the queue runs empty.
+type imageQueue struct {
+    payloads chan []byte
+    handle   func(payload []byte)
+
+    mu      sync.Mutex
+    running bool
+}
+
+func newImageQueue(handle func(payload []byte)) *imageQueue {
+    return &imageQueue{payloads: make(chan []byte, maxQueuedImages), handle: handle}
+}
+
+// push queues payload, waiting while the queue is full
+func (q *imageQueue) push(payload []byte) {
+    q.payloads <- payload
+    q.mu.Lock()
+    defer q.mu.Unlock()
+    if !q.running {
+        q.running = true
+        go q.run()
+    }
+}
+
+func (q *imageQueue) run() {
+    for {
+        select {
+        case payload := <-q.payloads:
+            q.handle(payload)
+        default:
+            // A payload pushed after the select is seen here, or its push
+            // starts a new worker once this one has stopped
+            q.mu.Lock()
+            if len(q.payloads) == 0 {
+                q.running = false
+                q.mu.Unlock()
+                return
+            }
+            q.mu.Unlock()
+        }
+    }
+}
+
+// SubscribeForImages receives the images published on topic and saves them to
+// outputDir. With an empty outputDir nothing is saved, and images are only
+// handed to the handler set with WithImageHandler. When the connection drops,
+// the client reconnects and subscribes again on its own, see
+// WithConnectionHandler.
+func SubscribeForImages(broker, topic, outputDir string, opts ...Option) (MQTT.Client, error) {
+    clientOpts, o, err := newClientOptions(broker, "receiver", opts)
+    if err != nil {
+        return nil, err
+    }
+
+    // Set once connected, before any message arrives
+    var client MQTT.Client
+    saveImage := func(payload []byte) {
+        m, data, err := Open(payload)
+        if err != nil {
+            fmt.Printf("Error receiving image: %v\n", err)
+            return
+        }
+
+        if outputDir == "" {
+            if o.onImage != nil {
+                o.onImage(Received{Metadata: m, Data: data, client: client, qos: o.qos})
+            }
+            return
+        }
+
+        filename, err := saveReceived(outputDir, m, data)
         if err != nil {
             fmt.Printf("Error saving received image: %v\n", err)
             return
         }
-        
+
         fmt.Printf("Received image saved to: %s\n", filename)
+        if m != nil {
+            sender := m.Sender
+            if sender == "" {
+                sender = "an unnamed sender"
+            }
+            fmt.Printf("  Message %s: %s, %d bytes, from %s at %s\n", m.ID, m.ContentType, m.Size, sender, m.Timestamp.Local().Format(time.DateTime))
+        }
+        if o.onImage != nil {
+            o.onImage(Received{Path: filename, Metadata: m, Data: data, client: client, qos: o.qos})
+        }
+    }
+
+    // Chunked images are put back together in a hidden directory, where partial
+    // transfers wait to be resumed
+    partialDir := ""
+    if outputDir != "" {
+        partialDir = filepath.Join(outputDir, ".partial")
+    }
+    images := newImageQueue(saveImage)
+    chunks := newReassembler(partialDir, o.chunkTimeout)
+    chunks.complete = func(id TransferID, data []byte) {
+        images.push(data)
+    }
+    chunks.incomplete = func(id TransferID, missing []int) {
+        if len(missing) == 0 {
+            fmt.Printf("Transfer %s incomplete: the manifest never arrived\n", id)
+            return
+        }
+        fmt.Printf("Transfer %s incomplete: missing chunks %s (kept for resuming)\n", id, FormatChunkList(missing))
+    }
+    chunks.failed = func(id TransferID, err error) {
+        fmt.Printf("Error receiving transfer %s: %v\n", id, err)
+    }
+    if o.requestResend {
+        chunks.resend = func(topic string, request []byte) {
+            client.Publish(ControlTopic(topic), o.qos, false, request)
+        }
+    }
+    
+    // Set the message handler
+    clientOpts.SetDefaultPublishHandler(func(_ MQTT.Client, msg MQTT.Message) {
+        if IsChunkMessage(msg.Payload()) {
+            if err := chunks.handle(msg.Topic(), msg.Payload()); err != nil {
+                fmt.Printf("Error receiving chunk: %v\n", err)
+            }
+            return
+        }
+        images.push(msg.Payload())
     })
     
+    // A clean session loses the subscription with the connection, so it is
+    // renewed on every reconnect
+    subscribe := func(c MQTT.Client) error {
+        token := c.Subscribe(topic, o.qos, nil)
+        token.Wait()
+        return token.Error()
+    }
+    watchConnection(clientOpts, o, subscribe)
+
     // Connect to the broker
-    client := MQTT.NewClient(opts)
+    client = MQTT.NewClient(clientOpts)
     if token := client.Connect(); token.Wait() && token.Error() != nil {
         return nil, token.Error()
     }
     
     // Subscribe to the topic
-    if token := client.Subscribe(topic, 0, nil); token.Wait() && token.Error() != nil {
+    if err := subscribe(client); err != nil {
         client.Disconnect(250)
-        return nil, token.Error()
+        return nil, err
     }
     
     return client, nil
@@ -0,0 +1,126 @@
+package mqtt
+
+import (
+    "testing"
+    "time"
+
+    MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+func TestReplyDoesNotWaitForAcknowledgement(t *testing.T) {
+    broker := startTestBroker(t)
+    broker.withholdAcks = "test/replies"
+    const topic = "test/images"
+
+    replied := make(chan time.Duration, 1)
+    client, err := SubscribeForImages(broker.URL(), topic, "", WithQoS(1), WithImageHandler(func(r Received) {
+        start := time.Now()
+        if err := r.Reply([]byte("receipt"), nil); err != nil {
+            t.Errorf("Reply: %v", err)
+        }
+        replied <- time.Since(start)
+    }))
+    if err != nil {
+        t.Fatalf("SubscribeForImages: %v", err)
+    }
+    defer client.Disconnect(0)
+
+    image, err := Seal(Metadata{ReplyTo: "test/replies"}, []byte("image"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := PublishImageData(broker.URL(), topic, image); err != nil {
+        t.Fatalf("PublishImageData: %v", err)
+    }
+
+    select {
+    case d := <-replied:
+        if d > time.Second {
+            t.Errorf("Reply took %s, waiting for an acknowledgement that never comes", d)
+        }
+    case <-time.After(10 * time.Second):
+        t.Fatal("Reply blocked the message handler")
+    }
+}
+
+func TestReplyReportsOutcome(t *testing.T) {
+    broker := startTestBroker(t)
+    const topic = "test/images"
+
+    outcome := make(chan error, 1)
+    client, err := SubscribeForImages(broker.URL(), topic, "", WithQoS(1), WithImageHandler(func(r Received) {
+        if err := r.Reply([]byte("receipt"), func(err error) { outcome <- err }); err != nil {
+            t.Errorf("Reply: %v", err)
+        }
+    }))
+    if err != nil {
+        t.Fatalf("SubscribeForImages: %v", err)
+    }
+    defer client.Disconnect(0)
+
+    image, err := Seal(Metadata{ReplyTo: "test/replies"}, []byte("image"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := PublishImageData(broker.URL(), topic, image); err != nil {
+        t.Fatalf("PublishImageData: %v", err)
+    }
+
+    select {
+    case err := <-outcome:
+        if err != nil {
+            t.Errorf("acknowledged reply reported %v", err)
+        }
+    case <-time.After(10 * time.Second):
+        t.Fatal("the outcome of the reply was never reported")
+    }
+}
+
+func TestSlowImageHandlerDoesNotStallClient(t *testing.T) {
+    broker := startTestBroker(t)
+    const topic = "test/images"
+
+    release := make(chan struct{})
+    handled := make(chan string, 4)
+    client, err := SubscribeForImages(broker.URL(), topic, "", WithImageHandler(func(r Received) {
+        <-release
+        handled <- string(r.Data)
+    }))
+    if err != nil {
+        t.Fatalf("SubscribeForImages: %v", err)
+    }
+    defer client.Disconnect(0)
+
+    // Other messages on the client must still be delivered while the handler
+    // is busy with the first image
+    other := make(chan struct{}, 1)
+    if token := client.Subscribe("test/other", 0, func(MQTT.Client, MQTT.Message) { other <- struct{}{} }); token.Wait() && token.Error() != nil {
+        t.Fatal(token.Error())
+    }
+    for _, data := range []string{"first", "second"} {
+        if err := PublishImageData(broker.URL(), topic, []byte(data)); err != nil {
+            t.Fatalf("PublishImageData: %v", err)
+        }
+    }
+    if token := client.Publish("test/other", 0, false, []byte("ping")); token.Wait() && token.Error() != nil {
+        t.Fatal(token.Error())
+    }
+    select {
+    case <-other:
+    case <-time.After(5 * time.Second):
+        t.Fatal("a busy image handler held up the client's other messages")
+    }
+
+    // Images are still handled one at a time, in order
+    close(release)
+    for _, want := range []string{"first", "second"} {
+        select {
+        case got := <-handled:
+            if got != want {
+                t.Fatalf("handled %q, want %q", got, want)
+            }
+        case <-time.After(5 * time.Second):
+            t.Fatalf("%q not handled", want)
+        }
+    }
+}
@@ -0,0 +1,251 @@
+package mqtt
+
+import (
+    "crypto/rand"
+    "crypto/tls"
+    "encoding/hex"
+    "fmt"
+    "os"
+    "path/filepath"
+    "time"
+
+    MQTT "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Option configures the connection to the broker
+type Option func(*options)
+
+type options struct {
+    tls        *tls.Config
+    username   string
+    password   string
+    clientID   string
+    qos        byte
+    retain     bool
+    persistent bool
+    storeDir   string
+
+    keepAlive            time.Duration
+    connectTimeout       time.Duration
+    maxReconnectInterval time.Duration
+    onConnection         func(ConnectionEvent)
+
+    chunkSize     int
+    resendWindow  time.Duration
+    chunkTimeout  time.Duration
+    requestResend bool
+
+    filename string
+    raw      bool
+    onImage  func(Received)
+
+    receiptTopic   string
+    receiptTimeout time.Duration
+    acceptReceipt  func([]byte) bool
+}
+
+// WithTLS sets the TLS configuration for ssl://, tls://, mqtts://, tcps:// and
+// wss:// brokers, see TLSOptions
+func WithTLS(cfg *tls.Config) Option {
+    return func(o *options) { o.tls = cfg }
+}
+
+// WithCredentials logs in with a user name and password. Brokers that take an
+// access token, such as a JWT, usually expect it as the password.
+func WithCredentials(username, password string) Option {
+    return func(o *options) { o.username, o.password = username, password }
+}
+
+// WithClientID connects with a fixed client ID instead of a generated one. The
+// broker disconnects any other client using the same ID.
+func WithClientID(id string) Option {
+    return func(o *options) { o.clientID = id }
+}
+
+// WithQoS sets the MQTT quality of service: 0 delivers at most once, 1 at least
+// once and 2 exactly once. With 1 or 2, publishing waits for the broker to
+// acknowledge the message.
+func WithQoS(qos byte) Option {
+    return func(o *options) { o.qos = qos }
+}
+
+// WithRetain asks the broker to keep the published message and hand it to
+// clients that subscribe later
+func WithRetain() Option {
+    return func(o *options) { o.retain = true }
+}
+
+// WithPersistentSession keeps the session on the broker when the client
+// disconnects, so messages published with QoS 1 or 2 while a receiver is offline
+// are queued for it. It needs a fixed client ID, see WithClientID.
+func WithPersistentSession() Option {
+    return func(o *options) { o.persistent = true }
+}
+
+// WithFileStore keeps messages that are in flight in dir instead of in memory,
+// so QoS 1 and 2 messages not yet acknowledged survive a restart. Each client ID
+// gets its own subdirectory.
+func WithFileStore(dir string) Option {
+    return func(o *options) { o.storeDir = dir }
+}
+
+// WithKeepAlive sets how often the client pings an idle broker, so a dead
+// connection is noticed and reconnected. It defaults to DefaultKeepAlive.
+func WithKeepAlive(d time.Duration) Option {
+    return func(o *options) { o.keepAlive = d }
+}
+
+// WithConnectTimeout limits how long connecting, and each attempt to reconnect,
+// may take. It defaults to DefaultConnectTimeout.
+func WithConnectTimeout(d time.Duration) Option {
+    return func(o *options) { o.connectTimeout = d }
+}
+
+// WithMaxReconnectInterval caps the wait between attempts to reconnect, which
+// starts at a second and doubles after each failure. It defaults to
+// DefaultMaxReconnectInterval.
+func WithMaxReconnectInterval(d time.Duration) Option {
+    return func(o *options) { o.maxReconnectInterval = d }
+}
+
+// WithConnectionHandler calls fn whenever the connection to the broker changes,
+// see ConnectionEvent. Calls come from the background, not from the caller's
+// goroutine.
+func WithConnectionHandler(fn func(ConnectionEvent)) Option {
+    return func(o *options) { o.onConnection = fn }
+}
+
+// WithChunkSize publishes images larger than size bytes as messages of at most
+// size bytes each, which SubscribeForImages puts back together. Zero, the
+// default, always publishes an image as one message.
+func WithChunkSize(size int) Option {
+    return func(o *options) { o.chunkSize = size }
+}
+
+// WithResendWindow keeps the sender connected for d after a chunked image, and
+// as long as requests keep coming, publishing again any chunks a receiver asks
+// for on the control topic
+func WithResendWindow(d time.Duration) Option {
+    return func(o *options) { o.resendWindow = d }
+}
+
+// WithChunkTimeout sets how long a receiver waits for the next chunk of an image
+// before asking for the missing ones again or giving up. It defaults to a minute.
+func WithChunkTimeout(d time.Duration) Option {
+    return func(o *options) { o.chunkTimeout = d }
+}
+
+// WithResendRequests makes a receiver ask the sender for missing chunks on the
+// control topic, see WithResendWindow
+func WithResendRequests() Option {
+    return func(o *options) { o.requestResend = true }
+}
+
+// WithFilename names the published image in its envelope, so the receiver can
+// save it under the same name. Only the base name is sent.
+func WithFilename(name string) Option {
+    return func(o *options) { o.filename = name }
+}
+
+// WithRawPayload publishes the image bytes alone instead of in an envelope, for
+// receivers that predate the envelope format
+func WithRawPayload() Option {
+    return func(o *options) { o.raw = true }
+}
+
+// WithImageHandler calls fn for every image SubscribeForImages has saved. Calls
+// come one at a time, in the order the images arrived, on a goroutine apart
+// from paho's message handling; only once a few images are waiting for fn does
+// the next message wait as well.
+func WithImageHandler(fn func(Received)) Option {
+    return func(o *options) { o.onImage = fn }
+}
+
+// WithReceipt makes PublishImageData wait up to timeout, once the image is
+// published, for a receipt on replyTopic, which the envelope should name. Every
+// message on the topic is passed to accept until it returns true. If none does
+// in time, ErrNoReceipt is returned.
+func WithReceipt(replyTopic string, timeout time.Duration, accept func(payload []byte) bool) Option {
+    return func(o *options) { o.receiptTopic, o.receiptTimeout, o.acceptReceipt = replyTopic, timeout, accept }
+}
+
+// NewClientID returns a random client ID such as mosquito-receiver-3f9a1c22d04e,
+// so clients started at the same time don't take over each other's connection
+func NewClientID(role string) string {
+    b := make([]byte, 6)
+    rand.Read(b)
+    return fmt.Sprintf("mosquito-%s-%s", role, hex.EncodeToString(b))
+}
+
+// checkMessage validates the options for the messages themselves
+func (o *options) checkMessage() error {
+    if o.qos > 2 {
+        return fmt.Errorf("%w: %d", ErrInvalidQoS, o.qos)
+    }
+    if o.chunkSize != 0 && o.chunkSize < MinChunkSize {
+        return fmt.Errorf("%w: %d bytes, the minimum is %d", ErrInvalidChunkSize, o.chunkSize, MinChunkSize)
+    }
+    return nil
+}
+
+// newClientOptions builds the paho options shared by publishing and subscribing,
+// and returns the options for the messages themselves. role names the client in
+// generated IDs.
+func newClientOptions(broker, role string, opts []Option) (*MQTT.ClientOptions, *options, error) {
+    o := &options{}
+    for _, opt := range opts {
+        opt(o)
+    }
+
+    if err := o.checkMessage(); err != nil {
+        return nil, nil, err
+    }
+
+    if o.keepAlive <= 0 {
+        o.keepAlive = DefaultKeepAlive
+    }
+    if o.connectTimeout <= 0 {
+        o.connectTimeout = DefaultConnectTimeout
+    }
+    if o.maxReconnectInterval <= 0 {
+        o.maxReconnectInterval = DefaultMaxReconnectInterval
+    }
+
+    // A session is found again by its client ID, which a random one never matches
+    if o.persistent && o.clientID == "" {
+        return nil, nil, ErrPersistentSessionNeedsClientID
+    }
+
+    clientOpts := MQTT.NewClientOptions().AddBroker(broker)
+    if o.clientID == "" {
+        o.clientID = NewClientID(role)
+    }
+    clientOpts.SetClientID(o.clientID)
+    clientOpts.SetCleanSession(!o.persistent)
+
+    if o.storeDir != "" {
+        dir := filepath.Join(o.storeDir, o.clientID)
+        if err := os.MkdirAll(dir, 0700); err != nil {
+            return nil, nil, err
+        }
+        clientOpts.SetStore(MQTT.NewFileStore(dir))
+    }
+
+    // MQTT 3.1.1 does not allow a password without a user name
+    if o.password != "" && o.username == "" {
+        return nil, nil, ErrPasswordWithoutUsername
+    }
+    if o.username != "" {
+        clientOpts.SetUsername(o.username)
+        clientOpts.SetPassword(o.password)
+    }
+
+    if o.tls != nil {
+        // paho silently ignores TLS settings for plain tcp:// brokers
+        if !IsTLSBroker(broker) {
+            return nil, nil, fmt.Errorf("%w, got %s", ErrInsecureBroker, broker)
+        }
+        clientOpts.SetTLSConfig(o.tls)
+    }
+    return clientOpts, o, nil
+}
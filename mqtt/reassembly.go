@@ -0,0 +1,372 @@
+package mqtt
+
+import (
+    "bytes"
+    "crypto/sha256"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Defaults for receiving chunked images
+const (
+    defaultChunkTimeout = time.Minute
+    defaultMaxResends   = 3
+    maxTransferSize     = 1 << 30
+    maxOpenTransfers    = 64
+    // maxBufferedBytes bounds the chunks held in memory across all transfers
+    // when there is no directory to keep them in
+    maxBufferedBytes = 256 << 20
+)
+
+// reassembler puts chunked images back together. Received chunks can be kept on
+// disk so a transfer resumes after the receiver restarts. Callbacks run after
+// the reassembler is unlocked, so they may block or call back into it.
+type reassembler struct {
+    dir        string        // Directory for partial transfers, or "" to keep them in memory
+    timeout    time.Duration // How long a transfer may go without a new message
+    maxResends int           // Resend requests per transfer before giving up
+    maxBuffer  int64         // Bytes of chunks held in memory across transfers, without a directory
+
+    complete   func(id TransferID, data []byte)
+    incomplete func(id TransferID, missing []int)
+    failed     func(id TransferID, err error)
+    resend     func(topic string, request []byte) // nil when resends are not requested
+
+    mu        sync.Mutex
+    transfers map[TransferID]*transfer
+    finished  map[TransferID]time.Time // Recently completed, to ignore late duplicates
+    buffered  int64                    // Bytes of chunks held in memory
+}
+
+// transfer is a chunked image being received
+type transfer struct {
+    id       TransferID
+    topic    string
+    count    int
+    have     map[int]bool
+    chunks   map[int][]byte // Only used without a directory
+    size     int64
+    manifest *manifest
+    timer    *time.Timer
+    resends  int
+}
+
+func newReassembler(dir string, timeout time.Duration) *reassembler {
+    if timeout <= 0 {
+        timeout = defaultChunkTimeout
+    }
+    return &reassembler{
+        dir:        dir,
+        timeout:    timeout,
+        maxResends: defaultMaxResends,
+        maxBuffer:  maxBufferedBytes,
+        transfers:  map[TransferID]*transfer{},
+        finished:   map[TransferID]time.Time{},
+    }
+}
+
+// handle processes a chunk or manifest received on topic. Resend requests, which
+// only senders act on, are ignored.
+func (r *reassembler) handle(topic string, payload []byte) error {
+    msg, err := parseChunkMessage(payload)
+    if err != nil {
+        return err
+    }
+
+    r.mu.Lock()
+    notify, err := r.handleLocked(topic, msg, payload)
+    r.mu.Unlock()
+    if notify != nil {
+        notify()
+    }
+    return err
+}
+
+// handleLocked does the work of handle with r.mu held. It returns the callback
+// to run once the lock is released, if any.
+func (r *reassembler) handleLocked(topic string, msg any, payload []byte) (func(), error) {
+    var (
+        t   *transfer
+        err error
+    )
+    switch m := msg.(type) {
+    case *chunk:
+        t, err = r.transfer(m.id, topic, m.count)
+        if t == nil || err != nil {
+            return nil, err
+        }
+        if err := r.addChunk(t, m); err != nil {
+            return nil, err
+        }
+    case *manifest:
+        t, err = r.transfer(m.id, topic, m.count)
+        if t == nil || err != nil {
+            return nil, err
+        }
+        t.manifest = m
+        if r.dir != "" {
+            if err := os.WriteFile(filepath.Join(r.transferDir(t.id), "manifest"), payload, 0600); err != nil {
+                return nil, err
+            }
+        }
+        // Chunks of one transfer arrive in order, so any still missing were lost
+        if missing := t.missing(); len(missing) > 0 {
+            return r.requestResend(t, missing), nil
+        }
+    default:
+        return nil, nil
+    }
+
+    return r.tryComplete(t), nil
+}
+
+// requestResend counts a resend request for t and returns the callback that
+// sends it, or nil once the sender has been asked often enough
+func (r *reassembler) requestResend(t *transfer, missing []int) func() {
+    if r.resend == nil || t.resends >= r.maxResends {
+        return nil
+    }
+    t.resends++
+    topic, request := t.topic, marshalResend(resendRequest{id: t.id, indices: missing})
+    return func() { r.resend(topic, request) }
+}
+
+// transfer finds or starts the transfer id. It returns nil for transfers that
+// were completed recently.
+func (r *reassembler) transfer(id TransferID, topic string, count int) (*transfer, error) {
+    if _, done := r.finished[id]; done {
+        return nil, nil
+    }
+
+    t := r.transfers[id]
+    if t == nil {
+        // Every open transfer holds a timer and possibly memory, so a flood of
+        // made-up transfer IDs must not pile them up
+        if len(r.transfers) >= maxOpenTransfers {
+            return nil, fmt.Errorf("%w: %d transfers are already open", ErrTooManyTransfers, len(r.transfers))
+        }
+        t = &transfer{id: id, topic: topic, count: count, have: map[int]bool{}, chunks: map[int][]byte{}}
+        if r.dir != "" {
+            if err := r.load(t); err != nil {
+                return nil, err
+            }
+        }
+        t.timer = time.AfterFunc(r.timeout, func() { r.expire(id) })
+        r.transfers[id] = t
+    } else {
+        t.timer.Reset(r.timeout)
+    }
+
+    if count != t.count {
+        return nil, fmt.Errorf("%w: transfer %s has %d chunks, got a message for %d", ErrInvalidChunk, id, t.count, count)
+    }
+    return t, nil
+}
+
+func (r *reassembler) transferDir(id TransferID) string {
+    return filepath.Join(r.dir, id.String())
+}
+
+// load picks up the chunks and manifest of a transfer from an earlier run
+func (r *reassembler) load(t *transfer) error {
+    dir := r.transferDir(t.id)
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return err
+    }
+    entries, err := os.ReadDir(dir)
+    if err != nil {
+        return err
+    }
+    for _, e := range entries {
+        if e.Name() == "manifest" {
+            data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+            if err != nil {
+                continue
+            }
+            if m, err := parseChunkMessage(data); err == nil {
+                t.manifest, _ = m.(*manifest)
+            }
+            continue
+        }
+        if i, err := strconv.Atoi(e.Name()); err == nil && i < t.count {
+            if info, err := e.Info(); err == nil {
+                t.have[i] = true
+                t.size += info.Size()
+            }
+        }
+    }
+    return nil
+}
+
+func (r *reassembler) addChunk(t *transfer, c *chunk) error {
+    if t.have[c.index] {
+        return nil
+    }
+    if t.size+int64(len(c.data)) > maxTransferSize {
+        r.drop(t, true)
+        return fmt.Errorf("%w: transfer %s exceeds %d bytes", ErrInvalidChunk, t.id, maxTransferSize)
+    }
+    // Dropping the transfer that overflows the buffer leaves room for the others
+    if r.dir == "" && r.buffered+int64(len(c.data)) > r.maxBuffer {
+        r.drop(t, true)
+        return fmt.Errorf("%w: dropped transfer %s, chunks in memory would exceed %d bytes", ErrReceiveBufferFull, t.id, r.maxBuffer)
+    }
+
+    if r.dir != "" {
+        path := filepath.Join(r.transferDir(t.id), strconv.Itoa(c.index))
+        if err := os.WriteFile(path, c.data, 0600); err != nil {
+            return err
+        }
+    } else {
+        t.chunks[c.index] = bytes.Clone(c.data)
+        r.buffered += int64(len(c.data))
+    }
+    t.have[c.index] = true
+    t.size += int64(len(c.data))
+    return nil
+}
+
+// tryComplete assembles t once all chunks and the manifest are in, and returns
+// the callback reporting the result. Only an image that matches its manifest
+// marks the transfer finished, so a failed one can be sent again.
+func (r *reassembler) tryComplete(t *transfer) func() {
+    if t.manifest == nil || len(t.have) < t.count {
+        return nil
+    }
+    id := t.id
+
+    data, err := r.assemble(t)
+    r.drop(t, true)
+    if err != nil {
+        if r.failed == nil {
+            return nil
+        }
+        return func() { r.failed(id, err) }
+    }
+    r.finished[id] = time.Now()
+    r.pruneFinished()
+    if r.complete == nil {
+        return nil
+    }
+    return func() { r.complete(id, data) }
+}
+
+// assemble joins the chunks of t and checks them against the manifest
+func (r *reassembler) assemble(t *transfer) ([]byte, error) {
+    data := make([]byte, 0, t.size)
+    for i := 0; i < t.count; i++ {
+        part := t.chunks[i]
+        if r.dir != "" {
+            var err error
+            part, err = os.ReadFile(filepath.Join(r.transferDir(t.id), strconv.Itoa(i)))
+            if err != nil {
+                return nil, err
+            }
+        }
+        data = append(data, part...)
+    }
+
+    if int64(len(data)) != t.manifest.size {
+        return nil, fmt.Errorf("%w: got %d bytes, the manifest says %d", ErrChunkCorrupted, len(data), t.manifest.size)
+    }
+    if sha256.Sum256(data) != t.manifest.sum {
+        return nil, fmt.Errorf("%w: the image does not match the manifest hash", ErrChunkCorrupted)
+    }
+    return data, nil
+}
+
+// expire runs when a transfer has been idle for the timeout. It asks for the
+// missing chunks again, or gives up and reports them. Chunks kept on disk stay
+// there, so the transfer resumes if the sender publishes the image again.
+func (r *reassembler) expire(id TransferID) {
+    r.mu.Lock()
+    notify := r.expireLocked(id)
+    r.mu.Unlock()
+    if notify != nil {
+        notify()
+    }
+}
+
+// expireLocked does the work of expire with r.mu held, and returns the
+// callback to run once the lock is released
+func (r *reassembler) expireLocked(id TransferID) func() {
+    t := r.transfers[id]
+    if t == nil {
+        return nil
+    }
+    missing := t.missing()
+    if notify := r.requestResend(t, missing); notify != nil {
+        t.timer.Reset(r.timeout)
+        return notify
+    }
+
+    r.drop(t, false)
+    if r.incomplete == nil {
+        return nil
+    }
+    return func() { r.incomplete(id, missing) }
+}
+
+// drop forgets a transfer, and with clean also deletes its chunks on disk
+func (r *reassembler) drop(t *transfer, clean bool) {
+    t.timer.Stop()
+    delete(r.transfers, t.id)
+    if r.dir == "" {
+        r.buffered -= t.size
+        t.chunks = nil
+    }
+    if clean && r.dir != "" {
+        os.RemoveAll(r.transferDir(t.id))
+    }
+}
+
+// pruneFinished forgets completed transfers once duplicates can no longer arrive
+func (r *reassembler) pruneFinished() {
+    for id, at := range r.finished {
+        if time.Since(at) > 10*r.timeout {
+            delete(r.finished, id)
+        }
+    }
+}
+
+// missing returns the indices of the chunks not received yet. An empty list
+// with no manifest means only the manifest is missing.
+func (t *transfer) missing() []int {
+    var missing []int
+    for i := 0; i < t.count; i++ {
+        if !t.have[i] {
+            missing = append(missing, i)
+        }
+    }
+    return missing
+}
+
+// FormatChunkList formats chunk indices compactly, e.g. "0-3, 7, 9-12"
+func FormatChunkList(indices []int) string {
+    if len(indices) == 0 {
+        return "none"
+    }
+    sorted := append([]int(nil), indices...)
+    sort.Ints(sorted)
+
+    var parts []string
+    for i := 0; i < len(sorted); {
+        j := i
+        for j+1 < len(sorted) && sorted[j+1] == sorted[j]+1 {
+            j++
+        }
+        if i == j {
+            parts = append(parts, strconv.Itoa(sorted[i]))
+        } else {
+            parts = append(parts, fmt.Sprintf("%d-%d", sorted[i], sorted[j]))
+        }
+        i = j + 1
+    }
+    return strings.Join(parts, ", ")
+}
@@ -0,0 +1,109 @@
+package mqtt
+
+import (
+    "bytes"
+    "errors"
+    "testing"
+    "time"
+)
+
+func testImage(size int, seed byte) []byte {
+    data := make([]byte, size)
+    for i := range data {
+        data[i] = seed + byte(i)
+    }
+    return data
+}
+
+// feed hands every message of a transfer to the reassembler
+func feed(t *testing.T, r *reassembler, chunks [][]byte, manifest []byte) error {
+    t.Helper()
+    for _, c := range chunks {
+        if err := r.handle("test/images", c); err != nil {
+            return err
+        }
+    }
+    return r.handle("test/images", manifest)
+}
+
+// The callbacks run unlocked, so a blocking handler does not stall other
+// transfers and may even call back into the reassembler
+func TestReassemblerCallbacksRunUnlocked(t *testing.T) {
+    r := newReassembler("", time.Minute)
+    chunks, manifest := splitImage(testImage(3000, 1), MinChunkSize)
+    got := make(chan []byte, 1)
+    r.complete = func(id TransferID, data []byte) {
+        // A late duplicate, ignored since the transfer is finished
+        if err := r.handle("test/images", chunks[0]); err != nil {
+            t.Errorf("duplicate chunk: %v", err)
+        }
+        got <- data
+    }
+
+    done := make(chan error, 1)
+    go func() { done <- feed(t, r, chunks, manifest) }()
+    select {
+    case err := <-done:
+        if err != nil {
+            t.Fatal(err)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("the complete callback deadlocked the reassembler")
+    }
+    if data := <-got; !bytes.Equal(data, testImage(3000, 1)) {
+        t.Error("reassembled image differs")
+    }
+}
+
+// A transfer that fails its manifest check is not remembered as finished, so
+// sending it again delivers the image
+func TestReassemblerRetriesFailedTransfer(t *testing.T) {
+    r := newReassembler("", time.Minute)
+    var completed, failed int
+    r.complete = func(TransferID, []byte) { completed++ }
+    r.failed = func(TransferID, error) { failed++ }
+
+    chunks, manifest := splitImage(testImage(3000, 1), MinChunkSize)
+    bad := bytes.Clone(manifest)
+    bad[len(bad)-1] ^= 1
+    if err := feed(t, r, chunks, bad); err != nil {
+        t.Fatal(err)
+    }
+    if err := feed(t, r, chunks, manifest); err != nil {
+        t.Fatal(err)
+    }
+    if failed != 1 || completed != 1 {
+        t.Errorf("%d failed and %d completed, want 1 of each", failed, completed)
+    }
+}
+
+func TestReassemblerMemoryBudget(t *testing.T) {
+    r := newReassembler("", time.Minute)
+    r.maxBuffer = 4000
+    var completed int
+    r.complete = func(TransferID, []byte) { completed++ }
+
+    first, _ := splitImage(testImage(3000, 1), MinChunkSize)
+    second, manifest := splitImage(testImage(3000, 2), MinChunkSize)
+    for _, c := range first {
+        if err := r.handle("test/images", c); err != nil {
+            t.Fatal(err)
+        }
+    }
+    // The transfer that overflows the budget is the one dropped
+    if err := feed(t, r, second, manifest); !errors.Is(err, ErrReceiveBufferFull) {
+        t.Fatalf("got %v, want %v", err, ErrReceiveBufferFull)
+    }
+    if len(r.transfers) != 1 || r.buffered != 3000 {
+        t.Errorf("%d transfers holding %d bytes, want 1 holding 3000", len(r.transfers), r.buffered)
+    }
+
+    // Finishing a transfer gives its memory back
+    _, manifest = splitImage(testImage(3000, 1), MinChunkSize)
+    if err := r.handle("test/images", manifest); err != nil {
+        t.Fatal(err)
+    }
+    if completed != 1 || r.buffered != 0 {
+        t.Errorf("%d completed, %d bytes still held", completed, r.buffered)
+    }
+}
@@ -0,0 +1,94 @@
+package mqtt
+
+import (
+    "bytes"
+    "crypto/hmac"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "strings"
+    "time"
+)
+
+// Receipts tell the sender of an image whether the receiver could extract its
+// payload. The sender names a reply topic in the envelope, and the receiver
+// publishes a receipt there, either as it is or hidden in an image:
+//
+// Layout: [magic(4) | JSON Receipt]
+//
+// With a shared secret the receipt carries an HMAC-SHA256 tag, so a third party
+// on the broker cannot claim a delivery.
+
+// Receipt statuses
+const (
+    ReceiptDelivered = "delivered" // The payload was extracted
+    ReceiptFailed    = "failed"    // The image arrived but its payload could not be extracted
+)
+
+var receiptMagic = []byte("MQRC")
+
+// Receipt answers an image whose envelope asked for one
+type Receipt struct {
+    ID        string    `json:"id"`                 // Message ID of the image the receipt is for
+    Status    string    `json:"status"`             // ReceiptDelivered or ReceiptFailed
+    Reason    string    `json:"reason,omitempty"`   // Why extracting failed
+    Receiver  string    `json:"receiver,omitempty"` // Client ID of the receiver
+    Timestamp time.Time `json:"timestamp"`          // When the image was extracted
+    MAC       string    `json:"mac,omitempty"`      // Hex HMAC-SHA256 tag, see MarshalReceipt
+}
+
+// ReplyTopic is the default topic to wait for the receipt of a message on
+func ReplyTopic(id string) string {
+    return "mosquito/receipts/" + id
+}
+
+// IsReceipt reports whether an MQTT payload is a receipt rather than an image
+func IsReceipt(payload []byte) bool {
+    return bytes.HasPrefix(payload, receiptMagic)
+}
+
+// MarshalReceipt encodes r, tagged with a key derived from secret unless it is
+// empty
+func MarshalReceipt(r Receipt, secret string) ([]byte, error) {
+    r.MAC = ""
+    if secret != "" {
+        r.MAC = hex.EncodeToString(r.tag(secret))
+    }
+    data, err := json.Marshal(r)
+    if err != nil {
+        return nil, err
+    }
+    return append(append([]byte{}, receiptMagic...), data...), nil
+}
+
+// ParseReceipt decodes a receipt. verified reports whether its tag matches
+// secret; it is false for receipts without a tag and when secret is empty.
+func ParseReceipt(payload []byte, secret string) (r *Receipt, verified bool, err error) {
+    if !IsReceipt(payload) {
+        return nil, false, ErrInvalidReceipt
+    }
+    r = &Receipt{}
+    if err := json.Unmarshal(payload[len(receiptMagic):], r); err != nil {
+        return nil, false, fmt.Errorf("%w: %v", ErrInvalidReceipt, err)
+    }
+    if r.ID == "" || (r.Status != ReceiptDelivered && r.Status != ReceiptFailed) {
+        return nil, false, fmt.Errorf("%w: missing message ID or status", ErrInvalidReceipt)
+    }
+
+    if secret != "" && r.MAC != "" {
+        mac, err := hex.DecodeString(r.MAC)
+        verified = err == nil && hmac.Equal(mac, r.tag(secret))
+    }
+    return r, verified, nil
+}
+
+// tag computes the HMAC of the receipt fields. The key is kept apart from the
+// secret itself, which may also be the password or HMAC key of the payload.
+func (r *Receipt) tag(secret string) []byte {
+    key := sha256.Sum256([]byte("mosquito receipt\x00" + secret))
+    mac := hmac.New(sha256.New, key[:])
+    fields := []string{r.ID, r.Status, r.Reason, r.Receiver, r.Timestamp.UTC().Format(time.RFC3339Nano)}
+    mac.Write([]byte(strings.Join(fields, "\x00")))
+    return mac.Sum(nil)
+}
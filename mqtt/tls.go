@@ -0,0 +1,91 @@
+package mqtt
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "net/url"
+    "os"
+)
+
+// tlsVersions are the versions accepted by ParseTLSVersion
+var tlsVersions = map[string]uint16{
+    "1.0": tls.VersionTLS10,
+    "1.1": tls.VersionTLS11,
+    "1.2": tls.VersionTLS12,
+    "1.3": tls.VersionTLS13,
+}
+
+// TLSOptions describes the TLS connection to a broker. The zero value verifies
+// the broker against the system roots and requires TLS 1.2.
+type TLSOptions struct {
+    CAFile             string // PEM bundle to verify the broker with, instead of the system roots
+    CertFile           string // Client certificate for mutual TLS
+    KeyFile            string // Key of the client certificate
+    ServerName         string // Name expected in the broker certificate, if not the host of the URL
+    InsecureSkipVerify bool   // Accept any broker certificate, for test setups only
+    MinVersion         string // Lowest TLS version to accept: 1.0, 1.1, 1.2 or 1.3
+}
+
+// ParseTLSVersion parses a TLS version such as "1.2"
+func ParseTLSVersion(s string) (uint16, error) {
+    v, ok := tlsVersions[s]
+    if !ok {
+        return 0, fmt.Errorf("%w: %q (expected 1.0, 1.1, 1.2 or 1.3)", ErrInvalidTLSVersion, s)
+    }
+    return v, nil
+}
+
+// Config loads the files named by o and returns the TLS configuration
+func (o TLSOptions) Config() (*tls.Config, error) {
+    cfg := &tls.Config{
+        ServerName:         o.ServerName,
+        InsecureSkipVerify: o.InsecureSkipVerify,
+        MinVersion:         tls.VersionTLS12,
+    }
+
+    if o.MinVersion != "" {
+        v, err := ParseTLSVersion(o.MinVersion)
+        if err != nil {
+            return nil, err
+        }
+        cfg.MinVersion = v
+    }
+
+    if (o.CertFile == "") != (o.KeyFile == "") {
+        return nil, ErrIncompleteKeyPair
+    }
+
+    if o.CAFile != "" {
+        pem, err := os.ReadFile(o.CAFile)
+        if err != nil {
+            return nil, err
+        }
+        cfg.RootCAs = x509.NewCertPool()
+        if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+            return nil, fmt.Errorf("%s: no PEM certificates found", o.CAFile)
+        }
+    }
+
+    if o.CertFile != "" {
+        cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+        if err != nil {
+            return nil, err
+        }
+        cfg.Certificates = []tls.Certificate{cert}
+    }
+    return cfg, nil
+}
+
+// IsTLSBroker reports whether a broker URL uses a TLS transport
+func IsTLSBroker(broker string) bool {
+    u, err := url.Parse(broker)
+    if err != nil {
+        return false
+    }
+    switch u.Scheme {
+    case "ssl", "tls", "mqtts", "mqtt+ssl", "tcps", "wss":
+        return true
+    }
+    return false
+}
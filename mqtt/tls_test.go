@@ -0,0 +1,233 @@
+package mqtt
+
+import (
+    "crypto/ecdsa"
+    "crypto/elliptic"
+    "crypto/rand"
+    "crypto/tls"
+    "crypto/x509"
+    "crypto/x509/pkix"
+    "encoding/pem"
+    "errors"
+    "math/big"
+    "os"
+    "path/filepath"
+    "testing"
+    "time"
+)
+
+// testPKI is a CA with a server and a client certificate it signed, written as
+// PEM files to a temporary directory
+type testPKI struct {
+    caFile     string
+    serverCert tls.Certificate
+    clientCert string
+    clientKey  string
+    pool       *x509.CertPool
+}
+
+// serverName is the only name in the server certificate
+const serverName = "broker.test"
+
+func newTestPKI(t *testing.T) *testPKI {
+    t.Helper()
+    dir := t.TempDir()
+    ca, caKey := newCert(t, nil, nil, &x509.Certificate{
+        Subject:               pkix.Name{CommonName: "Mosquito test CA"},
+        IsCA:                  true,
+        BasicConstraintsValid: true,
+        KeyUsage:              x509.KeyUsageCertSign,
+    })
+    server, serverKey := newCert(t, ca, caKey, &x509.Certificate{
+        Subject:     pkix.Name{CommonName: serverName},
+        DNSNames:    []string{serverName},
+        ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+    })
+    client, clientKey := newCert(t, ca, caKey, &x509.Certificate{
+        Subject:     pkix.Name{CommonName: "mosquito-client"},
+        ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+    })
+
+    p := &testPKI{
+        caFile:     writePEM(t, dir, "ca.pem", "CERTIFICATE", ca.Raw),
+        clientCert: writePEM(t, dir, "client.pem", "CERTIFICATE", client.Raw),
+        clientKey:  writePEM(t, dir, "client.key", "PRIVATE KEY", marshalKey(t, clientKey)),
+        pool:       x509.NewCertPool(),
+    }
+    p.pool.AddCert(ca)
+    p.serverCert = tls.Certificate{Certificate: [][]byte{server.Raw}, PrivateKey: serverKey}
+    return p
+}
+
+// newCert creates a certificate from template, signed by parent, or self-signed
+// when parent is nil
+func newCert(t *testing.T, parent *x509.Certificate, parentKey *ecdsa.PrivateKey, template *x509.Certificate) (*x509.Certificate, *ecdsa.PrivateKey) {
+    t.Helper()
+    key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+    if err != nil {
+        t.Fatal(err)
+    }
+    serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+    if err != nil {
+        t.Fatal(err)
+    }
+    template.SerialNumber = serial
+    template.NotBefore = time.Now().Add(-time.Hour)
+    template.NotAfter = time.Now().Add(time.Hour)
+    if parent == nil {
+        parent, parentKey = template, key
+    }
+
+    der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, parentKey)
+    if err != nil {
+        t.Fatal(err)
+    }
+    cert, err := x509.ParseCertificate(der)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return cert, key
+}
+
+func marshalKey(t *testing.T, key *ecdsa.PrivateKey) []byte {
+    t.Helper()
+    der, err := x509.MarshalPKCS8PrivateKey(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return der
+}
+
+func writePEM(t *testing.T, dir, name, blockType string, der []byte) string {
+    t.Helper()
+    path := filepath.Join(dir, name)
+    if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+        t.Fatal(err)
+    }
+    return path
+}
+
+// connectTLS connects to broker with the configuration from opts and returns
+// the connection error
+func connectTLS(t *testing.T, broker string, opts TLSOptions) error {
+    t.Helper()
+    cfg, err := opts.Config()
+    if err != nil {
+        t.Fatalf("Config: %v", err)
+    }
+    return PublishImageData(broker, "test/tls", []byte("image"), WithTLS(cfg), WithConnectTimeout(5*time.Second))
+}
+
+func TestTLSVerifiesBrokerAgainstCA(t *testing.T) {
+    pki := newTestPKI(t)
+    broker := startTLSTestBroker(t, &tls.Config{Certificates: []tls.Certificate{pki.serverCert}})
+
+    if err := connectTLS(t, broker.URL(), TLSOptions{CAFile: pki.caFile, ServerName: serverName}); err != nil {
+        t.Errorf("broker signed by --tls-ca: %v", err)
+    }
+
+    // Another CA, as the system roots would be, must not accept the broker
+    other := newTestPKI(t)
+    if err := connectTLS(t, broker.URL(), TLSOptions{CAFile: other.caFile, ServerName: serverName}); err == nil {
+        t.Error("broker signed by an unknown CA was accepted")
+    }
+
+    if err := connectTLS(t, broker.URL(), TLSOptions{CAFile: other.caFile, InsecureSkipVerify: true}); err != nil {
+        t.Errorf("--tls-insecure: %v", err)
+    }
+}
+
+func TestTLSServerNameMismatch(t *testing.T) {
+    pki := newTestPKI(t)
+    broker := startTLSTestBroker(t, &tls.Config{Certificates: []tls.Certificate{pki.serverCert}})
+
+    // The URL host is 127.0.0.1, which the certificate does not name either
+    for _, name := range []string{"", "other.test"} {
+        err := connectTLS(t, broker.URL(), TLSOptions{CAFile: pki.caFile, ServerName: name})
+        var hostErr x509.HostnameError
+        if !errors.As(err, &hostErr) {
+            t.Errorf("server name %q: got %v, want a host name error", name, err)
+        }
+    }
+}
+
+func TestMutualTLS(t *testing.T) {
+    pki := newTestPKI(t)
+    broker := startTLSTestBroker(t, &tls.Config{
+        Certificates: []tls.Certificate{pki.serverCert},
+        ClientAuth:   tls.RequireAndVerifyClientCert,
+        ClientCAs:    pki.pool,
+    })
+
+    opts := TLSOptions{CAFile: pki.caFile, ServerName: serverName}
+    if err := connectTLS(t, broker.URL(), opts); err == nil {
+        t.Error("connected without the client certificate the broker requires")
+    }
+
+    opts.CertFile, opts.KeyFile = pki.clientCert, pki.clientKey
+    if err := connectTLS(t, broker.URL(), opts); err != nil {
+        t.Errorf("with client certificate: %v", err)
+    }
+}
+
+func TestTLSMinVersion(t *testing.T) {
+    pki := newTestPKI(t)
+    broker := startTLSTestBroker(t, &tls.Config{
+        Certificates: []tls.Certificate{pki.serverCert},
+        MaxVersion:   tls.VersionTLS12,
+    })
+
+    opts := TLSOptions{CAFile: pki.caFile, ServerName: serverName, MinVersion: "1.2"}
+    if err := connectTLS(t, broker.URL(), opts); err != nil {
+        t.Errorf("TLS 1.2 broker with minimum 1.2: %v", err)
+    }
+    opts.MinVersion = "1.3"
+    if err := connectTLS(t, broker.URL(), opts); err == nil {
+        t.Error("TLS 1.2 broker accepted with minimum 1.3")
+    }
+}
+
+func TestTLSOptionsConfig(t *testing.T) {
+    cfg, err := TLSOptions{}.Config()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if cfg.MinVersion != tls.VersionTLS12 || cfg.RootCAs != nil || cfg.InsecureSkipVerify {
+        t.Errorf("zero TLSOptions: MinVersion %x, RootCAs %v, InsecureSkipVerify %v", cfg.MinVersion, cfg.RootCAs, cfg.InsecureSkipVerify)
+    }
+
+    pki := newTestPKI(t)
+    for _, tt := range []struct {
+        name string
+        opts TLSOptions
+        want error
+    }{
+        {"bad version", TLSOptions{MinVersion: "1.4"}, ErrInvalidTLSVersion},
+        {"cert without key", TLSOptions{CertFile: pki.clientCert}, ErrIncompleteKeyPair},
+        {"key without cert", TLSOptions{KeyFile: pki.clientKey}, ErrIncompleteKeyPair},
+        {"missing CA", TLSOptions{CAFile: filepath.Join(t.TempDir(), "none.pem")}, os.ErrNotExist},
+    } {
+        if _, err := tt.opts.Config(); !errors.Is(err, tt.want) {
+            t.Errorf("%s: got %v, want %v", tt.name, err, tt.want)
+        }
+    }
+
+    if _, err := (TLSOptions{CAFile: pki.clientKey}).Config(); err == nil {
+        t.Error("a key file was accepted as a CA bundle")
+    }
+}
+
+func TestNewClientOptionsRejectsTLSForPlainBroker(t *testing.T) {
+    if _, _, err := newClientOptions("tcp://127.0.0.1:1883", "test", []Option{WithTLS(&tls.Config{})}); !errors.Is(err, ErrInsecureBroker) {
+        t.Errorf("got %v, want %v", err, ErrInsecureBroker)
+    }
+    for _, broker := range []string{"ssl://h:8883", "tls://h:8883", "mqtts://h:8883", "tcps://h:8883", "wss://h/mqtt"} {
+        if !IsTLSBroker(broker) {
+            t.Errorf("IsTLSBroker(%q) = false", broker)
+        }
+    }
+    if IsTLSBroker("tcp://h:1883") || IsTLSBroker("ws://h/mqtt") {
+        t.Error("plain broker taken for TLS")
+    }
+}
+
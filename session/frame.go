@@ -0,0 +1,103 @@
+package session
+
+import (
+    "encoding/binary"
+    "encoding/hex"
+)
+
+// Frames are the payloads hidden in stego images for a session. Each starts with
+// a type byte and the session ID so the receiver can find the right state:
+//
+//   init:    [0x01 | id(16) | initiator public key(32)]
+//   accept:  [0x02 | id(16) | responder public key(32) | confirmation(32)]
+//   message: [0x03 | id(16) | counter(4) | nonce(12) | ciphertext | tag(16)]
+//
+// The type, ID and counter are authenticated as associated data of the message.
+
+// FrameType identifies the kind of session frame
+type FrameType byte
+
+const (
+    // FrameInit starts a handshake
+    FrameInit FrameType = 0x01
+    // FrameAccept answers a handshake
+    FrameAccept FrameType = 0x02
+    // FrameMessage carries an encrypted message
+    FrameMessage FrameType = 0x03
+)
+
+const (
+    publicKeySize     = 32
+    confirmSize       = 32
+    frameHeaderSize   = 1 + IDSize
+    messagePrefixSize = frameHeaderSize + 4
+)
+
+// FrameTypeNames provides human-readable names for frame types
+var FrameTypeNames = map[FrameType]string{
+    FrameInit:    "handshake init",
+    FrameAccept:  "handshake accept",
+    FrameMessage: "message",
+}
+
+// Frame is a parsed session frame
+type Frame struct {
+    Type       FrameType
+    ID         string
+    PublicKey  []byte // init and accept
+    Confirm    []byte // accept
+    Counter    uint32 // message
+    Ciphertext []byte // message, nonce included
+}
+
+// ParseFrame splits a session frame into its fields
+func ParseFrame(data []byte) (Frame, error) {
+    if len(data) < frameHeaderSize {
+        return Frame{}, ErrInvalidFrame
+    }
+
+    f := Frame{
+        Type: FrameType(data[0]),
+        ID:   hex.EncodeToString(data[1:frameHeaderSize]),
+    }
+    body := data[frameHeaderSize:]
+
+    switch f.Type {
+    case FrameInit:
+        if len(body) != publicKeySize {
+            return Frame{}, ErrInvalidFrame
+        }
+        f.PublicKey = body
+    case FrameAccept:
+        if len(body) != publicKeySize+confirmSize {
+            return Frame{}, ErrInvalidFrame
+        }
+        f.PublicKey = body[:publicKeySize]
+        f.Confirm = body[publicKeySize:]
+    case FrameMessage:
+        if len(body) < 4 {
+            return Frame{}, ErrInvalidFrame
+        }
+        f.Counter = binary.BigEndian.Uint32(body[:4])
+        f.Ciphertext = body[4:]
+    default:
+        return Frame{}, ErrInvalidFrame
+    }
+
+    return f, nil
+}
+
+// MessageFrameSize returns the size of the message frame Seal makes from n
+// bytes of plaintext
+func MessageFrameSize(n int) int {
+    return messagePrefixSize + nonceSize + n + tagSize
+}
+
+// marshalFrame builds a frame from its type, raw session ID and body
+func marshalFrame(t FrameType, id []byte, body ...[]byte) []byte {
+    out := append([]byte{byte(t)}, id...)
+    for _, b := range body {
+        out = append(out, b...)
+    }
+    return out
+}
@@ -0,0 +1,240 @@
+package session
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/ecdh"
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "encoding/binary"
+    "encoding/hex"
+    "errors"
+    "io"
+    "time"
+
+    "golang.org/x/crypto/hkdf"
+)
+
+// A session gives forward secrecy to an ongoing conversation. Both sides start
+// from an X25519 handshake carried in stego images, then keep one symmetric chain
+// key per direction. Every message advances the chain:
+//
+//   message key = HMAC-SHA256(chain key, 0x01)
+//   next chain  = HMAC-SHA256(chain key, 0x02)
+//
+// Old chain keys and used message keys are wiped, so a later compromise of the
+// stored state does not expose earlier traffic.
+
+const (
+    // IDSize is the size of a session ID in bytes
+    IDSize = 16
+    // MaxSkip limits how many message keys are kept for out-of-order delivery
+    MaxSkip = 256
+
+    keySize    = 32
+    nonceSize  = 12
+    tagSize    = 16
+    kdfInfo    = "mosquito session"
+    confirmMsg = "mosquito session accept"
+)
+
+// State describes how far a session has got through the handshake
+type State string
+
+const (
+    // StatePending means the initiator is waiting for the peer's reply
+    StatePending State = "pending"
+    // StateEstablished means both chains are set up and messages can flow
+    StateEstablished State = "established"
+)
+
+// Error types for session operations
+var (
+    ErrInvalidFrame     = errors.New("invalid session frame")
+    ErrUnknownSession   = errors.New("unknown session")
+    ErrNotEstablished   = errors.New("session handshake not completed")
+    ErrAlreadyComplete  = errors.New("session handshake already completed")
+    ErrHandshakeFailed  = errors.New("handshake confirmation failed, wrong pre-shared password?")
+    ErrMessageKeyGone   = errors.New("message key already used or expired")
+    ErrTooManySkipped   = errors.New("too many skipped messages")
+    ErrDecryptionFailed = errors.New("session decryption failed")
+)
+
+// Session holds the persisted state of one conversation
+type Session struct {
+    ID          string            `json:"id"`
+    Peer        string            `json:"peer,omitempty"`
+    Initiator   bool              `json:"initiator"`
+    State       State             `json:"state"`
+    PrivateKey  []byte            `json:"private_key,omitempty"` // only kept while pending
+    SendChain   []byte            `json:"send_chain,omitempty"`
+    RecvChain   []byte            `json:"recv_chain,omitempty"`
+    SendCounter uint32            `json:"send_counter"`
+    RecvCounter uint32            `json:"recv_counter"`
+    Skipped     map[uint32][]byte `json:"skipped,omitempty"`
+    Created     time.Time         `json:"created"`
+    Updated     time.Time         `json:"updated"`
+}
+
+// newID returns a random session ID
+func newID() ([]byte, error) {
+    id := make([]byte, IDSize)
+    if _, err := io.ReadFull(rand.Reader, id); err != nil {
+        return nil, err
+    }
+    return id, nil
+}
+
+// rawID decodes the hex session ID
+func (s *Session) rawID() []byte {
+    id, _ := hex.DecodeString(s.ID)
+    return id
+}
+
+// deriveChains turns the X25519 shared secret into the two chain keys and a key
+// used to confirm the handshake. A pre-shared password, if any, is mixed in so an
+// attacker in the middle cannot complete the handshake without it.
+func deriveChains(shared, id []byte, psk string) (initToResp, respToInit, confirm []byte, err error) {
+    salt := append([]byte{}, id...)
+    if psk != "" {
+        sum := sha256.Sum256([]byte(psk))
+        salt = append(salt, sum[:]...)
+    }
+
+    out := make([]byte, 3*keySize)
+    if _, err := io.ReadFull(hkdf.New(sha256.New, shared, salt, []byte(kdfInfo)), out); err != nil {
+        return nil, nil, nil, err
+    }
+    return out[:keySize], out[keySize : 2*keySize], out[2*keySize:], nil
+}
+
+// confirmTag proves to the initiator that the responder derived the same keys
+func confirmTag(confirm []byte) []byte {
+    mac := hmac.New(sha256.New, confirm)
+    mac.Write([]byte(confirmMsg))
+    return mac.Sum(nil)
+}
+
+// agree performs X25519 between our private key and the peer's public key
+func agree(private, peerPublic []byte) ([]byte, error) {
+    priv, err := ecdh.X25519().NewPrivateKey(private)
+    if err != nil {
+        return nil, err
+    }
+    pub, err := ecdh.X25519().NewPublicKey(peerPublic)
+    if err != nil {
+        return nil, ErrInvalidFrame
+    }
+    return priv.ECDH(pub)
+}
+
+// ratchet derives the message key for the current chain key and the next chain key
+func ratchet(chain []byte) (messageKey, next []byte) {
+    mac := hmac.New(sha256.New, chain)
+    mac.Write([]byte{0x01})
+    messageKey = mac.Sum(nil)
+
+    mac = hmac.New(sha256.New, chain)
+    mac.Write([]byte{0x02})
+    next = mac.Sum(nil)
+    return messageKey, next
+}
+
+// wipe overwrites key material that is no longer needed
+func wipe(b []byte) {
+    for i := range b {
+        b[i] = 0
+    }
+}
+
+// advanceSend steps the sending chain and returns the message key and its counter
+func (s *Session) advanceSend() ([]byte, uint32) {
+    messageKey, next := ratchet(s.SendChain)
+    wipe(s.SendChain)
+    s.SendChain = next
+
+    counter := s.SendCounter
+    s.SendCounter++
+    return messageKey, counter
+}
+
+// receiveKey returns the message key for counter, stepping the receiving chain as
+// needed and keeping keys for any messages skipped on the way
+func (s *Session) receiveKey(counter uint32) ([]byte, error) {
+    if counter < s.RecvCounter {
+        key, ok := s.Skipped[counter]
+        if !ok {
+            return nil, ErrMessageKeyGone
+        }
+        delete(s.Skipped, counter)
+        return key, nil
+    }
+
+    if counter-s.RecvCounter > MaxSkip || len(s.Skipped)+int(counter-s.RecvCounter) > MaxSkip {
+        return nil, ErrTooManySkipped
+    }
+
+    for s.RecvCounter < counter {
+        messageKey, next := ratchet(s.RecvChain)
+        wipe(s.RecvChain)
+        s.RecvChain = next
+        if s.Skipped == nil {
+            s.Skipped = make(map[uint32][]byte)
+        }
+        s.Skipped[s.RecvCounter] = messageKey
+        s.RecvCounter++
+    }
+
+    messageKey, next := ratchet(s.RecvChain)
+    wipe(s.RecvChain)
+    s.RecvChain = next
+    s.RecvCounter++
+    return messageKey, nil
+}
+
+// seal encrypts plaintext with a one-time message key, binding the frame prefix
+func seal(messageKey, prefix, plaintext []byte) ([]byte, error) {
+    block, err := aes.NewCipher(messageKey)
+    if err != nil {
+        return nil, err
+    }
+    gcm, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+
+    nonce := make([]byte, nonceSize)
+    if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+        return nil, err
+    }
+    return gcm.Seal(nonce, nonce, plaintext, prefix), nil
+}
+
+// open decrypts a message sealed with seal
+func open(messageKey, prefix, data []byte) ([]byte, error) {
+    block, err := aes.NewCipher(messageKey)
+    if err != nil {
+        return nil, err
+    }
+    gcm, err := cipher.NewGCM(block)
+    if err != nil {
+        return nil, err
+    }
+
+    if len(data) < nonceSize+gcm.Overhead() {
+        return nil, ErrInvalidFrame
+    }
+    plaintext, err := gcm.Open(nil, data[:nonceSize], data[nonceSize:], prefix)
+    if err != nil {
+        return nil, ErrDecryptionFailed
+    }
+    return plaintext, nil
+}
+
+// counterBytes encodes a message counter
+func counterBytes(counter uint32) []byte {
+    b := make([]byte, 4)
+    binary.BigEndian.PutUint32(b, counter)
+    return b
+}
@@ -0,0 +1,173 @@
+package session
+
+import (
+    "bytes"
+    "errors"
+    "sync"
+    "testing"
+)
+
+// establish runs a handshake between two stores and returns them with the ID
+func establish(t *testing.T, initPSK, acceptPSK string) (alice, bob *Store, id string, err error) {
+    t.Helper()
+    alice, bob = &Store{Dir: t.TempDir()}, &Store{Dir: t.TempDir()}
+    s, init, err := alice.Initiate("bob")
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, accept, err := bob.Accept(init, acceptPSK, "alice")
+    if err != nil {
+        t.Fatal(err)
+    }
+    _, err = alice.Complete(accept, initPSK)
+    return alice, bob, s.ID, err
+}
+
+func mustEstablish(t *testing.T) (alice, bob *Store, id string) {
+    t.Helper()
+    alice, bob, id, err := establish(t, "psk", "psk")
+    if err != nil {
+        t.Fatal(err)
+    }
+    return alice, bob, id
+}
+
+func mustSeal(t *testing.T, st *Store, id, msg string) []byte {
+    t.Helper()
+    frame, _, err := st.Seal(id, []byte(msg))
+    if err != nil {
+        t.Fatal(err)
+    }
+    return frame
+}
+
+func TestRoundTrip(t *testing.T) {
+    alice, bob, id := mustEstablish(t)
+
+    for i, msg := range []string{"hello bob", "still there?"} {
+        frame, counter, err := alice.Seal(id, []byte(msg))
+        if err != nil {
+            t.Fatal(err)
+        }
+        if len(frame) != MessageFrameSize(len(msg)) {
+            t.Errorf("frame is %d bytes, MessageFrameSize says %d", len(frame), MessageFrameSize(len(msg)))
+        }
+        _, got, plain, err := bob.Open(frame)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if string(plain) != msg || got != counter || counter != uint32(i) {
+            t.Errorf("got %q as message #%d, want %q as #%d", plain, got, msg, i)
+        }
+    }
+
+    // The other direction uses its own chain
+    _, _, plain, err := alice.Open(mustSeal(t, bob, id, "hi alice"))
+    if err != nil || string(plain) != "hi alice" {
+        t.Errorf("bob to alice: %q, %v", plain, err)
+    }
+}
+
+func TestOutOfOrderDelivery(t *testing.T) {
+    alice, bob, id := mustEstablish(t)
+    frames := [][]byte{mustSeal(t, alice, id, "0"), mustSeal(t, alice, id, "1"), mustSeal(t, alice, id, "2")}
+
+    for _, i := range []int{2, 0, 1} {
+        _, counter, plain, err := bob.Open(frames[i])
+        if err != nil {
+            t.Fatalf("message %d: %v", i, err)
+        }
+        if counter != uint32(i) || string(plain) != string(rune('0'+i)) {
+            t.Errorf("message %d: got %q as #%d", i, plain, counter)
+        }
+    }
+}
+
+func TestSkipWindow(t *testing.T) {
+    alice, bob, id := mustEstablish(t)
+    var last []byte
+    for i := 0; i <= MaxSkip+1; i++ {
+        last = mustSeal(t, alice, id, "x")
+    }
+    if _, _, _, err := bob.Open(last); !errors.Is(err, ErrTooManySkipped) {
+        t.Errorf("got %v, want %v", err, ErrTooManySkipped)
+    }
+}
+
+func TestRejectsReplay(t *testing.T) {
+    alice, bob, id := mustEstablish(t)
+    frame := mustSeal(t, alice, id, "once")
+    if _, _, _, err := bob.Open(frame); err != nil {
+        t.Fatal(err)
+    }
+    if _, _, _, err := bob.Open(frame); !errors.Is(err, ErrMessageKeyGone) {
+        t.Errorf("replay: got %v, want %v", err, ErrMessageKeyGone)
+    }
+}
+
+func TestRejectsTamperedFrame(t *testing.T) {
+    alice, bob, id := mustEstablish(t)
+    frame := mustSeal(t, alice, id, "do not touch")
+
+    for name, at := range map[string]int{"counter": frameHeaderSize, "ciphertext": len(frame) - tagSize - 1, "tag": len(frame) - 1} {
+        tampered := bytes.Clone(frame)
+        tampered[at] ^= 1
+        if _, _, _, err := bob.Open(tampered); err == nil {
+            t.Errorf("%s: tampered frame opened", name)
+        }
+    }
+
+    // A forged frame must not have advanced the stored chain
+    if _, _, plain, err := bob.Open(frame); err != nil || string(plain) != "do not touch" {
+        t.Errorf("original after tampering: %q, %v", plain, err)
+    }
+}
+
+func TestPSKMismatch(t *testing.T) {
+    alice, _, id, err := establish(t, "one", "two")
+    if !errors.Is(err, ErrHandshakeFailed) {
+        t.Fatalf("got %v, want %v", err, ErrHandshakeFailed)
+    }
+    s, err := alice.Load(id)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if s.State != StatePending {
+        t.Errorf("session is %s after a failed handshake", s.State)
+    }
+}
+
+// Every Seal must get a counter of its own, even from parallel callers
+func TestSealLocksStore(t *testing.T) {
+    alice, _, id := mustEstablish(t)
+    const workers, each = 8, 25
+
+    var mu sync.Mutex
+    seen := map[uint32]bool{}
+    var wg sync.WaitGroup
+    for w := 0; w < workers; w++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            // A store of its own, as another process would have
+            st := &Store{Dir: alice.Dir}
+            for i := 0; i < each; i++ {
+                _, counter, err := st.Seal(id, []byte("x"))
+                if err != nil {
+                    t.Error(err)
+                    return
+                }
+                mu.Lock()
+                if seen[counter] {
+                    t.Errorf("counter %d used twice", counter)
+                }
+                seen[counter] = true
+                mu.Unlock()
+            }
+        }()
+    }
+    wg.Wait()
+    if len(seen) != workers*each {
+        t.Errorf("%d distinct counters, want %d", len(seen), workers*each)
+    }
+}
@@ -0,0 +1,374 @@
+package session
+
+import (
+    "crypto/ecdh"
+    "crypto/hmac"
+    "crypto/rand"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+)
+
+// Store persists sessions as one JSON file per session in a private directory
+type Store struct {
+    Dir string
+}
+
+// DefaultDir returns the default session directory, ~/.config/mosquito/sessions
+func DefaultDir() (string, error) {
+    base, err := os.UserConfigDir()
+    if err != nil {
+        return "", err
+    }
+    return filepath.Join(base, "mosquito", "sessions"), nil
+}
+
+// NewStore opens a session store, creating the directory if needed
+func NewStore(dir string) (*Store, error) {
+    if dir == "" {
+        var err error
+        dir, err = DefaultDir()
+        if err != nil {
+            return nil, err
+        }
+    }
+    if err := os.MkdirAll(dir, 0700); err != nil {
+        return nil, err
+    }
+    return &Store{Dir: dir}, nil
+}
+
+// path returns the file holding the session with the given ID
+func (st *Store) path(id string) string {
+    return filepath.Join(st.Dir, id+".json")
+}
+
+// checkID rejects anything but a hex session ID, before it is used in a path
+func checkID(id string) error {
+    if _, err := hex.DecodeString(id); err != nil || len(id) != 2*IDSize {
+        return fmt.Errorf("%w: %s", ErrUnknownSession, id)
+    }
+    return nil
+}
+
+// lock takes an exclusive lock on a session until the returned function is
+// called, so two processes cannot both advance the same chain and reuse a
+// message key. The lock is a file of its own since Save replaces the state file.
+func (st *Store) lock(id string) (func(), error) {
+    if err := checkID(id); err != nil {
+        return nil, err
+    }
+    f, err := os.OpenFile(filepath.Join(st.Dir, id+".lock"), os.O_RDWR|os.O_CREATE, 0600)
+    if err != nil {
+        return nil, err
+    }
+    if err := lockFile(f); err != nil {
+        f.Close()
+        return nil, err
+    }
+    return func() {
+        unlockFile(f)
+        f.Close()
+    }, nil
+}
+
+// Load reads a session by ID
+func (st *Store) Load(id string) (*Session, error) {
+    if err := checkID(id); err != nil {
+        return nil, err
+    }
+
+    data, err := os.ReadFile(st.path(id))
+    if os.IsNotExist(err) {
+        return nil, fmt.Errorf("%w: %s", ErrUnknownSession, id)
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    var s Session
+    if err := json.Unmarshal(data, &s); err != nil {
+        return nil, err
+    }
+    return &s, nil
+}
+
+// Save writes a session, replacing the previous state atomically so a crash
+// never leaves both old and new keys behind
+func (st *Store) Save(s *Session) error {
+    s.Updated = time.Now()
+    data, err := json.MarshalIndent(s, "", "  ")
+    if err != nil {
+        return err
+    }
+
+    tmp, err := os.CreateTemp(st.Dir, "."+s.ID+"-*.tmp")
+    if err != nil {
+        return err
+    }
+    defer os.Remove(tmp.Name())
+
+    if _, err := tmp.Write(data); err != nil {
+        tmp.Close()
+        return err
+    }
+    if err := tmp.Close(); err != nil {
+        return err
+    }
+    return os.Rename(tmp.Name(), st.path(s.ID))
+}
+
+// Delete removes a session
+func (st *Store) Delete(id string) error {
+    if _, err := st.Load(id); err != nil {
+        return err
+    }
+    if err := os.Remove(st.path(id)); err != nil {
+        return err
+    }
+    os.Remove(filepath.Join(st.Dir, id+".lock"))
+    return nil
+}
+
+// List returns all stored sessions, oldest first
+func (st *Store) List() ([]*Session, error) {
+    entries, err := os.ReadDir(st.Dir)
+    if err != nil {
+        return nil, err
+    }
+
+    var sessions []*Session
+    for _, e := range entries {
+        name := e.Name()
+        if e.IsDir() || !strings.HasSuffix(name, ".json") {
+            continue
+        }
+        s, err := st.Load(strings.TrimSuffix(name, ".json"))
+        if err != nil {
+            continue
+        }
+        sessions = append(sessions, s)
+    }
+
+    sort.Slice(sessions, func(i, j int) bool {
+        return sessions[i].Created.Before(sessions[j].Created)
+    })
+    return sessions, nil
+}
+
+// Initiate starts a new session and returns the init frame to send to the peer
+func (st *Store) Initiate(peer string) (*Session, []byte, error) {
+    id, err := newID()
+    if err != nil {
+        return nil, nil, err
+    }
+    priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+    if err != nil {
+        return nil, nil, err
+    }
+
+    s := &Session{
+        ID:         hex.EncodeToString(id),
+        Peer:       peer,
+        Initiator:  true,
+        State:      StatePending,
+        PrivateKey: priv.Bytes(),
+        Created:    time.Now(),
+    }
+    if err := st.Save(s); err != nil {
+        return nil, nil, err
+    }
+
+    return s, marshalFrame(FrameInit, id, priv.PublicKey().Bytes()), nil
+}
+
+// Accept answers an init frame, establishing the session on the responder side,
+// and returns the accept frame to send back
+func (st *Store) Accept(data []byte, psk, peer string) (*Session, []byte, error) {
+    f, err := ParseFrame(data)
+    if err != nil {
+        return nil, nil, err
+    }
+    if f.Type != FrameInit {
+        return nil, nil, fmt.Errorf("%w: expected %s, got %s", ErrInvalidFrame, FrameTypeNames[FrameInit], FrameTypeNames[f.Type])
+    }
+    if _, err := st.Load(f.ID); err == nil {
+        return nil, nil, fmt.Errorf("%w: %s", ErrAlreadyComplete, f.ID)
+    }
+
+    priv, err := ecdh.X25519().GenerateKey(rand.Reader)
+    if err != nil {
+        return nil, nil, err
+    }
+    shared, err := agree(priv.Bytes(), f.PublicKey)
+    if err != nil {
+        return nil, nil, err
+    }
+    defer wipe(shared)
+
+    id, _ := hex.DecodeString(f.ID)
+    initToResp, respToInit, confirm, err := deriveChains(shared, id, psk)
+    if err != nil {
+        return nil, nil, err
+    }
+    defer wipe(confirm)
+
+    now := time.Now()
+    s := &Session{
+        ID:        f.ID,
+        Peer:      peer,
+        State:     StateEstablished,
+        SendChain: respToInit,
+        RecvChain: initToResp,
+        Created:   now,
+    }
+    if err := st.Save(s); err != nil {
+        return nil, nil, err
+    }
+
+    return s, marshalFrame(FrameAccept, id, priv.PublicKey().Bytes(), confirmTag(confirm)), nil
+}
+
+// Complete finishes a handshake on the initiator side using the peer's accept frame
+func (st *Store) Complete(data []byte, psk string) (*Session, error) {
+    f, err := ParseFrame(data)
+    if err != nil {
+        return nil, err
+    }
+    if f.Type != FrameAccept {
+        return nil, fmt.Errorf("%w: expected %s, got %s", ErrInvalidFrame, FrameTypeNames[FrameAccept], FrameTypeNames[f.Type])
+    }
+
+    unlock, err := st.lock(f.ID)
+    if err != nil {
+        return nil, err
+    }
+    defer unlock()
+
+    s, err := st.Load(f.ID)
+    if err != nil {
+        return nil, err
+    }
+    if s.State != StatePending || !s.Initiator {
+        return nil, fmt.Errorf("%w: %s", ErrAlreadyComplete, f.ID)
+    }
+
+    shared, err := agree(s.PrivateKey, f.PublicKey)
+    if err != nil {
+        return nil, err
+    }
+    defer wipe(shared)
+
+    initToResp, respToInit, confirm, err := deriveChains(shared, s.rawID(), psk)
+    if err != nil {
+        return nil, err
+    }
+    defer wipe(confirm)
+
+    if !hmac.Equal(confirmTag(confirm), f.Confirm) {
+        wipe(initToResp)
+        wipe(respToInit)
+        return nil, ErrHandshakeFailed
+    }
+
+    // The ephemeral private key is no longer needed once the chains exist
+    wipe(s.PrivateKey)
+    s.PrivateKey = nil
+    s.SendChain = initToResp
+    s.RecvChain = respToInit
+    s.State = StateEstablished
+
+    if err := st.Save(s); err != nil {
+        return nil, err
+    }
+    return s, nil
+}
+
+// Seal encrypts a message for a session with the next message key, saving the
+// advanced chain before the frame is returned
+func (st *Store) Seal(id string, plaintext []byte) ([]byte, uint32, error) {
+    unlock, err := st.lock(id)
+    if err != nil {
+        return nil, 0, err
+    }
+    defer unlock()
+
+    s, err := st.Load(id)
+    if err != nil {
+        return nil, 0, err
+    }
+    if s.State != StateEstablished {
+        return nil, 0, fmt.Errorf("%w: %s", ErrNotEstablished, id)
+    }
+
+    messageKey, counter := s.advanceSend()
+    defer wipe(messageKey)
+
+    prefix := marshalFrame(FrameMessage, s.rawID(), counterBytes(counter))
+    ciphertext, err := seal(messageKey, prefix, plaintext)
+    if err != nil {
+        return nil, 0, err
+    }
+
+    if err := st.Save(s); err != nil {
+        return nil, 0, err
+    }
+    return append(prefix, ciphertext...), counter, nil
+}
+
+// Open decrypts a message frame, picking the session and message key from the
+// frame's session ID and counter. The used key is deleted from the stored state.
+func (st *Store) Open(data []byte) (*Session, uint32, []byte, error) {
+    f, err := ParseFrame(data)
+    if err != nil {
+        return nil, 0, nil, err
+    }
+    if f.Type != FrameMessage {
+        return nil, 0, nil, fmt.Errorf("%w: expected %s, got %s", ErrInvalidFrame, FrameTypeNames[FrameMessage], FrameTypeNames[f.Type])
+    }
+
+    unlock, err := st.lock(f.ID)
+    if err != nil {
+        return nil, 0, nil, err
+    }
+    defer unlock()
+
+    s, err := st.Load(f.ID)
+    if err != nil {
+        return nil, 0, nil, err
+    }
+    if s.State != StateEstablished {
+        return nil, 0, nil, fmt.Errorf("%w: %s", ErrNotEstablished, f.ID)
+    }
+
+    // Work on a copy so a forged frame cannot advance the stored chain
+    trial := *s
+    trial.RecvChain = append([]byte{}, s.RecvChain...)
+    trial.Skipped = make(map[uint32][]byte, len(s.Skipped))
+    for k, v := range s.Skipped {
+        trial.Skipped[k] = v
+    }
+
+    messageKey, err := trial.receiveKey(f.Counter)
+    if err != nil {
+        return nil, 0, nil, err
+    }
+    defer wipe(messageKey)
+
+    prefix := data[:messagePrefixSize]
+    plaintext, err := open(messageKey, prefix, f.Ciphertext)
+    if err != nil {
+        return nil, 0, nil, err
+    }
+
+    wipe(s.RecvChain)
+    if err := st.Save(&trial); err != nil {
+        return nil, 0, nil, err
+    }
+    return &trial, f.Counter, plaintext, nil
+}
@@ -0,0 +1,157 @@
+package steg
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/rand"
+    "fmt"
+    "io"
+    "strings"
+
+    "golang.org/x/crypto/chacha20poly1305"
+)
+
+// CipherSuite identifies the AEAD used to encrypt a payload. It is stored as the
+// first byte of every encrypted payload so extraction can pick it automatically.
+type CipherSuite byte
+
+const (
+    // SuiteAES256GCM is AES-256-GCM with a random 12-byte nonce
+    SuiteAES256GCM CipherSuite = 0x01
+    // SuiteChaCha20Poly1305 is ChaCha20-Poly1305 with a random 12-byte nonce
+    SuiteChaCha20Poly1305 CipherSuite = 0x02
+    // SuiteXChaCha20Poly1305 is XChaCha20-Poly1305 with a random 24-byte nonce
+    SuiteXChaCha20Poly1305 CipherSuite = 0x03
+    // SuiteAES256GCMSIV is the nonce-misuse-resistant AES-256-GCM-SIV
+    SuiteAES256GCMSIV CipherSuite = 0x04
+
+    // DefaultCipherSuite is used when no suite is specified
+    DefaultCipherSuite = SuiteAES256GCM
+)
+
+// CipherSuiteNames provides human-readable names for cipher suites
+var CipherSuiteNames = map[CipherSuite]string{
+    SuiteAES256GCM:         "AES-256-GCM",
+    SuiteChaCha20Poly1305:  "ChaCha20-Poly1305",
+    SuiteXChaCha20Poly1305: "XChaCha20-Poly1305",
+    SuiteAES256GCMSIV:      "AES-256-GCM-SIV",
+}
+
+// cipherSuiteAliases maps command-line names to cipher suites
+var cipherSuiteAliases = map[string]CipherSuite{
+    "aes-gcm":     SuiteAES256GCM,
+    "aes256gcm":   SuiteAES256GCM,
+    "chacha20":    SuiteChaCha20Poly1305,
+    "chacha":      SuiteChaCha20Poly1305,
+    "xchacha20":   SuiteXChaCha20Poly1305,
+    "xchacha":     SuiteXChaCha20Poly1305,
+    "aes-gcm-siv": SuiteAES256GCMSIV,
+    "gcm-siv":     SuiteAES256GCMSIV,
+}
+
+// GetAvailableCipherSuites returns a slice of all supported cipher suites
+func GetAvailableCipherSuites() []CipherSuite {
+    return []CipherSuite{SuiteAES256GCM, SuiteChaCha20Poly1305, SuiteXChaCha20Poly1305, SuiteAES256GCMSIV}
+}
+
+// ParseCipherSuite looks up a cipher suite by its command-line name
+func ParseCipherSuite(name string) (CipherSuite, error) {
+    if suite, ok := cipherSuiteAliases[strings.ToLower(name)]; ok {
+        return suite, nil
+    }
+    return 0, fmt.Errorf("%w: unknown cipher suite %q (valid: aes-gcm, chacha20, xchacha20, aes-gcm-siv)", ErrUnsupportedCipher, name)
+}
+
+// String returns the name of the cipher suite
+func (s CipherSuite) String() string {
+    if name, ok := CipherSuiteNames[s]; ok {
+        return name
+    }
+    return fmt.Sprintf("unknown (0x%02x)", byte(s))
+}
+
+// newAEAD creates the AEAD for this suite with a 32-byte key
+func (s CipherSuite) newAEAD(key []byte) (cipher.AEAD, error) {
+    switch s {
+    case SuiteAES256GCM:
+        block, err := aes.NewCipher(key)
+        if err != nil {
+            return nil, err
+        }
+        return cipher.NewGCM(block)
+    case SuiteChaCha20Poly1305:
+        return chacha20poly1305.New(key)
+    case SuiteXChaCha20Poly1305:
+        return chacha20poly1305.NewX(key)
+    case SuiteAES256GCMSIV:
+        return newGCMSIV(key)
+    default:
+        return nil, fmt.Errorf("%w: %s", ErrUnsupportedCipher, s)
+    }
+}
+
+// overhead returns the number of bytes sealPayload adds to a plaintext
+func (s CipherSuite) overhead() (int, error) {
+    aead, err := s.newAEAD(make([]byte, 32))
+    if err != nil {
+        return 0, err
+    }
+    return 1 + aead.NonceSize() + aead.Overhead(), nil
+}
+
+// seal encrypts data under key, returning nonce || ciphertext
+func (s CipherSuite) seal(key, data, additionalData []byte) ([]byte, error) {
+    aead, err := s.newAEAD(key)
+    if err != nil {
+        return nil, err
+    }
+
+    nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(data)+aead.Overhead())
+    if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
+        return nil, err
+    }
+
+    return aead.Seal(nonce, nonce, data, additionalData), nil
+}
+
+// open decrypts a nonce || ciphertext block produced by seal
+func (s CipherSuite) open(key, data, additionalData []byte) ([]byte, error) {
+    aead, err := s.newAEAD(key)
+    if err != nil {
+        return nil, err
+    }
+
+    nonceSize := aead.NonceSize()
+    if len(data) < nonceSize+aead.Overhead() {
+        return nil, fmt.Errorf("%w: %s payload is %d bytes, shorter than its nonce and tag", ErrMessageCorrupted, s, len(data))
+    }
+
+    plaintext, err := aead.Open(nil, data[:nonceSize], data[nonceSize:], additionalData)
+    if err != nil {
+        return nil, ErrDecryptionFailed
+    }
+    return plaintext, nil
+}
+
+// sealPayload encrypts data and prefixes the suite byte: suite || nonce || ciphertext
+func sealPayload(suite CipherSuite, key, data, additionalData []byte) ([]byte, error) {
+    sealed, err := suite.seal(key, data, additionalData)
+    if err != nil {
+        return nil, err
+    }
+    return append([]byte{byte(suite)}, sealed...), nil
+}
+
+// openPayload decrypts a payload produced by sealPayload, picking the suite from its first byte
+func openPayload(key, data, additionalData []byte) ([]byte, CipherSuite, error) {
+    if len(data) < 1 {
+        return nil, 0, fmt.Errorf("%w: encrypted payload is empty", ErrMessageCorrupted)
+    }
+
+    suite := CipherSuite(data[0])
+    plaintext, err := suite.open(key, data[1:], additionalData)
+    if err != nil {
+        return nil, suite, err
+    }
+    return plaintext, suite, nil
+}
@@ -0,0 +1,89 @@
+package steg
+
+import (
+    "fmt"
+    "mime"
+    "net/http"
+    "strings"
+)
+
+// Typed payloads record their MIME type so the receiver knows what it got.
+// The type is stored in front of the payload, inside any encryption or tag:
+//
+// Layout: [type length(1) | MIME type | payload]
+
+// maxContentTypeLen is the longest MIME type the length byte can describe
+const maxContentTypeLen = 255
+
+// DetectContentType guesses the MIME type of a payload from its first bytes,
+// returning "application/octet-stream" when nothing matches
+func DetectContentType(data []byte) string {
+    return http.DetectContentType(data)
+}
+
+// IsImageType reports whether a MIME type describes an image
+func IsImageType(contentType string) bool {
+    mediaType, _, err := mime.ParseMediaType(contentType)
+    return err == nil && strings.HasPrefix(mediaType, "image/")
+}
+
+// IsTextType reports whether a MIME type describes text
+func IsTextType(contentType string) bool {
+    mediaType, _, err := mime.ParseMediaType(contentType)
+    return err == nil && strings.HasPrefix(mediaType, "text/")
+}
+
+// AddContentType returns data prefixed with its MIME type. The Encoder does this
+// for WithContentType; it is exported for payloads protected outside this
+// package, such as session messages.
+func AddContentType(contentType string, data []byte) ([]byte, error) {
+    if contentType == "" || len(contentType) > maxContentTypeLen {
+        return nil, fmt.Errorf("%w: content type must be 1 to %d bytes", ErrInvalidContentType, maxContentTypeLen)
+    }
+
+    typed := make([]byte, 0, 1+len(contentType)+len(data))
+    typed = append(typed, byte(len(contentType)))
+    typed = append(typed, contentType...)
+    return append(typed, data...), nil
+}
+
+// SplitContentType separates a typed payload into its MIME type and data. The
+// data shares memory with the input.
+func SplitContentType(typed []byte) (string, []byte, error) {
+    if len(typed) < 1 || len(typed) < 1+int(typed[0]) {
+        return "", nil, fmt.Errorf("%w: content type truncated", ErrMessageCorrupted)
+    }
+    n := int(typed[0])
+    return string(typed[1 : 1+n]), typed[1+n:], nil
+}
+
+// typeExtensions picks the usual extension for common types, where the system
+// MIME table may list several or none
+var typeExtensions = map[string]string{
+    "image/png":          ".png",
+    "image/jpeg":         ".jpg",
+    "image/gif":          ".gif",
+    "image/bmp":          ".bmp",
+    "image/webp":         ".webp",
+    "application/pdf":    ".pdf",
+    "text/plain":         ".txt",
+    "text/html":          ".html",
+    "application/zip":    ".zip",
+    "application/x-gzip": ".gz",
+}
+
+// ExtensionForType returns the file extension, with its dot, for a MIME type, or
+// "" when none is known
+func ExtensionForType(contentType string) string {
+    mediaType, _, err := mime.ParseMediaType(contentType)
+    if err != nil {
+        return ""
+    }
+    if ext, ok := typeExtensions[mediaType]; ok {
+        return ext
+    }
+    if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+        return exts[0]
+    }
+    return ""
+}
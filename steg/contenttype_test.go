@@ -0,0 +1,85 @@
+package steg
+
+import (
+    "bytes"
+    "errors"
+    "image/png"
+    "strings"
+    "testing"
+)
+
+func TestDetectContentType(t *testing.T) {
+    var pngData bytes.Buffer
+    if err := png.Encode(&pngData, noisyNRGBA(4, 4)); err != nil {
+        t.Fatal(err)
+    }
+
+    for _, tt := range []struct {
+        name  string
+        data  []byte
+        want  string
+        image bool
+        text  bool
+        ext   string
+    }{
+        {"png", pngData.Bytes(), "image/png", true, false, ".png"},
+        {"pdf", []byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n"), "application/pdf", false, false, ".pdf"},
+        {"text", []byte("meet me at the park"), "text/plain; charset=utf-8", false, true, ".txt"},
+        // The extension depends on the system MIME table, so it is not checked
+        {"binary", []byte{0x00, 0x01, 0x02, 0xfe}, "application/octet-stream", false, false, ""},
+    } {
+        got := DetectContentType(tt.data)
+        if got != tt.want {
+            t.Errorf("%s: detected %q, want %q", tt.name, got, tt.want)
+            continue
+        }
+        if IsImageType(got) != tt.image || IsTextType(got) != tt.text {
+            t.Errorf("%s: image %v, text %v", tt.name, IsImageType(got), IsTextType(got))
+        }
+        if ext := ExtensionForType(got); tt.ext != "" && ext != tt.ext {
+            t.Errorf("%s: extension %q, want %q", tt.name, ext, tt.ext)
+        }
+    }
+}
+
+func TestContentTypeRoundTrip(t *testing.T) {
+    msg := []byte("%PDF-1.7 pretend this is a report")
+    for name, opts := range map[string][]Option{
+        "plain":    nil,
+        "password": {WithPassword(kdfTestPassword), WithKDFCost(MinKDFCost)},
+        "hmac":     {WithHMACKey(hmacTestKey)},
+    } {
+        img, err := NewEncoder(kdfTestCover(), append(opts, WithContentType("application/pdf"))...).EncodeBytes(msg)
+        if err != nil {
+            t.Fatalf("%s: %v", name, err)
+        }
+        p, err := NewDecoder(img, opts...).Decode()
+        if err != nil {
+            t.Fatalf("%s: %v", name, err)
+        }
+        if !p.Header.IsTyped() || p.ContentType != "application/pdf" || !bytes.Equal(p.Data, msg) {
+            t.Errorf("%s: got %q typed %v as %q", name, p.Data, p.Header.IsTyped(), p.ContentType)
+        }
+    }
+
+    // Without the option nothing is recorded
+    img, err := EncodeMessage(kdfTestCover(), msg, LSB1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    p, err := NewDecoder(img).Decode()
+    if err != nil || p.Header.IsTyped() || p.ContentType != "" {
+        t.Errorf("untyped: typed %v, %q, %v", p.Header.IsTyped(), p.ContentType, err)
+    }
+}
+
+func TestContentTypeLimits(t *testing.T) {
+    for _, contentType := range []string{"", strings.Repeat("x", maxContentTypeLen+1)} {
+        if _, err := AddContentType(contentType, []byte("x")); !errors.Is(err, ErrInvalidContentType) {
+            t.Errorf("%d-byte type: got %v, want %v", len(contentType), err, ErrInvalidContentType)
+        }
+    }
+    if _, _, err := SplitContentType([]byte{10, 'a', 'b'}); !errors.Is(err, ErrMessageCorrupted) {
+        t.Errorf("truncated type: got %v, want %v", err, ErrMessageCorrupted)
+    }
+}
@@ -0,0 +1,196 @@
+package steg
+
+import (
+    "context"
+    "image"
+    "io"
+)
+
+// Payload is a payload extracted by a Decoder
+type Payload struct {
+    Header   Header      // Header the payload was stored under (decrypted for stealth images)
+    Suite    CipherSuite // Cipher suite of an encrypted payload, zero otherwise
+    Verified bool        // Whether an HMAC tag was checked and matched
+    Data     []byte      // The extracted payload
+
+    ContentType string // MIME type recorded with WithContentType, if any
+}
+
+// Decoder extracts payloads from stego images. It accepts the same options as an
+// Encoder; the mode and cipher suite are read from the image.
+type Decoder struct {
+    img  image.Image
+    opts options
+
+    found   bool
+    header  Header
+    stealth stealthHeader // Set for stealth images
+}
+
+// NewDecoder returns a decoder for the given stego image
+func NewDecoder(img image.Image, opts ...Option) *Decoder {
+    return &Decoder{img: img, opts: newOptions(opts)}
+}
+
+// Header finds the header of the hidden payload. With WithStealth only a stealth
+// header is looked for; otherwise a stealth header is only tried, when a password
+// is set, if there is no plaintext one. Finding a stealth header stretches the
+// password, so it is not done for images that do not need it.
+func (d *Decoder) Header() (Header, error) {
+    if d.found {
+        return d.header, nil
+    }
+
+    // The stealth header is the larger of the two, so one probe serves both lookups
+    probe := probeImage(d.img, stealthProbeSize)
+
+    if !d.opts.stealth {
+        header, err := probeHeader(d.img, probe)
+        if err == nil {
+            d.found, d.header = true, header
+            return header, nil
+        }
+        if d.opts.password == "" {
+            return Header{}, err
+        }
+    }
+
+    s, err := probeStealthHeader(d.img, probe, d.opts.password, d.opts.kdfCost)
+    if err != nil {
+        return Header{}, err
+    }
+    d.found, d.header, d.stealth = true, s.header, s
+    return s.header, nil
+}
+
+// CipherSuite reports which cipher suite protects the payload, without decrypting
+// it. It fails for payloads that are not encrypted.
+func (d *Decoder) CipherSuite() (CipherSuite, error) {
+    header, err := d.Header()
+    if err != nil {
+        return 0, err
+    }
+    if header.IsStealth() {
+        return d.stealth.suite, nil
+    }
+    return GetCipherSuite(d.img, header)
+}
+
+// Decode extracts the payload, decrypting it or verifying its tag as needed.
+// When an HMAC key is set and the tag does not match, the unverified payload is
+// returned together with ErrAuthenticationFailed.
+func (d *Decoder) Decode() (*Payload, error) {
+    return d.DecodeContext(context.Background())
+}
+
+// DecodeContext is Decode with a context that can cancel the operation
+func (d *Decoder) DecodeContext(ctx context.Context) (*Payload, error) {
+    p, err := d.decode(ctx)
+    if p == nil {
+        return nil, err
+    }
+
+    // Session frames keep the type inside the message, for the session layer
+    if p.Header.IsTyped() && !p.Header.IsSession() {
+        contentType, data, splitErr := SplitContentType(p.Data)
+        if splitErr != nil {
+            Wipe(p.Data)
+            return nil, splitErr
+        }
+        p.ContentType, p.Data = contentType, data
+    }
+    return p, err
+}
+
+// decode extracts the payload as it was stored
+func (d *Decoder) decode(ctx context.Context) (*Payload, error) {
+    header, err := d.Header()
+    if err != nil {
+        return nil, err
+    }
+
+    if header.IsStealth() {
+        return d.openStealth(ctx, d.stealth)
+    }
+
+    // Extract the payload that follows the header
+    if err := checkPayloadLen(d.img, header, header.Size()); err != nil {
+        return nil, err
+    }
+    data, err := extractPayload(ctx, d.img, header.Mode, int(header.PayloadLen), header.Size(), d.opts.progress)
+    if err != nil {
+        return nil, err
+    }
+    p := &Payload{Header: header}
+
+    switch {
+    case header.IsAuthenticated():
+        // Strip the integrity tag, verifying it when a secret is given
+        msg, verified, err := openAuthenticated(header, data, d.opts.hmacKey)
+        if err != nil {
+            return nil, err
+        }
+        p.Data, p.Verified = msg, verified
+        if d.opts.hmacKey != "" && !verified {
+            return p, ErrAuthenticationFailed
+        }
+
+    case header.IsEncrypted():
+        if d.opts.password == "" {
+            return nil, ErrPasswordRequired
+        }
+
+        // Older images carry a bare AES-256-GCM payload that is not bound to the header
+        if header.Version <= legacyCipherVersion {
+            p.Suite = SuiteAES256GCM
+            p.Data, err = decrypt(data, d.opts.password)
+            if err != nil {
+                return nil, err
+            }
+            return p, nil
+        }
+
+        var key *SecretBuffer
+        if header.Version <= legacyKDFVersion {
+            key = deriveKey(d.opts.password, payloadKeyLabel)
+        } else {
+            params, err := parseKDFParams(data, false, d.opts.kdfCost)
+            if err != nil {
+                return nil, err
+            }
+            data = data[kdfParamsSize:]
+            if key, err = deriveStretchedKey(d.opts.password, payloadKeyLabel, params); err != nil {
+                return nil, err
+            }
+        }
+        defer key.Release()
+
+        p.Data, p.Suite, err = openPayload(key.Bytes(), data, MarshalHeader(header))
+        if err != nil {
+            return nil, err
+        }
+
+    default:
+        p.Data = data
+    }
+
+    return p, nil
+}
+
+// WriteTo extracts the payload and writes it to w, so a Decoder can be used as an
+// io.WriterTo. The payload is wiped from memory once written.
+func (d *Decoder) WriteTo(w io.Writer) (int64, error) {
+    return d.WriteToContext(context.Background(), w)
+}
+
+// WriteToContext is WriteTo with a context that can cancel the operation
+func (d *Decoder) WriteToContext(ctx context.Context, w io.Writer) (int64, error) {
+    p, err := d.DecodeContext(ctx)
+    if err != nil {
+        return 0, err
+    }
+    defer Wipe(p.Data)
+
+    n, err := w.Write(p.Data)
+    return int64(n), err
+}
@@ -0,0 +1,129 @@
+package steg
+
+import (
+    "context"
+    "fmt"
+    "image"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+)
+
+// Embedder is a steganography algorithm. Each mode registers one embedder, and
+// everything that works across modes (detection, capacity reporting, mode
+// parsing) goes through the registry instead of switching on the mode.
+//
+// Other packages can add their own modes by registering an embedder from an
+// init function, so a blank import is enough to make the mode available:
+//
+//   import _ "example.com/mosquito-dct"
+type Embedder interface {
+    // ID returns the mode stored in the header. It must be unique and fit in a byte.
+    ID() StegMode
+    // Name returns a short human-readable name, also accepted by ParseMode
+    Name() string
+    // Capacity returns the total number of bytes the image can hold, header included
+    Capacity(img image.Image) int
+    // Embed writes data into img starting at the first pixel. The image is a private
+    // copy of the cover, so it can be modified in place.
+    Embed(img *image.NRGBA, data []byte) error
+    // Extract reads dataSize bytes from img, skipping the first offset bytes
+    Extract(img image.Image, dataSize int, offset int) ([]byte, error)
+}
+
+// ContextEmbedder is optionally implemented by embedders that can be cancelled and
+// can report progress while they work. Embedders without it are only checked for
+// cancellation before and after each call. progress may be nil.
+type ContextEmbedder interface {
+    EmbedContext(ctx context.Context, img *image.NRGBA, data []byte, progress ProgressFunc) error
+    ExtractContext(ctx context.Context, img image.Image, dataSize int, offset int, progress ProgressFunc) ([]byte, error)
+}
+
+// Describer is optionally implemented by embedders that have a longer description
+// for listings such as the info command
+type Describer interface {
+    Description() string
+}
+
+var (
+    registryMu sync.RWMutex
+    registry   = map[StegMode]Embedder{}
+)
+
+// Register makes an embedder available under its ID. It panics if the ID is
+// already taken or out of range, since that is a programming error.
+func Register(e Embedder) {
+    registryMu.Lock()
+    defer registryMu.Unlock()
+
+    id := e.ID()
+    if id < 0 || id > 0xFF {
+        panic(fmt.Sprintf("steg: mode ID %d of %s does not fit in the header", id, e.Name()))
+    }
+    if existing, ok := registry[id]; ok {
+        panic(fmt.Sprintf("steg: mode ID %d registered twice (%s and %s)", id, existing.Name(), e.Name()))
+    }
+
+    registry[id] = e
+    ModeNames[id] = describe(e)
+}
+
+// Lookup returns the embedder registered for a mode
+func Lookup(mode StegMode) (Embedder, error) {
+    registryMu.RLock()
+    defer registryMu.RUnlock()
+
+    e, ok := registry[mode]
+    if !ok {
+        return nil, ErrUnsupportedMode
+    }
+    return e, nil
+}
+
+// Embedders returns all registered embedders ordered by ID
+func Embedders() []Embedder {
+    registryMu.RLock()
+    defer registryMu.RUnlock()
+
+    out := make([]Embedder, 0, len(registry))
+    for _, e := range registry {
+        out = append(out, e)
+    }
+    sort.Slice(out, func(i, j int) bool {
+        return out[i].ID() < out[j].ID()
+    })
+    return out
+}
+
+// ParseMode resolves a mode given as its numeric ID or its name (case-insensitive,
+// dashes optional, so "3", "LSB8", "lsb-8" and "lsb8" all work)
+func ParseMode(s string) (StegMode, error) {
+    if n, err := strconv.Atoi(s); err == nil {
+        if _, err := Lookup(StegMode(n)); err != nil {
+            return 0, fmt.Errorf("%w: %d", ErrUnsupportedMode, n)
+        }
+        return StegMode(n), nil
+    }
+
+    want := normalizeModeName(s)
+    for _, e := range Embedders() {
+        if normalizeModeName(e.Name()) == want {
+            return e.ID(), nil
+        }
+    }
+    return 0, fmt.Errorf("%w: %q", ErrUnsupportedMode, s)
+}
+
+// normalizeModeName lowercases a mode name and strips separators
+func normalizeModeName(s string) string {
+    return strings.NewReplacer("-", "", "_", "", " ", "").Replace(strings.ToLower(s))
+}
+
+// describe returns the longest available name for an embedder
+func describe(e Embedder) string {
+    if d, ok := e.(Describer); ok {
+        return d.Description()
+    }
+    return e.Name()
+}
@@ -0,0 +1,315 @@
+package steg
+
+import (
+    "context"
+    "image"
+    "io"
+)
+
+// Option configures an Encoder or a Decoder
+type Option func(*options)
+
+type options struct {
+    mode     StegMode
+    password string
+    suite    CipherSuite
+    stealth  bool
+    hmacKey  string
+    flags    MessageFlags
+    format   string
+    progress ProgressFunc
+    kdfCost  int
+
+    contentType string
+}
+
+func newOptions(opts []Option) options {
+    o := options{
+        mode:    LSB1,
+        suite:   DefaultCipherSuite,
+        format:  "png",
+        kdfCost: DefaultKDFCost,
+    }
+    for _, opt := range opts {
+        opt(&o)
+    }
+    return o
+}
+
+// WithMode selects the steganography mode used to embed the payload (default LSB1)
+func WithMode(mode StegMode) Option {
+    return func(o *options) { o.mode = mode }
+}
+
+// WithPassword encrypts the payload when encoding and decrypts it when decoding
+func WithPassword(password string) Option {
+    return func(o *options) { o.password = password }
+}
+
+// WithCipher selects the cipher suite for encrypted payloads (default AES-256-GCM)
+func WithCipher(suite CipherSuite) Option {
+    return func(o *options) { o.suite = suite }
+}
+
+// WithKDFCost sets the scrypt cost the password is stretched with when encoding,
+// from MinKDFCost to MaxKDFCost (default DefaultKDFCost). Each step up doubles
+// the memory and time it takes, for us and for anyone guessing the password.
+// Decoders read the cost from the image, and refuse one above this, so a
+// crafted image cannot make them spend more.
+func WithKDFCost(cost int) Option {
+    return func(o *options) { o.kdfCost = cost }
+}
+
+// WithStealth encrypts the header as well as the payload, see EncodeMessageStealth.
+// A Decoder given this option only looks for stealth payloads.
+func WithStealth() Option {
+    return func(o *options) { o.stealth = true }
+}
+
+// WithHMACKey stores the payload unencrypted with an HMAC-SHA256 tag keyed by
+// secret, and verifies that tag when decoding. Unlike a password the secret is
+// hashed without salt or stretching, so it should be a random key: anyone with
+// the image can test guesses against the tag as fast as they can hash.
+func WithHMACKey(secret string) Option {
+    return func(o *options) { o.hmacKey = secret }
+}
+
+// WithFlags sets extra header flags, such as FlagImage for image payloads or
+// FlagSession for payloads encrypted outside this package
+func WithFlags(flags MessageFlags) Option {
+    return func(o *options) { o.flags |= flags }
+}
+
+// WithFormat selects the image format written by Encoder.EncodeTo (default png).
+// See WriteImage for the supported formats.
+func WithFormat(format string) Option {
+    return func(o *options) { o.format = format }
+}
+
+// WithContentType records the MIME type of the payload, which a Decoder returns
+// in Payload.ContentType. See DetectContentType.
+func WithContentType(contentType string) Option {
+    return func(o *options) {
+        o.contentType = contentType
+        o.flags |= FlagTyped
+    }
+}
+
+// WithProgress reports how much of the payload has been embedded or extracted
+func WithProgress(progress ProgressFunc) Option {
+    return func(o *options) { o.progress = progress }
+}
+
+// imageFlag returns FlagImage for image payloads, for the positional wrappers
+func imageFlag(isImage bool) MessageFlags {
+    if isImage {
+        return FlagImage
+    }
+    return 0
+}
+
+// Encoder hides payloads in a cover image. The cover is never modified; every
+// call returns a new image.
+type Encoder struct {
+    cover image.Image
+    opts  options
+}
+
+// NewEncoder returns an encoder for the given cover image
+func NewEncoder(cover image.Image, opts ...Option) *Encoder {
+    return &Encoder{cover: cover, opts: newOptions(opts)}
+}
+
+// Encode reads the whole payload from r and returns the stego image
+func (e *Encoder) Encode(r io.Reader) (image.Image, error) {
+    return e.EncodeContext(context.Background(), r)
+}
+
+// EncodeContext is Encode with a context that can cancel the operation
+func (e *Encoder) EncodeContext(ctx context.Context, r io.Reader) (image.Image, error) {
+    payload, err := io.ReadAll(r)
+    if err != nil {
+        return nil, err
+    }
+    defer Wipe(payload)
+
+    return e.EncodeBytesContext(ctx, payload)
+}
+
+// EncodeTo reads the whole payload from r and writes the stego image to w in the
+// configured format
+func (e *Encoder) EncodeTo(w io.Writer, r io.Reader) error {
+    return e.EncodeToContext(context.Background(), w, r)
+}
+
+// EncodeToContext is EncodeTo with a context that can cancel the operation,
+// including while the image is being written
+func (e *Encoder) EncodeToContext(ctx context.Context, w io.Writer, r io.Reader) error {
+    out, err := e.EncodeContext(ctx, r)
+    if err != nil {
+        return err
+    }
+    return WriteImage(&contextWriter{ctx: ctx, w: w}, out, e.opts.format)
+}
+
+// EncodeBytes hides payload and returns the stego image
+func (e *Encoder) EncodeBytes(payload []byte) (image.Image, error) {
+    return e.EncodeBytesContext(context.Background(), payload)
+}
+
+// EncodeBytesContext is EncodeBytes with a context that can cancel the operation
+func (e *Encoder) EncodeBytesContext(ctx context.Context, payload []byte) (image.Image, error) {
+    if e.opts.stealth && e.opts.password == "" {
+        return nil, ErrInvalidKey
+    }
+    if e.opts.hmacKey != "" && e.opts.password != "" {
+        return nil, ErrConflictingOptions
+    }
+    if e.opts.password != "" {
+        if err := checkKDFCost(e.opts.kdfCost); err != nil {
+            return nil, err
+        }
+    }
+
+    // The type goes inside the payload so it is protected like the rest
+    if e.opts.contentType != "" {
+        typed, err := AddContentType(e.opts.contentType, payload)
+        if err != nil {
+            return nil, err
+        }
+        defer Wipe(typed)
+        payload = typed
+    }
+
+    switch {
+    case e.opts.stealth:
+        return e.encodeStealth(ctx, payload)
+    case e.opts.hmacKey != "":
+        return e.encodeAuthenticated(ctx, payload)
+    case e.opts.password != "":
+        return e.encodeEncrypted(ctx, payload)
+    default:
+        return e.encodePlain(ctx, payload)
+    }
+}
+
+// CheckCapacity reports whether a payload of payloadLen bytes fits the cover
+// with the encoder's options, without encoding anything or running the key
+// derivation. It returns a *CapacityError when it does not.
+func (e *Encoder) CheckCapacity(payloadLen int) error {
+    if e.opts.contentType != "" {
+        payloadLen += 1 + len(e.opts.contentType)
+    }
+    dataLen, headerLen, err := e.layout(payloadLen)
+    if err != nil {
+        return err
+    }
+    return checkCapacity(e.cover, dataLen, headerLen, e.opts.mode)
+}
+
+// layout returns the number of bytes stored after the header for a payload of
+// payloadLen bytes, which is what the header records, and the size of the
+// header in front of them
+func (e *Encoder) layout(payloadLen int) (dataLen, headerLen int, err error) {
+    switch {
+    case e.opts.stealth:
+        // The suite overhead includes a suite byte that stealth mode keeps in
+        // the header, and the key derivation block goes in front of the header
+        overhead, err := e.opts.suite.overhead()
+        if err != nil {
+            return 0, 0, err
+        }
+        return overhead - 1 + payloadLen, stealthProbeSize, nil
+    case e.opts.hmacKey != "":
+        return payloadLen + HMACTagSize, headerSize, nil
+    case e.opts.password != "":
+        // Account for the key derivation parameters, suite byte, nonce and tag
+        overhead, err := e.opts.suite.overhead()
+        if err != nil {
+            return 0, 0, err
+        }
+        return kdfParamsSize + payloadLen + overhead, headerSize, nil
+    }
+    return payloadLen, headerSize, nil
+}
+
+// encodePlain embeds the payload as it is, behind a plaintext header
+func (e *Encoder) encodePlain(ctx context.Context, payload []byte) (image.Image, error) {
+    if err := checkCapacity(e.cover, len(payload), headerSize, e.opts.mode); err != nil {
+        return nil, err
+    }
+
+    header := e.header(len(payload))
+    return e.embed(ctx, append(MarshalHeader(header), payload...))
+}
+
+// encodeEncrypted encrypts the payload with the configured cipher suite
+func (e *Encoder) encodeEncrypted(ctx context.Context, payload []byte) (image.Image, error) {
+    payloadLen, headerLen, err := e.layout(len(payload))
+    if err != nil {
+        return nil, err
+    }
+    if err := checkCapacity(e.cover, payloadLen, headerLen, e.opts.mode); err != nil {
+        return nil, err
+    }
+
+    header := e.header(payloadLen)
+    header.Flags |= FlagEncrypted
+    headerData := MarshalHeader(header)
+
+    params, err := newKDFParams(e.opts.kdfCost)
+    if err != nil {
+        return nil, err
+    }
+    key, err := deriveStretchedKey(e.opts.password, payloadKeyLabel, params)
+    if err != nil {
+        return nil, ErrEncryptionFailed
+    }
+    defer key.Release()
+
+    // The header is bound as associated data so tampering with it is detected
+    sealed, err := sealPayload(e.opts.suite, key.Bytes(), payload, headerData)
+    if err != nil {
+        return nil, ErrEncryptionFailed
+    }
+
+    data := append(headerData, params.marshal(false)...)
+    return e.embed(ctx, append(data, sealed...))
+}
+
+// header returns a plaintext header for a payload of payloadLen bytes
+func (e *Encoder) header(payloadLen int) Header {
+    return Header{
+        Magic:      MagicByte,
+        Version:    Version,
+        Mode:       e.opts.mode,
+        Flags:      e.opts.flags,
+        PayloadLen: uint32(payloadLen),
+    }
+}
+
+// embed writes data into a copy of the cover image
+func (e *Encoder) embed(ctx context.Context, data []byte) (image.Image, error) {
+    out, err := copyToNRGBA(ctx, e.cover)
+    if err != nil {
+        return nil, err
+    }
+    if err := embedData(ctx, out, data, e.opts.mode, e.opts.progress); err != nil {
+        return nil, err
+    }
+    return out, nil
+}
+
+// contextWriter fails writes once its context is done, so encoding a large image
+// stops early when the operation is cancelled
+type contextWriter struct {
+    ctx context.Context
+    w   io.Writer
+}
+
+func (cw *contextWriter) Write(p []byte) (int, error) {
+    if err := cw.ctx.Err(); err != nil {
+        return 0, err
+    }
+    return cw.w.Write(p)
+}
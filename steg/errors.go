@@ -1,16 +1,63 @@
 package steg
 
-import "errors"
+import (
+    "errors"
+    "fmt"
+)
 
 // Error types for steganography operations
 var (
-    ErrInvalidHeader     = errors.New("invalid steganography header")
-    ErrInvalidMagic      = errors.New("invalid magic byte, not a Mosquito steganographic image")
-    ErrUnsupportedMode   = errors.New("unsupported steganography mode")
-    ErrImageTooSmall     = errors.New("image too small to encode payload")
-    ErrMessageCorrupted  = errors.New("message data corrupted or truncated")
-    ErrEncryptionFailed  = errors.New("encryption failed")
-    ErrDecryptionFailed  = errors.New("decryption failed, invalid key or corrupted data")
-    ErrInvalidImage      = errors.New("invalid or unsupported image format")
-    ErrInvalidKey        = errors.New("invalid encryption key")
-)
\ No newline at end of file
+    ErrInvalidHeader        = errors.New("invalid steganography header")
+    ErrInvalidMagic         = errors.New("invalid magic byte, not a Mosquito steganographic image")
+    ErrUnsupportedMode      = errors.New("unsupported steganography mode")
+    ErrImageTooSmall        = errors.New("image too small to encode payload")
+    ErrMessageCorrupted     = errors.New("message data corrupted or truncated")
+    ErrEncryptionFailed     = errors.New("encryption failed")
+    ErrDecryptionFailed     = errors.New("decryption failed, invalid key or corrupted data")
+    ErrPasswordRequired     = errors.New("payload is encrypted, a password is required")
+    ErrInvalidImage         = errors.New("invalid or unsupported image format")
+    ErrInvalidKey           = errors.New("invalid encryption key")
+    ErrUnsupportedCipher    = errors.New("unsupported cipher suite")
+    ErrAuthenticationFailed = errors.New("integrity check failed, payload modified or wrong key")
+    ErrNotAuthenticated     = errors.New("payload has no integrity tag")
+    ErrNoStealthPayload     = errors.New("no stealth payload found for this password")
+    ErrConflictingOptions   = errors.New("an HMAC key cannot be combined with a password")
+    ErrUnsupportedFormat    = errors.New("unsupported output image format")
+    ErrInvalidContentType   = errors.New("invalid content type")
+    ErrInvalidKDFCost       = errors.New("invalid key derivation cost")
+)
+
+// CapacityError reports a payload that does not fit in the cover image. It wraps
+// ErrImageTooSmall.
+type CapacityError struct {
+    Required  int      `json:"required"`  // Bytes needed, including encryption overhead
+    Available int      `json:"available"` // Bytes the image can hold after the header
+    Mode      StegMode `json:"mode"`
+}
+
+func (e *CapacityError) Error() string {
+    return fmt.Sprintf("%v: %d bytes required, %d available in %s", ErrImageTooSmall, e.Required, e.Available, e.Mode)
+}
+
+func (e *CapacityError) Unwrap() error {
+    return ErrImageTooSmall
+}
+
+// HeaderError reports a header that is missing or cannot be used. It always
+// matches ErrInvalidHeader, and also Err when that gives a more specific cause.
+type HeaderError struct {
+    Offset int    `json:"offset"` // Byte offset of the offending header field
+    Reason string `json:"reason"`
+    Err    error  `json:"-"`
+}
+
+func (e *HeaderError) Error() string {
+    return fmt.Sprintf("%v at byte %d: %s", ErrInvalidHeader, e.Offset, e.Reason)
+}
+
+func (e *HeaderError) Unwrap() []error {
+    if e.Err == nil {
+        return []error{ErrInvalidHeader}
+    }
+    return []error{ErrInvalidHeader, e.Err}
+}
@@ -0,0 +1,58 @@
+package steg
+
+import (
+    "errors"
+    "testing"
+)
+
+func TestCapacityError(t *testing.T) {
+    cover := noisyNRGBA(16, 16)
+    _, err := NewEncoder(cover, WithMode(LSB3)).EncodeBytes(make([]byte, 1024))
+    if !errors.Is(err, ErrImageTooSmall) {
+        t.Fatalf("got %v, want ErrImageTooSmall", err)
+    }
+    var capErr *CapacityError
+    if !errors.As(err, &capErr) {
+        t.Fatalf("%v is not a CapacityError", err)
+    }
+    if capErr.Mode != LSB3 || capErr.Required < 1024 || capErr.Available >= capErr.Required {
+        t.Errorf("details %+v do not describe a 1024 byte payload in LSB3", *capErr)
+    }
+}
+
+func TestHeaderError(t *testing.T) {
+    for _, tt := range []struct {
+        name  string
+        err   func() error
+        magic bool
+    }{
+        {"no payload", func() error {
+            _, err := NewDecoder(noisyNRGBA(16, 16)).Header()
+            return err
+        }, true},
+        {"bad magic", func() error {
+            _, err := UnmarshalHeader([]byte{MagicByte ^ 0xFF, 1, 0, 0, 0, 0, 0, 0})
+            return err
+        }, true},
+        {"truncated", func() error {
+            _, err := UnmarshalHeader([]byte{MagicByte, 1})
+            return err
+        }, false},
+    } {
+        err := tt.err()
+        var hdrErr *HeaderError
+        if !errors.As(err, &hdrErr) {
+            t.Errorf("%s: %v is not a HeaderError", tt.name, err)
+            continue
+        }
+        if !errors.Is(err, ErrInvalidHeader) {
+            t.Errorf("%s: %v does not match ErrInvalidHeader", tt.name, err)
+        }
+        if errors.Is(err, ErrInvalidMagic) != tt.magic {
+            t.Errorf("%s: errors.Is(ErrInvalidMagic) = %v, want %v", tt.name, !tt.magic, tt.magic)
+        }
+        if hdrErr.Reason == "" {
+            t.Errorf("%s: no reason given", tt.name)
+        }
+    }
+}
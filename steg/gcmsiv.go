@@ -0,0 +1,240 @@
+package steg
+
+import (
+    "crypto/aes"
+    "crypto/cipher"
+    "crypto/subtle"
+    "encoding/binary"
+    "errors"
+)
+
+// AES-256-GCM-SIV (RFC 8452) is a nonce-misuse-resistant AEAD: repeating a nonce
+// only reveals whether two messages were identical, instead of breaking
+// confidentiality and authenticity the way it does with plain GCM.
+
+const (
+    gcmSIVNonceSize = 12
+    gcmSIVTagSize   = 16
+)
+
+var errGCMSIVOpen = errors.New("gcm-siv: message authentication failed")
+
+// gcmSIV implements cipher.AEAD for AES-256-GCM-SIV
+type gcmSIV struct {
+    keyGen cipher.Block
+}
+
+// newGCMSIV returns an AES-256-GCM-SIV AEAD for a 32-byte key
+func newGCMSIV(key []byte) (cipher.AEAD, error) {
+    if len(key) != 32 {
+        return nil, ErrInvalidKey
+    }
+    block, err := aes.NewCipher(key)
+    if err != nil {
+        return nil, err
+    }
+    return &gcmSIV{keyGen: block}, nil
+}
+
+func (g *gcmSIV) NonceSize() int { return gcmSIVNonceSize }
+
+func (g *gcmSIV) Overhead() int { return gcmSIVTagSize }
+
+// deriveKeys computes the per-nonce POLYVAL and AES-256 keys (RFC 8452 section 4)
+func (g *gcmSIV) deriveKeys(nonce []byte) (authKey [16]byte, encBlock cipher.Block) {
+    var in, out [16]byte
+    var encKey [32]byte
+    copy(in[4:], nonce)
+
+    for i := uint32(0); i < 6; i++ {
+        binary.LittleEndian.PutUint32(in[:4], i)
+        g.keyGen.Encrypt(out[:], in[:])
+        if i < 2 {
+            copy(authKey[i*8:], out[:8])
+        } else {
+            copy(encKey[(i-2)*8:], out[:8])
+        }
+    }
+
+    // A 32-byte key is always valid for AES
+    encBlock, _ = aes.NewCipher(encKey[:])
+    return authKey, encBlock
+}
+
+// tag computes the authentication tag over plaintext and additional data
+func (g *gcmSIV) tag(authKey [16]byte, encBlock cipher.Block, nonce, plaintext, additionalData []byte) [16]byte {
+    p := newPolyval(authKey)
+    p.update(additionalData)
+    p.update(plaintext)
+
+    var lengths [16]byte
+    binary.LittleEndian.PutUint64(lengths[:8], uint64(len(additionalData))*8)
+    binary.LittleEndian.PutUint64(lengths[8:], uint64(len(plaintext))*8)
+    p.update(lengths[:])
+
+    s := p.sum()
+    for i := range nonce {
+        s[i] ^= nonce[i]
+    }
+    s[15] &= 0x7f
+
+    var tag [16]byte
+    encBlock.Encrypt(tag[:], s[:])
+    return tag
+}
+
+// ctr applies the AES-CTR keystream derived from the tag to in, writing to out
+func (g *gcmSIV) ctr(encBlock cipher.Block, tag [16]byte, out, in []byte) {
+    counter := tag
+    counter[15] |= 0x80
+    ctr := binary.LittleEndian.Uint32(counter[:4])
+
+    var stream [16]byte
+    for len(in) > 0 {
+        binary.LittleEndian.PutUint32(counter[:4], ctr)
+        encBlock.Encrypt(stream[:], counter[:])
+        n := subtle.XORBytes(out, in, stream[:])
+        in, out = in[n:], out[n:]
+        ctr++
+    }
+}
+
+func (g *gcmSIV) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+    if len(nonce) != gcmSIVNonceSize {
+        panic("gcm-siv: incorrect nonce length")
+    }
+
+    authKey, encBlock := g.deriveKeys(nonce)
+    tag := g.tag(authKey, encBlock, nonce, plaintext, additionalData)
+
+    ret, out := sliceForAppend(dst, len(plaintext)+gcmSIVTagSize)
+    g.ctr(encBlock, tag, out[:len(plaintext)], plaintext)
+    copy(out[len(plaintext):], tag[:])
+    return ret
+}
+
+func (g *gcmSIV) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+    if len(nonce) != gcmSIVNonceSize {
+        panic("gcm-siv: incorrect nonce length")
+    }
+    if len(ciphertext) < gcmSIVTagSize {
+        return nil, errGCMSIVOpen
+    }
+
+    var tag [16]byte
+    copy(tag[:], ciphertext[len(ciphertext)-gcmSIVTagSize:])
+    ciphertext = ciphertext[:len(ciphertext)-gcmSIVTagSize]
+
+    authKey, encBlock := g.deriveKeys(nonce)
+
+    ret, out := sliceForAppend(dst, len(ciphertext))
+    g.ctr(encBlock, tag, out, ciphertext)
+
+    expected := g.tag(authKey, encBlock, nonce, out, additionalData)
+    if subtle.ConstantTimeCompare(expected[:], tag[:]) != 1 {
+        for i := range out {
+            out[i] = 0
+        }
+        return nil, errGCMSIVOpen
+    }
+    return ret, nil
+}
+
+// sliceForAppend extends in by n bytes, returning the whole slice and the new tail
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+    if total := len(in) + n; cap(in) >= total {
+        head = in[:total]
+    } else {
+        head = make([]byte, total)
+        copy(head, in)
+    }
+    tail = head[len(in):]
+    return
+}
+
+// ========================= POLYVAL =========================
+
+// fieldElement is an element of GF(2^128) as used by POLYVAL, stored little-endian
+// so that bit i of the 128-bit value is the coefficient of x^i
+type fieldElement struct {
+    lo, hi uint64
+}
+
+// xInv128 is x^-128 in the POLYVAL field, used to turn a plain product into
+// POLYVAL's dot(a, b) = a * b * x^-128
+var xInv128 = func() fieldElement {
+    // x^-1 = x^127 + x^126 + x^125 + x^120, since x * x^-1 = P(x) - 1
+    xInv := fieldElement{hi: 1<<63 | 1<<62 | 1<<61 | 1<<56}
+    r := fieldElement{lo: 1}
+    for i := 0; i < 128; i++ {
+        r = r.mul(xInv)
+    }
+    return r
+}()
+
+func loadElement(b []byte) fieldElement {
+    return fieldElement{
+        lo: binary.LittleEndian.Uint64(b[:8]),
+        hi: binary.LittleEndian.Uint64(b[8:16]),
+    }
+}
+
+// mul returns a * b mod x^128 + x^127 + x^126 + x^121 + 1
+func (a fieldElement) mul(b fieldElement) fieldElement {
+    var r fieldElement
+    for i := 127; i >= 0; i-- {
+        // r = r * x
+        carry := r.hi >> 63
+        r.hi = r.hi<<1 | r.lo>>63
+        r.lo <<= 1
+        if carry != 0 {
+            // x^128 = x^127 + x^126 + x^121 + 1
+            r.hi ^= 1<<63 | 1<<62 | 1<<57
+            r.lo ^= 1
+        }
+
+        var bit uint64
+        if i >= 64 {
+            bit = (b.hi >> (i - 64)) & 1
+        } else {
+            bit = (b.lo >> i) & 1
+        }
+        mask := -bit
+        r.lo ^= a.lo & mask
+        r.hi ^= a.hi & mask
+    }
+    return r
+}
+
+// polyval accumulates POLYVAL(H, X_1, ..., X_n) over zero-padded input
+type polyval struct {
+    h fieldElement // H * x^-128, so each step is a single multiplication
+    s fieldElement
+}
+
+func newPolyval(key [16]byte) *polyval {
+    return &polyval{h: loadElement(key[:]).mul(xInv128)}
+}
+
+// update absorbs data, zero-padding the final partial block
+func (p *polyval) update(data []byte) {
+    var block [16]byte
+    for len(data) > 0 {
+        n := copy(block[:], data)
+        for i := n; i < 16; i++ {
+            block[i] = 0
+        }
+        x := loadElement(block[:])
+        p.s.lo ^= x.lo
+        p.s.hi ^= x.hi
+        p.s = p.s.mul(p.h)
+        data = data[n:]
+    }
+}
+
+func (p *polyval) sum() [16]byte {
+    var out [16]byte
+    binary.LittleEndian.PutUint64(out[:8], p.s.lo)
+    binary.LittleEndian.PutUint64(out[8:], p.s.hi)
+    return out
+}
@@ -0,0 +1,181 @@
+package steg
+
+import (
+    "bytes"
+    "encoding/hex"
+    "testing"
+)
+
+// gcmSIVVectors are the AES-256-GCM-SIV test vectors of RFC 8452, Appendix C.2
+var gcmSIVVectors = []struct {
+    key, nonce, aad, plaintext, result string
+}{
+    {
+        key:    "0100000000000000000000000000000000000000000000000000000000000000",
+        nonce:  "030000000000000000000000",
+        result: "07f5f4169bbf55a8400cd47ea6fd400f",
+    },
+    {
+        key:       "0100000000000000000000000000000000000000000000000000000000000000",
+        nonce:     "030000000000000000000000",
+        plaintext: "0100000000000000",
+        result:    "c2ef328e5c71c83b843122130f7364b761e0b97427e3df28",
+    },
+    {
+        key:       "0100000000000000000000000000000000000000000000000000000000000000",
+        nonce:     "030000000000000000000000",
+        plaintext: "010000000000000000000000",
+        result:    "9aab2aeb3faa0a34aea8e2b18ca50da9ae6559e48fd10f6e5c9ca17e",
+    },
+    {
+        key:       "0100000000000000000000000000000000000000000000000000000000000000",
+        nonce:     "030000000000000000000000",
+        plaintext: "01000000000000000000000000000000",
+        result:    "85a01b63025ba19b7fd3ddfc033b3e76c9eac6fa700942702e90862383c6c366",
+    },
+    {
+        key:       "0100000000000000000000000000000000000000000000000000000000000000",
+        nonce:     "030000000000000000000000",
+        plaintext: "0100000000000000000000000000000002000000000000000000000000000000",
+        result:    "4a6a9db4c8c6549201b9edb53006cba821ec9cf850948a7c86c68ac7539d027fe819e63abcd020b006a976397632eb5d",
+    },
+    {
+        key:       "0100000000000000000000000000000000000000000000000000000000000000",
+        nonce:     "030000000000000000000000",
+        aad:       "01",
+        plaintext: "0200000000000000",
+        result:    "1de22967237a813291213f267e3b452f02d01ae33e4ec854",
+    },
+    {
+        key:       "0100000000000000000000000000000000000000000000000000000000000000",
+        nonce:     "030000000000000000000000",
+        aad:       "01",
+        plaintext: "020000000000000000000000",
+        result:    "163d6f9cc1b346cd453a2e4cc1a4a19ae800941ccdc57cc8413c277f",
+    },
+    {
+        key:       "0100000000000000000000000000000000000000000000000000000000000000",
+        nonce:     "030000000000000000000000",
+        aad:       "01",
+        plaintext: "02000000000000000000000000000000",
+        result:    "c91545823cc24f17dbb0e9e807d5ec17b292d28ff61189e8e49f3875ef91aff7",
+    },
+    {
+        key:       "0100000000000000000000000000000000000000000000000000000000000000",
+        nonce:     "030000000000000000000000",
+        aad:       "010000000000000000000000",
+        plaintext: "02000000",
+        result:    "22b3f4cd1835e517741dfddccfa07fa4661b74cf",
+    },
+    {
+        key:    "e66021d5eb8e4f4066d4adb9c33560e4f46e44bb3da0015c94f7088736864200",
+        nonce:  "e0eaf5284d884a0e77d31646",
+        result: "169fbb2fbf389a995f6390af22228a62",
+    },
+    {
+        key:       "bae8e37fc83441b16034566b7a806c46bb91c3c5aedb64a6c590bc84d1a5e269",
+        nonce:     "e4b47801afc0577e34699b9e",
+        aad:       "4fbdc66f14",
+        plaintext: "671fdd",
+        result:    "0eaccb93da9bb81333aee0c785b240d319719d",
+    },
+}
+
+func mustHex(t *testing.T, s string) []byte {
+    t.Helper()
+    b, err := hex.DecodeString(s)
+    if err != nil {
+        t.Fatal(err)
+    }
+    return b
+}
+
+func TestGCMSIVVectors(t *testing.T) {
+    for i, v := range gcmSIVVectors {
+        aead, err := newGCMSIV(mustHex(t, v.key))
+        if err != nil {
+            t.Fatal(err)
+        }
+        nonce, aad, plaintext, want := mustHex(t, v.nonce), mustHex(t, v.aad), mustHex(t, v.plaintext), mustHex(t, v.result)
+
+        got := aead.Seal(nil, nonce, plaintext, aad)
+        if !bytes.Equal(got, want) {
+            t.Errorf("vector %d: Seal = %x, want %x", i, got, want)
+        }
+        opened, err := aead.Open(nil, nonce, want, aad)
+        if err != nil {
+            t.Errorf("vector %d: Open: %v", i, err)
+        } else if !bytes.Equal(opened, plaintext) {
+            t.Errorf("vector %d: Open = %x, want %x", i, opened, plaintext)
+        }
+    }
+}
+
+func TestGCMSIVRoundTrip(t *testing.T) {
+    key := bytes.Repeat([]byte{0x42}, 32)
+    nonce := bytes.Repeat([]byte{0x07}, gcmSIVNonceSize)
+    aead, err := newGCMSIV(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if aead.NonceSize() != gcmSIVNonceSize || aead.Overhead() != gcmSIVTagSize {
+        t.Fatalf("NonceSize %d, Overhead %d", aead.NonceSize(), aead.Overhead())
+    }
+
+    // Lengths around the block size exercise the partial last block
+    for _, n := range []int{0, 1, 15, 16, 17, 31, 32, 33, 1000} {
+        plaintext := bytes.Repeat([]byte{byte(n)}, n)
+        aad := []byte("header")
+        prefix := []byte("dst")
+
+        sealed := aead.Seal(append([]byte{}, prefix...), nonce, plaintext, aad)
+        if !bytes.HasPrefix(sealed, prefix) || len(sealed) != len(prefix)+n+gcmSIVTagSize {
+            t.Fatalf("%d bytes: Seal did not append to dst", n)
+        }
+        opened, err := aead.Open(nil, nonce, sealed[len(prefix):], aad)
+        if err != nil {
+            t.Fatalf("%d bytes: Open: %v", n, err)
+        }
+        if !bytes.Equal(opened, plaintext) {
+            t.Fatalf("%d bytes: round trip changed the plaintext", n)
+        }
+    }
+}
+
+func TestGCMSIVRejectsTampering(t *testing.T) {
+    aead, err := newGCMSIV(bytes.Repeat([]byte{0x42}, 32))
+    if err != nil {
+        t.Fatal(err)
+    }
+    nonce := make([]byte, gcmSIVNonceSize)
+    aad := []byte("associated data")
+    sealed := aead.Seal(nil, nonce, []byte("attack at dawn, bring snacks"), aad)
+
+    flip := func(b []byte, i int) []byte {
+        b = bytes.Clone(b)
+        b[i] ^= 0x01
+        return b
+    }
+    for _, tt := range []struct {
+        name       string
+        ciphertext []byte
+        aad        []byte
+        nonce      []byte
+    }{
+        {"tag", flip(sealed, len(sealed)-1), aad, nonce},
+        {"ciphertext", flip(sealed, 0), aad, nonce},
+        {"associated data", sealed, flip(aad, 3), nonce},
+        {"missing associated data", sealed, nil, nonce},
+        {"nonce", sealed, aad, flip(nonce, 11)},
+        {"truncated", sealed[:len(sealed)-1], aad, nonce},
+        {"shorter than a tag", sealed[:gcmSIVTagSize-1], aad, nonce},
+    } {
+        if out, err := aead.Open(nil, tt.nonce, tt.ciphertext, tt.aad); err == nil {
+            t.Errorf("%s changed: Open accepted it and returned %q", tt.name, out)
+        }
+    }
+
+    if _, err := newGCMSIV(make([]byte, 16)); err != ErrInvalidKey {
+        t.Errorf("16-byte key: got %v, want %v", err, ErrInvalidKey)
+    }
+}
@@ -3,13 +3,20 @@ package steg
 import (
     "bytes"
     "encoding/binary"
+    "fmt"
 )
 
 const (
     // MagicByte identifies a Mosquito steganography header
     MagicByte byte = 0x53
     // Version of the header format
-    Version byte = 0x02
+    Version byte = 0x04
+    // legacyCipherVersion is the last version whose encrypted payloads carry no
+    // cipher-suite byte and are not bound to the header
+    legacyCipherVersion byte = 0x02
+    // legacyKDFVersion is the last version whose encryption keys are derived
+    // from an unsalted hash of the password, see kdfParams
+    legacyKDFVersion byte = 0x03
 )
 
 // MessageFlags for different payload types and features
@@ -22,8 +29,20 @@ const (
     FlagCompressed
     // FlagImage indicates the payload is an image
     FlagImage
+    // FlagStealth indicates the header itself is encrypted (never set in a plaintext header)
+    FlagStealth
+    // FlagAuthenticated indicates the plaintext payload is followed by an HMAC-SHA256 tag
+    FlagAuthenticated
+    // FlagSession indicates the payload is a session frame (handshake or ratcheted message)
+    FlagSession
+    // FlagTyped indicates the payload starts with its MIME type, see AddContentType.
+    // For session frames the type is inside the decrypted message.
+    FlagTyped
 )
 
+// headerSize is the size of a marshalled header in bytes
+const headerSize = 8
+
 // Header represents the metadata for a hidden message
 type Header struct {
     Magic     byte        // Magic byte (0x53)
@@ -46,8 +65,11 @@ func MarshalHeader(h Header) []byte {
 
 // UnmarshalHeader parses bytes into a header
 func UnmarshalHeader(data []byte) (Header, error) {
-    if len(data) < 8 {
-        return Header{}, ErrInvalidHeader
+    if len(data) < headerSize {
+        return Header{}, &HeaderError{
+            Offset: len(data),
+            Reason: fmt.Sprintf("truncated, %d of %d bytes", len(data), headerSize),
+        }
     }
 
     h := Header{
@@ -59,7 +81,11 @@ func UnmarshalHeader(data []byte) (Header, error) {
     }
 
     if h.Magic != MagicByte {
-        return Header{}, ErrInvalidMagic
+        return Header{}, &HeaderError{
+            Offset: 0,
+            Reason: fmt.Sprintf("magic byte is 0x%02x, expected 0x%02x", h.Magic, MagicByte),
+            Err:    ErrInvalidMagic,
+        }
     }
 
     return h, nil
@@ -67,7 +93,7 @@ func UnmarshalHeader(data []byte) (Header, error) {
 
 // Size returns the size of the header in bytes
 func (h Header) Size() int {
-    return 8 // Magic(1) + Version(1) + Mode(1) + Flags(1) + PayloadLen(4)
+    return headerSize // Magic(1) + Version(1) + Mode(1) + Flags(1) + PayloadLen(4)
 }
 
 // IsEncrypted returns true if the payload is encrypted
@@ -83,4 +109,24 @@ func (h Header) IsCompressed() bool {
 // IsImage returns true if the payload is an image
 func (h Header) IsImage() bool {
     return (h.Flags & FlagImage) != 0
+}
+
+// IsAuthenticated returns true if the payload carries an HMAC integrity tag
+func (h Header) IsAuthenticated() bool {
+    return (h.Flags & FlagAuthenticated) != 0
+}
+
+// IsSession returns true if the payload is a session frame
+func (h Header) IsSession() bool {
+    return (h.Flags & FlagSession) != 0
+}
+
+// IsStealth returns true if the header was stored encrypted
+func (h Header) IsStealth() bool {
+    return (h.Flags & FlagStealth) != 0
+}
+
+// IsTyped returns true if the payload records its MIME type
+func (h Header) IsTyped() bool {
+    return (h.Flags & FlagTyped) != 0
 }
\ No newline at end of file
@@ -0,0 +1,96 @@
+package steg
+
+import (
+    "context"
+    "crypto/hmac"
+    "crypto/sha256"
+    "errors"
+    "fmt"
+    "image"
+)
+
+// Authenticated payloads are stored in the clear followed by an HMAC-SHA256 tag
+// over the header and the payload. They suit data that is not secret but must be
+// tamper-evident, such as provenance watermarks.
+//
+// Layout: [header(8) | payload | HMAC-SHA256(header | payload)(32)]
+
+const (
+    // HMACTagSize is the size of the integrity tag appended to authenticated payloads
+    HMACTagSize = sha256.Size
+
+    hmacKeyLabel = "mosquito hmac"
+)
+
+// EncodeMessageAuthenticated embeds an unencrypted message together with an
+// HMAC-SHA256 tag keyed by a shared secret
+func EncodeMessageAuthenticated(img image.Image, msg []byte, secret string, mode StegMode, isImage bool) (image.Image, error) {
+    if secret == "" {
+        return nil, ErrInvalidKey
+    }
+    return NewEncoder(img, WithMode(mode), WithHMACKey(secret), WithFlags(imageFlag(isImage))).EncodeBytes(msg)
+}
+
+// DecodeAuthenticatedMessage extracts an HMAC-protected payload and checks its tag.
+// The message is returned even when it cannot be verified, so it can still be shown;
+// verified is only true when a secret was given and the tag matched.
+func DecodeAuthenticatedMessage(img image.Image, secret string) (msg []byte, verified bool, err error) {
+    dec := NewDecoder(img, WithHMACKey(secret))
+    header, err := dec.Header()
+    if err != nil {
+        return nil, false, err
+    }
+    if !header.IsAuthenticated() {
+        return nil, false, ErrNotAuthenticated
+    }
+
+    p, err := dec.Decode()
+    if err != nil && !errors.Is(err, ErrAuthenticationFailed) {
+        return nil, false, err
+    }
+    return p.Data, p.Verified, nil
+}
+
+// encodeAuthenticated stores the payload in the clear followed by its HMAC tag
+func (e *Encoder) encodeAuthenticated(ctx context.Context, msg []byte) (image.Image, error) {
+    // Check if the image has enough capacity for the payload and tag
+    if err := checkCapacity(e.cover, len(msg)+HMACTagSize, headerSize, e.opts.mode); err != nil {
+        return nil, err
+    }
+
+    header := e.header(len(msg) + HMACTagSize)
+    header.Flags |= FlagAuthenticated
+
+    headerData := MarshalHeader(header)
+    data := append(headerData, msg...)
+    data = append(data, computeTag(e.opts.hmacKey, headerData, msg)...)
+
+    return e.embed(ctx, data)
+}
+
+// openAuthenticated splits an authenticated payload and verifies its tag
+func openAuthenticated(header Header, data []byte, secret string) ([]byte, bool, error) {
+    if len(data) < HMACTagSize {
+        return nil, false, fmt.Errorf("%w: payload is %d bytes, shorter than its %d-byte tag", ErrMessageCorrupted, len(data), HMACTagSize)
+    }
+
+    msg, tag := data[:len(data)-HMACTagSize], data[len(data)-HMACTagSize:]
+    if secret == "" {
+        return msg, false, nil
+    }
+
+    expected := computeTag(secret, MarshalHeader(header), msg)
+    return msg, hmac.Equal(tag, expected), nil
+}
+
+// computeTag returns the HMAC-SHA256 tag over the header and payload. The key
+// is not stretched, see deriveKey.
+func computeTag(secret string, headerData, msg []byte) []byte {
+    key := deriveKey(secret, hmacKeyLabel)
+    defer key.Release()
+
+    mac := hmac.New(sha256.New, key.Bytes())
+    mac.Write(headerData)
+    mac.Write(msg)
+    return mac.Sum(nil)
+}
@@ -0,0 +1,71 @@
+package steg
+
+import (
+    "bytes"
+    "errors"
+    "testing"
+)
+
+const hmacTestKey = "4f1c0d9e8b7a6c5d4e3f2a1b0c9d8e7f"
+
+func TestHMACVerifies(t *testing.T) {
+    msg := []byte("Photo by Alice, 2025")
+    img, err := EncodeMessageAuthenticated(kdfTestCover(), msg, hmacTestKey, LSB1, false)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    got, verified, err := DecodeAuthenticatedMessage(img, hmacTestKey)
+    if err != nil || !verified || !bytes.Equal(got, msg) {
+        t.Errorf("got %q, verified %v, %v", got, verified, err)
+    }
+
+    // Without a key the payload can still be read, but is not verified
+    got, verified, err = DecodeAuthenticatedMessage(img, "")
+    if err != nil || verified || !bytes.Equal(got, msg) {
+        t.Errorf("no key: got %q, verified %v, %v", got, verified, err)
+    }
+}
+
+func TestHMACRejectsTampering(t *testing.T) {
+    msg := []byte("Photo by Alice, 2025")
+    img, err := EncodeMessageAuthenticated(kdfTestCover(), msg, hmacTestKey, LSB1, false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stored, err := extractData(img, LSB1, headerSize+len(msg)+HMACTagSize, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    for name, at := range map[string]int{"header": 3, "payload": headerSize, "tag": len(stored) - 1} {
+        tampered := bytes.Clone(stored)
+        tampered[at] ^= 1
+        _, err := NewDecoder(embedRaw(t, img, tampered), WithHMACKey(hmacTestKey)).Decode()
+        if !errors.Is(err, ErrAuthenticationFailed) {
+            t.Errorf("%s bit flipped: got %v, want %v", name, err, ErrAuthenticationFailed)
+        }
+    }
+}
+
+func TestHMACWrongKey(t *testing.T) {
+    img, err := EncodeMessageAuthenticated(kdfTestCover(), []byte("x"), hmacTestKey, LSB1, false)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := NewDecoder(img, WithHMACKey("another key")).Decode(); !errors.Is(err, ErrAuthenticationFailed) {
+        t.Errorf("got %v, want %v", err, ErrAuthenticationFailed)
+    }
+    if _, verified, err := DecodeAuthenticatedMessage(img, "another key"); err != nil || verified {
+        t.Errorf("DecodeAuthenticatedMessage: verified %v, %v", verified, err)
+    }
+
+    // A payload without a tag cannot pass for one
+    plain, err := EncodeMessage(kdfTestCover(), []byte("x"), LSB1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, _, err := DecodeAuthenticatedMessage(plain, hmacTestKey); !errors.Is(err, ErrNotAuthenticated) {
+        t.Errorf("untagged: got %v, want %v", err, ErrNotAuthenticated)
+    }
+}
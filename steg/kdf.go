@@ -0,0 +1,126 @@
+package steg
+
+import (
+    "crypto/hmac"
+    "crypto/rand"
+    "crypto/sha256"
+    "fmt"
+
+    "golang.org/x/crypto/scrypt"
+)
+
+// Passwords are stretched with scrypt before any key is derived from them, so
+// every guess costs an attacker the same memory and time it costs us. The cost
+// is the base-2 logarithm of scrypt's N, with r = 8 and p = 1: cost 15 takes
+// 32 MiB and about a tenth of a second. Each image gets a random salt, stored
+// with the cost in front of the encrypted payload:
+//
+//   [cost(1) | salt(16)]
+//
+// Stealth images store the cost XORed with the first salt byte instead, so the
+// block is as random as the rest of the stealth data.
+
+const (
+    // DefaultKDFCost is the scrypt cost used unless WithKDFCost says otherwise
+    DefaultKDFCost = 15
+    // MinKDFCost and MaxKDFCost bound the cost of encoding and of decoding, so
+    // an image cannot ask the decoder for gigabytes of memory
+    MinKDFCost = 10
+    MaxKDFCost = 18
+
+    kdfSaltSize = 16
+    // kdfParamsSize is the size of the cost and salt block in bytes
+    kdfParamsSize = 1 + kdfSaltSize
+
+    // scrypt parameters other than the cost
+    scryptR = 8
+    scryptP = 1
+)
+
+// kdfParams are the cost and salt a password was stretched with
+type kdfParams struct {
+    cost int
+    salt []byte
+}
+
+// newKDFParams returns params with the given cost and a fresh random salt
+func newKDFParams(cost int) (kdfParams, error) {
+    if err := checkKDFCost(cost); err != nil {
+        return kdfParams{}, err
+    }
+    salt := make([]byte, kdfSaltSize)
+    if _, err := rand.Read(salt); err != nil {
+        return kdfParams{}, err
+    }
+    return kdfParams{cost: cost, salt: salt}, nil
+}
+
+// checkKDFCost returns ErrInvalidKDFCost for a cost outside MinKDFCost..MaxKDFCost
+func checkKDFCost(cost int) error {
+    if cost < MinKDFCost || cost > MaxKDFCost {
+        return fmt.Errorf("%w: %d (valid: %d to %d)", ErrInvalidKDFCost, cost, MinKDFCost, MaxKDFCost)
+    }
+    return nil
+}
+
+// marshal returns the block stored in front of an encrypted payload. masked
+// hides the cost behind the salt, for stealth images.
+func (p kdfParams) marshal(masked bool) []byte {
+    cost := byte(p.cost)
+    if masked {
+        cost ^= p.salt[0]
+    }
+    return append([]byte{cost}, p.salt...)
+}
+
+// parseKDFParams reads the block written by marshal. A cost above maxCost is
+// refused before any work is done, so an image cannot make the decoder spend
+// more memory and time than it was allowed.
+func parseKDFParams(data []byte, masked bool, maxCost int) (kdfParams, error) {
+    if len(data) < kdfParamsSize {
+        return kdfParams{}, fmt.Errorf("%w: key derivation parameters truncated", ErrMessageCorrupted)
+    }
+    salt := append([]byte{}, data[1:kdfParamsSize]...)
+    cost := data[0]
+    if masked {
+        cost ^= salt[0]
+    }
+    // No encoder writes such a cost, so the image is damaged
+    if err := checkKDFCost(int(cost)); err != nil {
+        return kdfParams{}, fmt.Errorf("%w: %w", ErrMessageCorrupted, err)
+    }
+    if int(cost) > maxCost {
+        return kdfParams{}, fmt.Errorf("%w: the image was encrypted with cost %d, above the %d allowed", ErrInvalidKDFCost, cost, maxCost)
+    }
+    return kdfParams{cost: int(cost), salt: salt}, nil
+}
+
+// stretchPassword runs scrypt over the password. Keys are derived from the
+// result with labelKey. The caller must release it once done with it.
+func stretchPassword(password string, p kdfParams) (*SecretBuffer, error) {
+    pw := SecretFromString(password)
+    defer pw.Release()
+    key, err := scrypt.Key(pw.Bytes(), p.salt, 1<<p.cost, scryptR, scryptP, 32)
+    if err != nil {
+        return nil, err
+    }
+    return SecretFromBytes(key), nil
+}
+
+// labelKey derives an independent 256-bit key for the given purpose from a
+// stretched password. The caller must release the returned key once done with it.
+func labelKey(base *SecretBuffer, label string) *SecretBuffer {
+    mac := hmac.New(sha256.New, base.Bytes())
+    mac.Write([]byte(label))
+    return SecretFromBytes(mac.Sum(nil))
+}
+
+// deriveStretchedKey stretches the password and derives the key for label
+func deriveStretchedKey(password, label string, p kdfParams) (*SecretBuffer, error) {
+    base, err := stretchPassword(password, p)
+    if err != nil {
+        return nil, err
+    }
+    defer base.Release()
+    return labelKey(base, label), nil
+}
@@ -0,0 +1,171 @@
+package steg
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "image"
+    "testing"
+)
+
+const kdfTestPassword = "correct horse battery staple"
+
+func kdfTestCover() image.Image {
+    return noisyNRGBA(64, 64)
+}
+
+// embedRaw embeds data as it is, for images in formats the encoder no longer writes
+func embedRaw(t *testing.T, cover image.Image, data []byte) image.Image {
+    t.Helper()
+    img, err := copyToNRGBA(context.Background(), cover)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := embedData(context.Background(), img, data, LSB1, nil); err != nil {
+        t.Fatal(err)
+    }
+    return img
+}
+
+func TestKDFRoundTrip(t *testing.T) {
+    msg := []byte("meet me at the park at 5pm")
+    for _, stealth := range []bool{false, true} {
+        opts := []Option{WithPassword(kdfTestPassword), WithKDFCost(MinKDFCost)}
+        if stealth {
+            opts = append(opts, WithStealth())
+        }
+        img, err := NewEncoder(kdfTestCover(), opts...).EncodeBytes(msg)
+        if err != nil {
+            t.Fatalf("stealth %v: encoding: %v", stealth, err)
+        }
+
+        p, err := NewDecoder(img, WithPassword(kdfTestPassword)).Decode()
+        if err != nil {
+            t.Fatalf("stealth %v: decoding: %v", stealth, err)
+        }
+        if !bytes.Equal(p.Data, msg) || p.Header.Version != Version || p.Header.IsStealth() != stealth {
+            t.Errorf("stealth %v: got %q in version %d, stealth %v", stealth, p.Data, p.Header.Version, p.Header.IsStealth())
+        }
+        if _, err := NewDecoder(img, WithPassword("wrong")).Decode(); err == nil {
+            t.Errorf("stealth %v: decoded with the wrong password", stealth)
+        }
+    }
+}
+
+func TestKDFSaltsEveryImage(t *testing.T) {
+    read := func() []byte {
+        img, err := NewEncoder(kdfTestCover(), WithPassword(kdfTestPassword), WithKDFCost(MinKDFCost)).EncodeBytes([]byte("same"))
+        if err != nil {
+            t.Fatal(err)
+        }
+        data, err := extractData(img, LSB1, kdfParamsSize, headerSize)
+        if err != nil {
+            t.Fatal(err)
+        }
+        return data
+    }
+    a, b := read(), read()
+    if a[0] != MinKDFCost || b[0] != MinKDFCost {
+        t.Errorf("stored costs %d and %d, want %d", a[0], b[0], MinKDFCost)
+    }
+    if bytes.Equal(a[1:], b[1:]) {
+        t.Error("two images share a salt")
+    }
+}
+
+func TestKDFCostLimits(t *testing.T) {
+    for _, cost := range []int{MinKDFCost - 1, MaxKDFCost + 1} {
+        _, err := NewEncoder(kdfTestCover(), WithPassword(kdfTestPassword), WithKDFCost(cost)).EncodeBytes([]byte("x"))
+        if !errors.Is(err, ErrInvalidKDFCost) {
+            t.Errorf("cost %d: got %v, want %v", cost, err, ErrInvalidKDFCost)
+        }
+    }
+
+    // An image asking for more than MaxKDFCost is refused before any work is done
+    img, err := NewEncoder(kdfTestCover(), WithPassword(kdfTestPassword), WithKDFCost(MinKDFCost)).EncodeBytes([]byte("x"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    data, err := extractData(img, LSB1, headerSize+kdfParamsSize, 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    data[headerSize] = MaxKDFCost + 1
+    _, err = NewDecoder(embedRaw(t, img, data), WithPassword(kdfTestPassword)).Decode()
+    if !errors.Is(err, ErrInvalidKDFCost) || !errors.Is(err, ErrMessageCorrupted) {
+        t.Errorf("got %v, want %v and %v", err, ErrInvalidKDFCost, ErrMessageCorrupted)
+    }
+}
+
+// A decoder refuses images stretched above its own cost, before running scrypt
+func TestKDFCostCeiling(t *testing.T) {
+    for _, stealth := range []bool{false, true} {
+        opts := []Option{WithPassword(kdfTestPassword), WithKDFCost(MinKDFCost + 1)}
+        if stealth {
+            opts = append(opts, WithStealth())
+        }
+        img, err := NewEncoder(kdfTestCover(), opts...).EncodeBytes([]byte("x"))
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        want := ErrInvalidKDFCost
+        if stealth {
+            // Without a plaintext header the probe just finds nothing it may open
+            want = ErrNoStealthPayload
+        }
+        _, err = NewDecoder(img, WithPassword(kdfTestPassword), WithKDFCost(MinKDFCost)).Decode()
+        if !errors.Is(err, want) {
+            t.Errorf("stealth %v: got %v, want %v", stealth, err, want)
+        }
+        if _, err := NewDecoder(img, WithPassword(kdfTestPassword), WithKDFCost(MinKDFCost+1)).Decode(); err != nil {
+            t.Errorf("stealth %v: at the image's cost: %v", stealth, err)
+        }
+    }
+}
+
+// Images written before the key derivation was salted must still open
+func TestKDFLegacyImages(t *testing.T) {
+    msg := []byte("written by an older version")
+
+    header := Header{Magic: MagicByte, Version: legacyKDFVersion, Mode: LSB1, Flags: FlagEncrypted}
+    overhead, _ := DefaultCipherSuite.overhead()
+    header.PayloadLen = uint32(len(msg) + overhead)
+    key := deriveKey(kdfTestPassword, payloadKeyLabel)
+    sealed, err := sealPayload(DefaultCipherSuite, key.Bytes(), msg, MarshalHeader(header))
+    key.Release()
+    if err != nil {
+        t.Fatal(err)
+    }
+    plain := embedRaw(t, kdfTestCover(), append(MarshalHeader(header), sealed...))
+
+    header.Flags |= FlagStealth
+    header.PayloadLen = uint32(len(msg) + overhead - 1)
+    headerData := append(MarshalHeader(header), byte(DefaultCipherSuite))
+    payloadKey, headerKey := deriveKey(kdfTestPassword, stealthPayloadLabel), deriveKey(kdfTestPassword, stealthHeaderLabel)
+    payload, err := DefaultCipherSuite.seal(payloadKey.Bytes(), msg, headerData)
+    if err != nil {
+        t.Fatal(err)
+    }
+    sealedHeader, err := SuiteAES256GCM.seal(headerKey.Bytes(), headerData, nil)
+    if err != nil {
+        t.Fatal(err)
+    }
+    payloadKey.Release()
+    headerKey.Release()
+    stealth := embedRaw(t, kdfTestCover(), append(sealedHeader, payload...))
+
+    for name, img := range map[string]image.Image{"encrypted": plain, "stealth": stealth} {
+        p, err := NewDecoder(img, WithPassword(kdfTestPassword)).Decode()
+        if err != nil {
+            t.Errorf("%s: %v", name, err)
+            continue
+        }
+        if !bytes.Equal(p.Data, msg) {
+            t.Errorf("%s: got %q", name, p.Data)
+        }
+    }
+    if suite, err := GetCipherSuite(plain, Header{Version: legacyKDFVersion, Mode: LSB1, Flags: FlagEncrypted}); err != nil || suite != DefaultCipherSuite {
+        t.Errorf("GetCipherSuite: %v, %v", suite, err)
+    }
+}
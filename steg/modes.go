@@ -1,5 +1,10 @@
 package steg
 
+import (
+    "context"
+    "image"
+)
+
 // StegMode represents different steganography algorithms
 type StegMode int
 
@@ -14,31 +19,91 @@ const (
     LSB8
 )
 
-// ModeNames provides human-readable names for steganography modes
-var ModeNames = map[StegMode]string{
-    LSB1: "LSB-1 (R channel only)",
-    LSB3: "LSB-3 (RGB channels)",
-    LSB4: "LSB-4 (2-bits in R & G)",
-    LSB8: "LSB-8 (all channels, 2-bits each)",
+// ModeNames provides human-readable names for steganography modes. It is filled
+// in by Register.
+var ModeNames = map[StegMode]string{}
+
+func init() {
+    Register(lsbEmbedder{LSB1, "LSB1", "LSB-1 (R channel only)", lsb1Slots})
+    Register(lsbEmbedder{LSB3, "LSB3", "LSB-3 (RGB channels)", lsb3Slots})
+    Register(lsbEmbedder{LSB4, "LSB4", "LSB-4 (2-bits in R & G)", lsb4Slots})
+    Register(lsbEmbedder{LSB8, "LSB8", "LSB-8 (all channels, 2-bits each)", lsb8Slots})
+}
+
+// lsbEmbedder is the Embedder for the built-in least-significant-bit modes
+type lsbEmbedder struct {
+    id          StegMode
+    name        string
+    description string
+    slots       []lsbSlot
+}
+
+func (e lsbEmbedder) ID() StegMode { return e.id }
+
+func (e lsbEmbedder) Name() string { return e.name }
+
+func (e lsbEmbedder) Description() string { return e.description }
+
+func (e lsbEmbedder) Capacity(img image.Image) int {
+    bounds := img.Bounds()
+    return bounds.Dx() * bounds.Dy() * len(e.slots) / 8
+}
+
+func (e lsbEmbedder) Embed(img *image.NRGBA, data []byte) error {
+    return e.EmbedContext(context.Background(), img, data, nil)
+}
+
+func (e lsbEmbedder) Extract(img image.Image, dataSize int, offset int) ([]byte, error) {
+    return e.ExtractContext(context.Background(), img, dataSize, offset, nil)
 }
 
-// CapacityFactor returns the number of bits per pixel for each mode
+func (e lsbEmbedder) EmbedContext(ctx context.Context, img *image.NRGBA, data []byte, progress ProgressFunc) error {
+    if len(data) > e.Capacity(img) {
+        return &CapacityError{Required: len(data), Available: e.Capacity(img), Mode: e.id}
+    }
+    bounds := img.Bounds()
+    t := newTracker(ctx, progress, len(data)*8)
+    return lsbEmbed(t, img.Pix, img.Stride, bounds.Dx(), bounds.Dy(), e.slots, data)
+}
+
+func (e lsbEmbedder) ExtractContext(ctx context.Context, img image.Image, dataSize int, offset int, progress ProgressFunc) ([]byte, error) {
+    if dataSize < 0 || offset < 0 || dataSize+offset > e.Capacity(img) {
+        return nil, ErrMessageCorrupted
+    }
+    t := newTracker(ctx, progress, dataSize*8)
+    return lsbExtractImage(t, img, e.slots, dataSize, offset)
+}
+
+// CapacityFactor returns the number of bits per pixel for the built-in LSB modes.
+//
+// Deprecated: use Lookup(mode) and the embedder's Capacity, which also covers
+// registered modes that do not have a fixed number of bits per pixel.
 func (m StegMode) CapacityFactor() int {
-    switch m {
-    case LSB1:
-        return 1
-    case LSB3:
-        return 3
-    case LSB4:
-        return 4
-    case LSB8:
-        return 8
-    default:
+    e, err := Lookup(m)
+    if err != nil {
         return 1
     }
+    if lsb, ok := e.(lsbEmbedder); ok {
+        return len(lsb.slots)
+    }
+    return 1
+}
+
+// String returns the short name of the mode
+func (m StegMode) String() string {
+    e, err := Lookup(m)
+    if err != nil {
+        return "unknown"
+    }
+    return e.Name()
 }
 
 // GetAvailableModes returns a slice of all available steganography modes
 func GetAvailableModes() []StegMode {
-    return []StegMode{LSB1, LSB3, LSB4, LSB8}
-}
\ No newline at end of file
+    embedders := Embedders()
+    modes := make([]StegMode, len(embedders))
+    for i, e := range embedders {
+        modes[i] = e.ID()
+    }
+    return modes
+}
@@ -0,0 +1,184 @@
+package steg
+
+import (
+    "context"
+    "image"
+    "image/color"
+    "runtime"
+    "sync"
+)
+
+// Embedding works on 8-bit RGBA-layout pixel buffers. *image.RGBA and *image.NRGBA
+// are read in place; every other image type is converted row by row into an
+// *image.NRGBA, with fast paths for the types the standard decoders return.
+
+// parallelThreshold is the number of pixels below which work stays on one goroutine
+const parallelThreshold = 1 << 16
+
+// parallelize splits [0, n) into one contiguous chunk per CPU and runs fn on each
+// chunk concurrently, returning the first error. Chunk boundaries are multiples of
+// align, and pixelsPerItem is used to decide whether the work is large enough to
+// be worth splitting.
+func parallelize(n, align, pixelsPerItem int, fn func(lo, hi int) error) error {
+    workers := runtime.GOMAXPROCS(0)
+    if n*pixelsPerItem < parallelThreshold || workers < 2 {
+        return fn(0, n)
+    }
+
+    chunk := (n + workers - 1) / workers
+    chunk = (chunk + align - 1) / align * align
+
+    var (
+        wg       sync.WaitGroup
+        errOnce  sync.Once
+        firstErr error
+    )
+    for lo := 0; lo < n; lo += chunk {
+        hi := min(lo+chunk, n)
+        wg.Add(1)
+        go func(lo, hi int) {
+            defer wg.Done()
+            if err := fn(lo, hi); err != nil {
+                errOnce.Do(func() { firstErr = err })
+            }
+        }(lo, hi)
+    }
+    wg.Wait()
+    return firstErr
+}
+
+// copyToNRGBA returns an NRGBA copy of img that can be modified without touching
+// the original. NRGBA keeps every channel independent, so bits written into the
+// alpha channel survive being saved as PNG.
+func copyToNRGBA(ctx context.Context, img image.Image) (*image.NRGBA, error) {
+    bounds := img.Bounds()
+    out := image.NewNRGBA(bounds)
+    rowsPerStep := max(progressStep/max(bounds.Dx(), 1), 1)
+
+    err := parallelize(bounds.Dy(), 1, bounds.Dx(), func(lo, hi int) error {
+        for y := lo; y < hi; y += rowsPerStep {
+            if err := ctx.Err(); err != nil {
+                return err
+            }
+            convertRows(img, out, y, min(y+rowsPerStep, hi))
+        }
+        return nil
+    })
+    if err != nil {
+        return nil, err
+    }
+    return out, nil
+}
+
+// rawPixels returns the RGBA-layout bytes of img covering at least its first n
+// pixels, along with the row stride and the number of rows covered. Images that
+// are already RGBA or NRGBA are returned as they are; anything else has just the
+// needed rows converted.
+func rawPixels(img image.Image, n int) ([]byte, int, int) {
+    switch src := img.(type) {
+    case *image.RGBA:
+        return src.Pix, src.Stride, src.Rect.Dy()
+    case *image.NRGBA:
+        return src.Pix, src.Stride, src.Rect.Dy()
+    }
+
+    out := convertPrefix(img, n)
+    return out.Pix, out.Stride, out.Rect.Dy()
+}
+
+// probeImage returns the start of img that holds the first nbytes in every
+// built-in mode. Other image types are converted once here, so header detection
+// can try every mode without converting the same pixels again for each one.
+func probeImage(img image.Image, nbytes int) image.Image {
+    switch img.(type) {
+    case *image.RGBA, *image.NRGBA:
+        return img
+    }
+
+    // One bit per pixel is the lowest density of the built-in modes
+    return convertPrefix(img, nbytes*8)
+}
+
+// convertPrefix converts the rows of img holding its first n pixels to NRGBA
+func convertPrefix(img image.Image, n int) *image.NRGBA {
+    bounds := img.Bounds()
+    if bounds.Empty() {
+        return image.NewNRGBA(bounds)
+    }
+
+    rows := min((n+bounds.Dx()-1)/bounds.Dx(), bounds.Dy())
+    out := image.NewNRGBA(image.Rect(bounds.Min.X, bounds.Min.Y, bounds.Max.X, bounds.Min.Y+rows))
+    parallelize(rows, 1, bounds.Dx(), func(lo, hi int) error {
+        convertRows(img, out, lo, hi)
+        return nil
+    })
+    return out
+}
+
+// convertRows writes rows [y0, y1) of src, counted from the top of its bounds,
+// into dst as non-premultiplied 8-bit RGBA
+func convertRows(src image.Image, dst *image.NRGBA, y0, y1 int) {
+    bounds := src.Bounds()
+    width := bounds.Dx()
+
+    // Resolve the palette once rather than converting a color per pixel
+    var palette [][4]byte
+    if p, ok := src.(*image.Paletted); ok {
+        palette = make([][4]byte, len(p.Palette))
+        for i, c := range p.Palette {
+            n := color.NRGBAModel.Convert(c).(color.NRGBA)
+            palette[i] = [4]byte{n.R, n.G, n.B, n.A}
+        }
+    }
+
+    for y := y0; y < y1; y++ {
+        row := dst.Pix[y*dst.Stride : y*dst.Stride+width*4]
+        sy := bounds.Min.Y + y
+
+        switch s := src.(type) {
+        case *image.NRGBA:
+            copy(row, s.Pix[s.PixOffset(bounds.Min.X, sy):])
+
+        case *image.RGBA:
+            copy(row, s.Pix[s.PixOffset(bounds.Min.X, sy):])
+            // Only translucent pixels differ between premultiplied and straight alpha
+            for i := 0; i < len(row); i += 4 {
+                if a := row[i+3]; a != 0xFF {
+                    c := color.NRGBAModel.Convert(color.RGBA{row[i], row[i+1], row[i+2], a}).(color.NRGBA)
+                    row[i], row[i+1], row[i+2] = c.R, c.G, c.B
+                }
+            }
+
+        case *image.YCbCr:
+            for x := 0; x < width; x++ {
+                yi := s.YOffset(bounds.Min.X+x, sy)
+                ci := s.COffset(bounds.Min.X+x, sy)
+                r, g, b := color.YCbCrToRGB(s.Y[yi], s.Cb[ci], s.Cr[ci])
+                row[x*4], row[x*4+1], row[x*4+2], row[x*4+3] = r, g, b, 0xFF
+            }
+
+        case *image.Gray:
+            off := s.PixOffset(bounds.Min.X, sy)
+            for x := 0; x < width; x++ {
+                v := s.Pix[off+x]
+                row[x*4], row[x*4+1], row[x*4+2], row[x*4+3] = v, v, v, 0xFF
+            }
+
+        case *image.Paletted:
+            off := s.PixOffset(bounds.Min.X, sy)
+            for x := 0; x < width; x++ {
+                var c [4]byte
+                if i := int(s.Pix[off+x]); i < len(palette) {
+                    c = palette[i]
+                }
+                copy(row[x*4:x*4+4], c[:])
+            }
+
+        default:
+            for x := 0; x < width; x++ {
+                c := color.NRGBAModel.Convert(src.At(bounds.Min.X+x, sy)).(color.NRGBA)
+                row[x*4], row[x*4+1], row[x*4+2], row[x*4+3] = c.R, c.G, c.B, c.A
+            }
+        }
+    }
+}
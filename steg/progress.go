@@ -0,0 +1,40 @@
+package steg
+
+import (
+    "context"
+    "sync"
+)
+
+// ProgressFunc is called while a payload is embedded or extracted with the number
+// of payload bits processed so far and the total. Calls are serialized, but may
+// come from different goroutines.
+type ProgressFunc func(done, total int64)
+
+// progressStep is the number of pixels processed between cancellation checks and
+// progress reports
+const progressStep = 1 << 15
+
+// tracker checks for cancellation and reports progress from the embedding loops
+type tracker struct {
+    ctx      context.Context
+    progress ProgressFunc
+    total    int64
+
+    mu   sync.Mutex
+    done int64
+}
+
+func newTracker(ctx context.Context, progress ProgressFunc, totalBits int) *tracker {
+    return &tracker{ctx: ctx, progress: progress, total: int64(totalBits)}
+}
+
+// add records bits as processed, and returns an error once the context is done
+func (t *tracker) add(bits int) error {
+    if t.progress != nil && bits > 0 {
+        t.mu.Lock()
+        t.done += int64(bits)
+        t.progress(min(t.done, t.total), t.total)
+        t.mu.Unlock()
+    }
+    return t.ctx.Err()
+}
@@ -0,0 +1,67 @@
+package steg
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "testing"
+)
+
+// Progress must only move forward and end on the total, both ways
+func TestProgressReachesTotal(t *testing.T) {
+    type report struct{ done, total int64 }
+    var reports []report
+    record := WithProgress(func(done, total int64) {
+        reports = append(reports, report{done, total})
+    })
+    check := func(what string) {
+        t.Helper()
+        if len(reports) == 0 {
+            t.Fatalf("%s: progress was never reported", what)
+        }
+        last := reports[len(reports)-1]
+        if last.done != last.total || last.total == 0 {
+            t.Errorf("%s: last report %d of %d", what, last.done, last.total)
+        }
+        for i := 1; i < len(reports); i++ {
+            if reports[i].done < reports[i-1].done {
+                t.Errorf("%s: progress went back from %d to %d", what, reports[i-1].done, reports[i].done)
+            }
+        }
+        reports = nil
+    }
+
+    msg := bytes.Repeat([]byte("progress"), 2048)
+    out, err := NewEncoder(noisyNRGBA(512, 512), record).EncodeBytes(msg)
+    if err != nil {
+        t.Fatal(err)
+    }
+    check("encode")
+
+    p, err := NewDecoder(out, record).Decode()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(p.Data, msg) {
+        t.Fatal("decoded message differs")
+    }
+    check("decode")
+}
+
+// A cancelled context must stop both directions with context.Canceled
+func TestCancelledContext(t *testing.T) {
+    msg := bytes.Repeat([]byte("cancel"), 4096)
+    out, err := NewEncoder(noisyNRGBA(512, 512)).EncodeBytes(msg)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    ctx, cancel := context.WithCancel(context.Background())
+    cancel()
+    if _, err := NewEncoder(noisyNRGBA(512, 512)).EncodeBytesContext(ctx, msg); !errors.Is(err, context.Canceled) {
+        t.Errorf("encode: got %v, want context.Canceled", err)
+    }
+    if _, err := NewDecoder(out).DecodeContext(ctx); !errors.Is(err, context.Canceled) {
+        t.Errorf("decode: got %v, want context.Canceled", err)
+    }
+}
@@ -0,0 +1,91 @@
+package steg
+
+import (
+    "crypto/rand"
+    "io"
+    "os"
+)
+
+// SecretBuffer holds sensitive bytes such as keys or plaintext and zeroes them
+// when released. Go may still have copied the data elsewhere (strings, GC moves),
+// so this limits how long secrets linger rather than guaranteeing they are gone.
+type SecretBuffer struct {
+    b []byte
+}
+
+// NewSecretBuffer allocates a zeroed secret buffer of n bytes
+func NewSecretBuffer(n int) *SecretBuffer {
+    return &SecretBuffer{b: make([]byte, n)}
+}
+
+// SecretFromBytes wraps b without copying; the buffer takes ownership of b
+func SecretFromBytes(b []byte) *SecretBuffer {
+    return &SecretBuffer{b: b}
+}
+
+// SecretFromString copies s into a new secret buffer
+func SecretFromString(s string) *SecretBuffer {
+    return &SecretBuffer{b: []byte(s)}
+}
+
+// Bytes returns the secret. The slice is only valid until Release is called.
+func (s *SecretBuffer) Bytes() []byte {
+    if s == nil {
+        return nil
+    }
+    return s.b
+}
+
+// Len returns the size of the secret in bytes
+func (s *SecretBuffer) Len() int {
+    if s == nil {
+        return 0
+    }
+    return len(s.b)
+}
+
+// Release zeroes the secret and drops the reference to it. It is safe to call
+// more than once.
+func (s *SecretBuffer) Release() {
+    if s == nil {
+        return
+    }
+    Wipe(s.b)
+    s.b = nil
+}
+
+// Wipe overwrites b with zeros
+func Wipe(b []byte) {
+    for i := range b {
+        b[i] = 0
+    }
+}
+
+// ShredFile overwrites a file with random data, flushes it to disk and deletes it.
+// On journaling or copy-on-write filesystems and SSDs the old blocks may survive,
+// so this is a best effort.
+func ShredFile(path string) error {
+    info, err := os.Stat(path)
+    if err != nil {
+        return err
+    }
+
+    f, err := os.OpenFile(path, os.O_WRONLY, 0)
+    if err != nil {
+        return err
+    }
+
+    if _, err := io.CopyN(f, rand.Reader, info.Size()); err != nil {
+        f.Close()
+        return err
+    }
+    if err := f.Sync(); err != nil {
+        f.Close()
+        return err
+    }
+    if err := f.Close(); err != nil {
+        return err
+    }
+
+    return os.Remove(path)
+}
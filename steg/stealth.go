@@ -0,0 +1,235 @@
+package steg
+
+import (
+    "context"
+    "image"
+)
+
+// Stealth mode hides the header as well as the payload. Nothing is written in the
+// clear: the embedded data starts with an encrypted header block, followed by the
+// encrypted payload. Without the password the pixels carry nothing but noise, so
+// the image cannot be told apart from a clean one by looking for the magic byte.
+//
+// Layout:
+//   [cost ^ salt[0](1) | salt(16)]                         - kdfParamsSize bytes
+//   [nonce(12) | AES-GCM(header(8) | suite(1)) | tag(16)]  - StealthHeaderSize bytes
+//   [nonce     | AEAD(payload)                 | tag    ]  - header.PayloadLen bytes
+//
+// The payload cipher suite is kept inside the sealed header rather than in front of
+// the payload, since a plaintext suite byte would itself be a marker. Images from
+// header versions up to legacyKDFVersion have no key derivation block, and derive
+// both keys from an unsalted hash of the password.
+
+const (
+    // StealthHeaderSize is the size of the encrypted header block in bytes
+    StealthHeaderSize = 12 + 9 + 16
+
+    // stealthProbeSize is how much data finding a stealth header reads
+    stealthProbeSize = kdfParamsSize + StealthHeaderSize
+
+    stealthHeaderLabel  = "mosquito stealth header"
+    stealthPayloadLabel = "mosquito stealth payload"
+)
+
+// EncodeMessageStealth embeds an encrypted message without any plaintext header
+func EncodeMessageStealth(img image.Image, msg []byte, password string, mode StegMode, isImage bool) (image.Image, error) {
+    return EncodeMessageStealthWithCipher(img, msg, password, mode, isImage, DefaultCipherSuite)
+}
+
+// EncodeMessageStealthWithCipher embeds a message in stealth mode using the given
+// cipher suite for the payload
+func EncodeMessageStealthWithCipher(img image.Image, msg []byte, password string, mode StegMode, isImage bool, suite CipherSuite) (image.Image, error) {
+    return NewEncoder(img,
+        WithMode(mode),
+        WithPassword(password),
+        WithCipher(suite),
+        WithStealth(),
+        WithFlags(imageFlag(isImage)),
+    ).EncodeBytes(msg)
+}
+
+// DecodeMessageStealth extracts and decrypts a stealth payload, returning the
+// decrypted header along with the message
+func DecodeMessageStealth(img image.Image, password string) ([]byte, Header, error) {
+    p, err := NewDecoder(img, WithPassword(password), WithStealth()).Decode()
+    if err != nil {
+        return nil, Header{}, err
+    }
+    return p.Data, p.Header, nil
+}
+
+// encodeStealth seals the header and payload so nothing is left in the clear
+func (e *Encoder) encodeStealth(ctx context.Context, msg []byte) (image.Image, error) {
+    // Check the capacity including the key derivation block and encrypted header
+    suite := e.opts.suite
+    payloadLen, headerLen, err := e.layout(len(msg))
+    if err != nil {
+        return nil, err
+    }
+    if err := checkCapacity(e.cover, payloadLen, headerLen, e.opts.mode); err != nil {
+        return nil, err
+    }
+
+    header := e.header(payloadLen)
+    header.Flags |= FlagEncrypted | FlagStealth
+    headerData := append(MarshalHeader(header), byte(suite))
+
+    // Both keys come from one run of the key derivation
+    params, err := newKDFParams(e.opts.kdfCost)
+    if err != nil {
+        return nil, err
+    }
+    base, err := stretchPassword(e.opts.password, params)
+    if err != nil {
+        return nil, ErrEncryptionFailed
+    }
+    defer base.Release()
+    payloadKey := labelKey(base, stealthPayloadLabel)
+    defer payloadKey.Release()
+    headerKey := labelKey(base, stealthHeaderLabel)
+    defer headerKey.Release()
+
+    // The payload is bound to its header so the two cannot be mixed and matched
+    payload, err := suite.seal(payloadKey.Bytes(), msg, headerData)
+    if err != nil {
+        return nil, ErrEncryptionFailed
+    }
+
+    sealedHeader, err := SuiteAES256GCM.seal(headerKey.Bytes(), headerData, nil)
+    if err != nil {
+        return nil, ErrEncryptionFailed
+    }
+
+    data := append(params.marshal(true), sealedHeader...)
+    return e.embed(ctx, append(data, payload...))
+}
+
+// stealthHeader is a decrypted stealth header, with what it takes to open the
+// payload behind it
+type stealthHeader struct {
+    header Header
+    suite  CipherSuite
+    kdf    *kdfParams // nil for images that predate them
+}
+
+// payloadOffset returns where the payload starts in the embedded data
+func (s stealthHeader) payloadOffset() int {
+    if s.kdf == nil {
+        return StealthHeaderSize
+    }
+    return stealthProbeSize
+}
+
+// payloadKey derives the key the payload was sealed with
+func (s stealthHeader) payloadKey(password string) (*SecretBuffer, error) {
+    if s.kdf == nil {
+        return deriveKey(password, stealthPayloadLabel), nil
+    }
+    return deriveStretchedKey(password, stealthPayloadLabel, *s.kdf)
+}
+
+// openStealth extracts and decrypts the payload behind a decrypted stealth header
+func (d *Decoder) openStealth(ctx context.Context, s stealthHeader) (*Payload, error) {
+    header, suite := s.header, s.suite
+    if err := checkPayloadLen(d.img, header, s.payloadOffset()); err != nil {
+        return nil, err
+    }
+
+    data, err := extractPayload(ctx, d.img, header.Mode, int(header.PayloadLen), s.payloadOffset(), d.opts.progress)
+    if err != nil {
+        return nil, err
+    }
+
+    headerData := append(MarshalHeader(header), byte(suite))
+    payloadKey, err := s.payloadKey(d.opts.password)
+    if err != nil {
+        return nil, err
+    }
+    defer payloadKey.Release()
+
+    msg, err := suite.open(payloadKey.Bytes(), data, headerData)
+    if err != nil {
+        return nil, err
+    }
+
+    return &Payload{Header: header, Suite: suite, Data: msg}, nil
+}
+
+// GetStealthInfo decrypts the stealth header of an image and reports the payload
+// cipher suite. The mode is found by trying every mode until the header
+// authenticates under the password.
+func GetStealthInfo(img image.Image, password string) (Header, CipherSuite, error) {
+    s, err := probeStealthHeader(img, probeImage(img, stealthProbeSize), password, DefaultKDFCost)
+    if err != nil {
+        return Header{}, 0, err
+    }
+    return s.header, s.suite, nil
+}
+
+// probeStealthHeader is GetStealthInfo reading from a probe made by probeImage.
+// Costs above maxCost are not tried, which bounds the work an image can make it
+// do to one scrypt run at maxCost per mode.
+func probeStealthHeader(img, probe image.Image, password string, maxCost int) (stealthHeader, error) {
+    if password == "" {
+        return stealthHeader{}, ErrNoStealthPayload
+    }
+
+    // Older images are cheap to rule out, so they are tried first
+    legacyKey := deriveKey(password, stealthHeaderLabel)
+    defer legacyKey.Release()
+    for _, e := range Embedders() {
+        src := probeFor(e, img, probe)
+        if e.Capacity(src) < StealthHeaderSize {
+            continue
+        }
+        sealed, err := e.Extract(src, StealthHeaderSize, 0)
+        if err != nil {
+            continue
+        }
+        if header, suite, ok := openStealthHeader(legacyKey, sealed, e.ID()); ok {
+            return stealthHeader{header: header, suite: suite}, nil
+        }
+    }
+
+    // Each mode reads a different salt, so the password is stretched once per mode
+    for _, e := range Embedders() {
+        src := probeFor(e, img, probe)
+        if e.Capacity(src) < stealthProbeSize {
+            continue
+        }
+        data, err := e.Extract(src, stealthProbeSize, 0)
+        if err != nil {
+            continue
+        }
+        // Most clean images fail here, on a cost no encoder would write
+        params, err := parseKDFParams(data, true, maxCost)
+        if err != nil {
+            continue
+        }
+        key, err := deriveStretchedKey(password, stealthHeaderLabel, params)
+        if err != nil {
+            continue
+        }
+        header, suite, ok := openStealthHeader(key, data[kdfParamsSize:], e.ID())
+        key.Release()
+        if ok {
+            return stealthHeader{header: header, suite: suite, kdf: &params}, nil
+        }
+    }
+
+    return stealthHeader{}, ErrNoStealthPayload
+}
+
+// openStealthHeader decrypts a sealed stealth header read in the given mode
+func openStealthHeader(key *SecretBuffer, sealed []byte, mode StegMode) (Header, CipherSuite, bool) {
+    headerData, err := SuiteAES256GCM.open(key.Bytes(), sealed, nil)
+    if err != nil || len(headerData) != 9 {
+        return Header{}, 0, false
+    }
+
+    header, err := UnmarshalHeader(headerData[:8])
+    if err != nil || header.Mode != mode {
+        return Header{}, 0, false
+    }
+    return header, CipherSuite(headerData[8]), true
+}
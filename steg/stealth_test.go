@@ -0,0 +1,48 @@
+package steg
+
+import (
+    "bytes"
+    "errors"
+    "testing"
+)
+
+func TestStealthLeavesNoPlaintext(t *testing.T) {
+    msg := []byte("nothing to see here")
+    img, err := NewEncoder(kdfTestCover(), WithPassword(kdfTestPassword), WithKDFCost(MinKDFCost), WithStealth()).EncodeBytes(msg)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    p, err := NewDecoder(img, WithPassword(kdfTestPassword)).Decode()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(p.Data, msg) || !p.Header.IsStealth() {
+        t.Fatalf("got %q, stealth %v", p.Data, p.Header.IsStealth())
+    }
+
+    // Neither the header nor the message is stored in the clear
+    stored, err := extractData(img, LSB1, stealthProbeSize+int(p.Header.PayloadLen), 0)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if bytes.Contains(stored, MarshalHeader(p.Header)) || bytes.Contains(stored, msg) {
+        t.Error("the stored data shows the header or the message")
+    }
+    if _, err := NewDecoder(img).Decode(); err == nil {
+        t.Error("decoded without a password")
+    }
+}
+
+func TestStealthWrongPassword(t *testing.T) {
+    img, err := NewEncoder(kdfTestCover(), WithPassword(kdfTestPassword), WithKDFCost(MinKDFCost), WithStealth()).EncodeBytes([]byte("x"))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err := NewDecoder(img, WithPassword("wrong"), WithStealth()).Decode(); !errors.Is(err, ErrNoStealthPayload) {
+        t.Errorf("got %v, want %v", err, ErrNoStealthPayload)
+    }
+    if _, _, err := GetStealthInfo(img, "wrong"); !errors.Is(err, ErrNoStealthPayload) {
+        t.Errorf("GetStealthInfo: got %v, want %v", err, ErrNoStealthPayload)
+    }
+}
@@ -1,36 +1,21 @@
 package steg
 
 import (
-    "crypto/aes"
-    "crypto/cipher"
-    "crypto/rand"
+    "context"
     "crypto/sha256"
+    "fmt"
     "image"
-    "image/color"
-    "io"
 )
 
 // Capacity checks if the image can store the payload using the given mode
 func Capacity(img image.Image, dataSize int, mode StegMode) (bool, int, int) {
-    bounds := img.Bounds()
-    pixelCount := bounds.Dx() * bounds.Dy()
-    
-    // Calculate bits per pixel for the mode
-    bitsPerPixel := mode.CapacityFactor()
-    
-    // Total capacity in bits
-    totalBits := pixelCount * bitsPerPixel
-    
-    // Header size in bits (8 bytes * 8 bits)
-    headerBits := 8 * 8
-    
-    // Available bits for payload
-    availableBits := totalBits - headerBits
-    
-    // Required bits for payload (dataSize in bytes * 8 bits)
-    requiredBits := dataSize * 8
+    // Available bytes for payload once the header is stored
+    available := rawCapacity(img, mode) - headerSize
+    if available < 0 {
+        available = 0
+    }
     
-    return availableBits >= requiredBits, availableBits / 8, requiredBits / 8
+    return available >= dataSize, available, dataSize
 }
 
 // EncodeMessage embeds a message into an image
@@ -38,575 +23,442 @@ func EncodeMessage(img image.Image, msg []byte, mode StegMode) (image.Image, err
     return EncodeMessageWithPassword(img, msg, "", mode, false)
 }
 
+// IsStegImage reports whether any registered mode finds a Mosquito header in the image
 func IsStegImage(img image.Image) bool {
-    // Try all modes for header detection
-    for _, mode := range []StegMode{LSB1, LSB3, LSB4, LSB8} {
-        var headerData []byte
-        switch mode {
-        case LSB1:
-            headerData = decodeLSB1(img, 8, 0)
-        case LSB3:
-            headerData = decodeLSB3(img, 8, 0)
-        case LSB4:
-            headerData = decodeLSB4(img, 8, 0)
-        case LSB8:
-            headerData = decodeLSB8(img, 8, 0)
-        }
-        
-        if len(headerData) >= 8 && headerData[0] == MagicByte {
-            return true
-        }
-    }
-    return false
+    _, err := findHeader(img)
+    return err == nil
+}
+
+// EncodeMessageWithFlags embeds an already prepared payload with the given header
+// flags, for payloads whose protection is handled outside this package
+func EncodeMessageWithFlags(img image.Image, payload []byte, mode StegMode, flags MessageFlags) (image.Image, error) {
+    return NewEncoder(img, WithMode(mode), WithFlags(flags)).EncodeBytes(payload)
 }
 
 // EncodeMessageWithPassword embeds an encrypted message into an image
 func EncodeMessageWithPassword(img image.Image, msg []byte, password string, mode StegMode, isImage bool) (image.Image, error) {
-    bounds := img.Bounds()
-    
-    // Check if the image has enough capacity
-    hasCapacity, _, _ := Capacity(img, len(msg), mode)
-    if !hasCapacity {
-        return nil, ErrImageTooSmall
-    }
-    
-    // Create the output image
-    out := image.NewRGBA(bounds)
-    for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-        for x := bounds.Min.X; x < bounds.Max.X; x++ {
-            r, g, b, a := img.At(x, y).RGBA()
-            out.Set(x, y, color.RGBA{uint8(r >> 8), uint8(g >> 8), uint8(b >> 8), uint8(a >> 8)})
-        }
-    }
-    
-    // Set up the header
-    header := Header{
-        Magic:     MagicByte,
-        Version:   Version,
-        Mode:      mode,
-        Flags:     0,
-        PayloadLen: uint32(len(msg)),
-    }
-    
-    // Set image flag if payload is an image
-    if isImage {
-        header.Flags |= FlagImage
-    }
-    
-    // Encrypt the payload if a password is provided
-    finalMsg := msg
-    if password != "" {
-        encryptedMsg, err := encrypt(msg, password)
-        if err != nil {
-            return nil, err
-        }
-        finalMsg = encryptedMsg
-        header.Flags |= FlagEncrypted
-        header.PayloadLen = uint32(len(encryptedMsg))
-    }
-    
-    // Encode the header and payload
-    headerData := MarshalHeader(header)
-    data := append(headerData, finalMsg...)
-    
-    // Encode the data using the specified mode
-    switch mode {
-    case LSB1:
-        encodeLSB1(out, data)
-    case LSB3:
-        encodeLSB3(out, data)
-    case LSB4:
-        encodeLSB4(out, data)
-    case LSB8:
-        encodeLSB8(out, data)
-    default:
-        return nil, ErrUnsupportedMode
-    }
-    
-    return out, nil
+    return EncodeMessageWithCipher(img, msg, password, mode, isImage, DefaultCipherSuite)
 }
 
-
+// EncodeMessageWithCipher embeds a message into an image, encrypting it with the
+// given cipher suite when a password is provided
+func EncodeMessageWithCipher(img image.Image, msg []byte, password string, mode StegMode, isImage bool, suite CipherSuite) (image.Image, error) {
+    return NewEncoder(img,
+        WithMode(mode),
+        WithPassword(password),
+        WithCipher(suite),
+        WithFlags(imageFlag(isImage)),
+    ).EncodeBytes(msg)
+}
 
 // DecodeMessage extracts a message from an image
 func DecodeMessage(img image.Image) ([]byte, error) {
     return DecodeMessageWithPassword(img, "")
 }
 
-// DecodeMessageWithPassword extracts and decrypts a message from an image
-// DecodeMessageWithPassword extracts and decrypts a message from an image
+// DecodeMessageWithPassword extracts and decrypts a message from an image. The
+// password also verifies the tag of authenticated payloads, with the unsalted
+// key WithHMACKey describes rather than the stretched one used for decryption.
 func DecodeMessageWithPassword(img image.Image, password string) ([]byte, error) {
-    // Try to extract the header using all possible modes
-    var header Header
-    // var err error
-    var headerFound bool
-    
-    for _, mode := range []StegMode{LSB1, LSB3, LSB4, LSB8} {
-        var headerData []byte
-        switch mode {
-        case LSB1:
-            headerData = decodeLSB1(img, 8, 0)
-        case LSB3:
-            headerData = decodeLSB3(img, 8, 0)
-        case LSB4:
-            headerData = decodeLSB4(img, 8, 0)
-        case LSB8:
-            headerData = decodeLSB8(img, 8, 0)
-        }
-        
-        if len(headerData) >= 8 && headerData[0] == MagicByte {
-            tmpHeader, tmpErr := UnmarshalHeader(headerData)
-            if tmpErr == nil {
-                header = tmpHeader
-                headerFound = true
-                break
-            }
-        }
-    }
-    
-    if !headerFound {
-        return nil, ErrInvalidHeader
-    }
-    
-    // Extract data based on the mode in the header
-    var data []byte
-    switch header.Mode {
-    case LSB1:
-        data = decodeLSB1(img, int(header.PayloadLen), 8) // Skip 8 bytes of header
-    case LSB3:
-        data = decodeLSB3(img, int(header.PayloadLen), 8)
-    case LSB4:
-        data = decodeLSB4(img, int(header.PayloadLen), 8)
-    case LSB8:
-        data = decodeLSB8(img, int(header.PayloadLen), 8)
-    default:
-        return nil, ErrUnsupportedMode
-    }
-    
-    // Decrypt the data if it's encrypted
-    if header.IsEncrypted() {
-        if password == "" {
-            return nil, ErrDecryptionFailed
-        }
-        
-        decrypted, err := decrypt(data, password)
-        if err != nil {
-            return nil, err
-        }
-        return decrypted, nil
+    p, err := NewDecoder(img, WithPassword(password), WithHMACKey(password)).Decode()
+    if err != nil {
+        return nil, err
     }
-    
-    return data, nil
+    return p.Data, nil
 }
+
 // GetImageInfo extracts information about a steganographic image
 func GetImageInfo(img image.Image) (Header, error) {
-    // Try all modes for header detection
-    for _, mode := range []StegMode{LSB1, LSB3, LSB4, LSB8} {
-        var headerData []byte
-        switch mode {
-        case LSB1:
-            headerData = decodeLSB1(img, 8, 0)
-        case LSB3:
-            headerData = decodeLSB3(img, 8, 0)
-        case LSB4:
-            headerData = decodeLSB4(img, 8, 0)
-        case LSB8:
-            headerData = decodeLSB8(img, 8, 0)
+    return findHeader(img)
+}
+
+// findHeader tries every registered mode and returns the first valid header
+func findHeader(img image.Image) (Header, error) {
+    return probeHeader(img, probeImage(img, headerSize))
+}
+
+// probeHeader looks for a plaintext header, reading the built-in modes from a
+// probe made by probeImage so the pixels are only converted once
+func probeHeader(img, probe image.Image) (Header, error) {
+    for _, e := range Embedders() {
+        headerData, err := e.Extract(probeFor(e, img, probe), headerSize, 0)
+        if err != nil || headerData[0] != MagicByte {
+            continue
         }
         
-        if len(headerData) >= 8 && headerData[0] == MagicByte {
-            header, err := UnmarshalHeader(headerData)
-            if err == nil {
-                return header, nil
-            }
+        // A header written by another mode would not decode to its own mode
+        header, err := UnmarshalHeader(headerData)
+        if err == nil && header.Mode == e.ID() {
+            return header, nil
         }
     }
     
-    return Header{}, ErrInvalidHeader
+    return Header{}, &HeaderError{
+        Offset: 0,
+        Reason: "no registered mode found a Mosquito header",
+        Err:    ErrInvalidMagic,
+    }
 }
-// ========================= LSB Encoding Functions =========================
 
-// encodeLSB1 embeds data using LSB of the red channel only
-func encodeLSB1(img *image.RGBA, data []byte) {
-    bounds := img.Bounds()
-    bitIndex := 0
-    totalBits := len(data) * 8
-    
-    for y := bounds.Min.Y; y < bounds.Max.Y && bitIndex < totalBits; y++ {
-        for x := bounds.Min.X; x < bounds.Max.X && bitIndex < totalBits; x++ {
-            byteIndex := bitIndex / 8
-            bitPos := 7 - (bitIndex % 8)
-            bit := (data[byteIndex] >> bitPos) & 1
-            
-            idx := (y-bounds.Min.Y)*img.Stride + (x-bounds.Min.X)*4
-            
-            // Modify only the least significant bit of the red channel
-            img.Pix[idx] = (img.Pix[idx] & 0xFE) | bit
-            
-            bitIndex++
+// checkPayloadLen returns a HeaderError if the payload length in a header points
+// past the end of the image, which means the header is damaged
+func checkPayloadLen(img image.Image, header Header, headerLen int) error {
+    available := max(rawCapacity(img, header.Mode)-headerLen, 0)
+    if int64(header.PayloadLen) > int64(available) {
+        return &HeaderError{
+            Offset: 4,
+            Reason: fmt.Sprintf("payload length %d exceeds the %d bytes the image holds", header.PayloadLen, available),
+            Err:    ErrMessageCorrupted,
         }
     }
+    return nil
 }
 
-// encodeLSB3 embeds data using LSB of RGB channels
-func encodeLSB3(img *image.RGBA, data []byte) {
-    bounds := img.Bounds()
-    bitIndex := 0
-    totalBits := len(data) * 8
-    
-    for y := bounds.Min.Y; y < bounds.Max.Y && bitIndex < totalBits; y++ {
-        for x := bounds.Min.X; x < bounds.Max.X && bitIndex < totalBits; x++ {
-            idx := (y-bounds.Min.Y)*img.Stride + (x-bounds.Min.X)*4
-            
-            // Modify R, G, B channels (3 bits per pixel)
-            for c := 0; c < 3 && bitIndex < totalBits; c++ {
-                byteIndex := bitIndex / 8
-                bitPos := 7 - (bitIndex % 8)
-                bit := (data[byteIndex] >> bitPos) & 1
-                
-                img.Pix[idx+c] = (img.Pix[idx+c] & 0xFE) | bit
-                
-                bitIndex++
-            }
-        }
+// probeFor returns the probe for the built-in modes, which read pixels in raster
+// order from the first one, and the full image for any other embedder
+func probeFor(e Embedder, img, probe image.Image) image.Image {
+    if _, ok := e.(lsbEmbedder); ok {
+        return probe
     }
+    return img
 }
 
-// encodeLSB4 embeds data using 2 LSBs of R and G channels
-func encodeLSB4(img *image.RGBA, data []byte) {
-    bounds := img.Bounds()
-    bitIndex := 0
-    totalBits := len(data) * 8
+// GetCipherSuite reports which cipher suite protects an encrypted payload.
+// Stealth images keep their suite in the sealed header, see GetStealthInfo.
+func GetCipherSuite(img image.Image, header Header) (CipherSuite, error) {
+    if !header.IsEncrypted() || header.IsStealth() {
+        return 0, ErrUnsupportedCipher
+    }
+    if header.Version <= legacyCipherVersion {
+        return SuiteAES256GCM, nil
+    }
     
-    for y := bounds.Min.Y; y < bounds.Max.Y && bitIndex < totalBits; y++ {
-        for x := bounds.Min.X; x < bounds.Max.X && bitIndex < totalBits; x++ {
-            idx := (y-bounds.Min.Y)*img.Stride + (x-bounds.Min.X)*4
-            
-            // Modify 2 LSB of R (2 bits)
-            for i := 0; i < 2 && bitIndex < totalBits; i++ {
-                byteIndex := bitIndex / 8
-                bitPos := 7 - (bitIndex % 8)
-                bit := (data[byteIndex] >> bitPos) & 1
-                
-                img.Pix[idx] = (img.Pix[idx] & (0xFF - (1 << i))) | (bit << i)
-                
-                bitIndex++
-            }
-            
-            // Modify 2 LSB of G (2 bits)
-            for i := 0; i < 2 && bitIndex < totalBits; i++ {
-                byteIndex := bitIndex / 8
-                bitPos := 7 - (bitIndex % 8)
-                bit := (data[byteIndex] >> bitPos) & 1
-                
-                img.Pix[idx+1] = (img.Pix[idx+1] & (0xFF - (1 << i))) | (bit << i)
-                
-                bitIndex++
-            }
-        }
+    // The suite byte follows the key derivation parameters
+    offset := header.Size()
+    if header.Version > legacyKDFVersion {
+        offset += kdfParamsSize
+    }
+    data, err := extractData(img, header.Mode, 1, offset)
+    if err != nil {
+        return 0, err
     }
+    return CipherSuite(data[0]), nil
 }
 
-// encodeLSB8 embeds data using 2 LSBs of all RGBA channels
-func encodeLSB8(img *image.RGBA, data []byte) {
-    bounds := img.Bounds()
-    bitIndex := 0
-    totalBits := len(data) * 8
+// embedData writes data into img using the given mode, starting at the first pixel
+func embedData(ctx context.Context, img *image.NRGBA, data []byte, mode StegMode, progress ProgressFunc) error {
+    e, err := Lookup(mode)
+    if err != nil {
+        return err
+    }
+    if ce, ok := e.(ContextEmbedder); ok {
+        return ce.EmbedContext(ctx, img, data, progress)
+    }
     
-    for y := bounds.Min.Y; y < bounds.Max.Y && bitIndex < totalBits; y++ {
-        for x := bounds.Min.X; x < bounds.Max.X && bitIndex < totalBits; x++ {
-            idx := (y-bounds.Min.Y)*img.Stride + (x-bounds.Min.X)*4
-            
-            // Modify 2 LSB of R, G, B, A (8 bits per pixel)
-            for c := 0; c < 4 && bitIndex < totalBits; c++ {
-                for i := 0; i < 2 && bitIndex < totalBits; i++ {
-                    byteIndex := bitIndex / 8
-                    bitPos := 7 - (bitIndex % 8)
-                    bit := (data[byteIndex] >> bitPos) & 1
-                    
-                    img.Pix[idx+c] = (img.Pix[idx+c] & (0xFF - (1 << i))) | (bit << i)
-                    
-                    bitIndex++
-                }
-            }
-        }
+    // Embedders without context support can only be checked around the call
+    if err := ctx.Err(); err != nil {
+        return err
+    }
+    if err := e.Embed(img, data); err != nil {
+        return err
+    }
+    if progress != nil {
+        progress(int64(len(data))*8, int64(len(data))*8)
     }
+    return ctx.Err()
 }
 
-// ========================= LSB Decoding Functions =========================
+// extractData reads dataSize bytes from img using the given mode, skipping offset bytes
+func extractData(img image.Image, mode StegMode, dataSize int, offset int) ([]byte, error) {
+    return extractPayload(context.Background(), img, mode, dataSize, offset, nil)
+}
 
-// decodeLSB1 extracts data from LSB of the red channel
-func decodeLSB1(img image.Image, dataSize int, offset int) []byte {
-    bounds := img.Bounds()
-    output := make([]byte, dataSize)
-    bitIndex := 0
-    totalBits := dataSize * 8
-    
-    // Skip offset bits
-    skipBits := offset * 8
-    
-    for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-        for x := bounds.Min.X; x < bounds.Max.X; x++ {
-            if bitIndex < skipBits {
-                bitIndex++
-                continue
-            }
-            
-            if bitIndex-skipBits >= totalBits {
-                return output
-            }
-            
-            r, _, _, _ := img.At(x, y).RGBA()
-            // Fix: add shift to extract the correct bit
-            bit := byte((r >> 8) & 1)
-            
-            outIndex := (bitIndex - skipBits) / 8
-            bitPos := 7 - ((bitIndex - skipBits) % 8)
-            
-            output[outIndex] |= bit << bitPos
-            
-            bitIndex++
-        }
+// extractPayload is extractData for payloads large enough to be worth cancelling
+// and reporting progress on
+func extractPayload(ctx context.Context, img image.Image, mode StegMode, dataSize int, offset int, progress ProgressFunc) ([]byte, error) {
+    e, err := Lookup(mode)
+    if err != nil {
+        return nil, err
+    }
+    if ce, ok := e.(ContextEmbedder); ok {
+        return ce.ExtractContext(ctx, img, dataSize, offset, progress)
     }
     
-    return output
+    if err := ctx.Err(); err != nil {
+        return nil, err
+    }
+    data, err := e.Extract(img, dataSize, offset)
+    if err != nil {
+        return nil, err
+    }
+    if progress != nil {
+        progress(int64(dataSize)*8, int64(dataSize)*8)
+    }
+    return data, ctx.Err()
 }
 
-// decodeLSB3 extracts data from LSB of RGB channels
-func decodeLSB3(img image.Image, dataSize int, offset int) []byte {
-    bounds := img.Bounds()
-    output := make([]byte, dataSize)
-    bitIndex := 0
-    totalBits := dataSize * 8
-    
-    // Skip offset bits
-    skipBits := offset * 8
-    totalSkipAndData := skipBits + totalBits
-    
-    for y := bounds.Min.Y; y < bounds.Max.Y && bitIndex < totalSkipAndData; y++ {
-        for x := bounds.Min.X; x < bounds.Max.X && bitIndex < totalSkipAndData; x++ {
-            r, g, b, _ := img.At(x, y).RGBA()
-            channels := []uint32{r, g, b}
-            
-            for c := 0; c < 3 && bitIndex < totalSkipAndData; c++ {
-                // Skip bits according to offset
-                if bitIndex < skipBits {
-                    bitIndex++
-                    continue
-                }
-                
-                // Extract bit from the channel's LSB
-                bit := byte((channels[c] >> 8) & 1)
-                
-                // Place the bit in the output byte
-                outIndex := (bitIndex - skipBits) / 8
-                bitPos := 7 - ((bitIndex - skipBits) % 8)
-                
-                output[outIndex] |= bit << bitPos
-                
-                bitIndex++
-            }
-        }
+// checkCapacity returns a CapacityError unless payloadLen bytes fit in the image
+// after a header of headerLen bytes
+func checkCapacity(img image.Image, payloadLen, headerLen int, mode StegMode) error {
+    available := max(rawCapacity(img, mode)-headerLen, 0)
+    if payloadLen > available {
+        return &CapacityError{Required: payloadLen, Available: available, Mode: mode}
     }
-    
-    return output
+    return nil
 }
 
-// decodeLSB4 extracts data from 2 LSBs of R and G channels
-func decodeLSB4(img image.Image, dataSize int, offset int) []byte {
-    bounds := img.Bounds()
-    output := make([]byte, dataSize)
-    bitIndex := 0
-    totalBits := dataSize * 8
-    
-    // Skip offset bits
-    skipBits := offset * 8
+// rawCapacity returns the total number of bytes the image can hold in the given mode,
+// without reserving any room for a header
+func rawCapacity(img image.Image, mode StegMode) int {
+    e, err := Lookup(mode)
+    if err != nil {
+        return 0
+    }
+    return e.Capacity(img)
+}
+
+// ========================= LSB Functions =========================
+
+// lsbSlot is one bit of one channel that carries data. Each LSB mode fills a fixed
+// list of slots per pixel, in raster order, most significant data bit first.
+type lsbSlot struct {
+    channel uint8 // 0=R, 1=G, 2=B, 3=A
+    bit     uint8
+}
+
+var (
+    // lsb1Slots uses the LSB of the red channel only
+    lsb1Slots = []lsbSlot{{0, 0}}
+    // lsb3Slots uses the LSB of the RGB channels
+    lsb3Slots = []lsbSlot{{0, 0}, {1, 0}, {2, 0}}
+    // lsb4Slots uses the 2 LSBs of the R and G channels
+    lsb4Slots = []lsbSlot{{0, 0}, {0, 1}, {1, 0}, {1, 1}}
+    // lsb8Slots uses the 2 LSBs of all RGBA channels
+    lsb8Slots = []lsbSlot{{0, 0}, {0, 1}, {1, 0}, {1, 1}, {2, 0}, {2, 1}, {3, 0}, {3, 1}}
+)
+
+// lsbEmbed writes data into an RGBA-layout pixel buffer. Whatever does not fit in
+// width*height pixels is dropped; callers check the capacity first.
+func lsbEmbed(t *tracker, pix []byte, stride, width, height int, slots []lsbSlot, data []byte) error {
+    bpp := len(slots)
+    totalBits := len(data) * 8
+    pixels := min((totalBits+bpp-1)/bpp, width*height)
     
-    for y := bounds.Min.Y; y < bounds.Max.Y && (bitIndex-skipBits) < totalBits; y++ {
-        for x := bounds.Min.X; x < bounds.Max.X && (bitIndex-skipBits) < totalBits; x++ {
-            r, g, _, _ := img.At(x, y).RGBA()
-            
-            // Extract 2 bits from R
-            for i := 0; i < 2 && (bitIndex-skipBits) < totalBits; i++ {
-                if bitIndex < skipBits {
-                    bitIndex++
-                    continue
+    // Every pixel is written by exactly one chunk, so chunks can run in parallel
+    return parallelize(pixels, 1, 1, func(lo, hi int) error {
+        x, y := lo%width, lo/width
+        bitIndex := lo * bpp
+        reported := bitIndex
+        
+        for p := lo; p < hi; p++ {
+            idx := y*stride + x*4
+            px := pix[idx : idx+4 : idx+4]
+            for _, s := range slots {
+                if bitIndex >= totalBits {
+                    break
                 }
-                
-                // Fix: Add shift for proper bit extraction
-                bit := byte((r >> (8 + i)) & 1)
-                
-                outIndex := (bitIndex - skipBits) / 8
-                bitPos := 7 - ((bitIndex - skipBits) % 8)
-                
-                output[outIndex] |= bit << bitPos
-                
+                bit := (data[bitIndex>>3] >> (7 - bitIndex&7)) & 1
+                px[s.channel] = px[s.channel]&^(1<<s.bit) | bit<<s.bit
                 bitIndex++
             }
             
-            // Extract 2 bits from G
-            for i := 0; i < 2 && (bitIndex-skipBits) < totalBits; i++ {
-                if bitIndex < skipBits {
-                    bitIndex++
-                    continue
+            if x++; x == width {
+                x, y = 0, y+1
+            }
+            if (p+1)%progressStep == 0 {
+                if err := t.add(bitIndex - reported); err != nil {
+                    return err
                 }
-                
-                // Fix: Add shift for proper bit extraction
-                bit := byte((g >> (8 + i)) & 1)
-                
-                outIndex := (bitIndex - skipBits) / 8
-                bitPos := 7 - ((bitIndex - skipBits) % 8)
-                
-                output[outIndex] |= bit << bitPos
-                
-                bitIndex++
+                reported = bitIndex
             }
         }
-    }
-    
-    return output
+        return t.add(bitIndex - reported)
+    })
 }
 
-// decodeLSB8 extracts data from 2 LSBs of all RGBA channels
-func decodeLSB8(img image.Image, dataSize int, offset int) []byte {
-    bounds := img.Bounds()
+// lsbExtract reads dataSize bytes from an RGBA-layout pixel buffer, skipping the
+// first offset bytes. Bits beyond width*height pixels read as zero.
+func lsbExtract(t *tracker, pix []byte, stride, width, height int, slots []lsbSlot, dataSize, offset int) ([]byte, error) {
     output := make([]byte, dataSize)
-    bitIndex := 0
-    totalBits := dataSize * 8
-    
-    // Skip offset bits
-    skipBits := offset * 8
+    bpp := len(slots)
+    startBit, endBit := offset*8, (offset+dataSize)*8
+    
+    // Start on a multiple of 8 pixels; every chunk then begins on an output byte
+    // boundary, so no two chunks write to the same byte
+    first := startBit / bpp / 8 * 8
+    last := min((endBit+bpp-1)/bpp, width*height)
+    if last <= first {
+        return output, nil
+    }
     
-    for y := bounds.Min.Y; y < bounds.Max.Y && (bitIndex-skipBits) < totalBits; y++ {
-        for x := bounds.Min.X; x < bounds.Max.X && (bitIndex-skipBits) < totalBits; x++ {
-            r, g, b, a := img.At(x, y).RGBA()
-            channels := []uint32{r, g, b, a}
+    err := parallelize(last-first, 8, 1, func(lo, hi int) error {
+        lo, hi = lo+first, hi+first
+        x, y := lo%width, lo/width
+        
+        // Only the first chunk starts before the data, by less than 8 pixels
+        skip := max(startBit-lo*bpp, 0)
+        remaining := min(hi*bpp, endBit) - max(lo*bpp, startBit)
+        outIndex := max(lo*bpp-startBit, 0) / 8
+        reported := remaining
+        
+        var acc byte
+        accBits := 0
+        for p := lo; remaining > 0; p++ {
+            idx := y*stride + x*4
+            px := pix[idx : idx+4 : idx+4]
+            for _, s := range slots {
+                if skip > 0 {
+                    skip--
+                    continue
+                }
+                if remaining == 0 {
+                    break
+                }
+                acc = acc<<1 | (px[s.channel]>>s.bit)&1
+                remaining--
+                if accBits++; accBits == 8 {
+                    output[outIndex] = acc
+                    outIndex++
+                    acc, accBits = 0, 0
+                }
+            }
             
-            for c := 0; c < 4 && (bitIndex-skipBits) < totalBits; c++ {
-                for i := 0; i < 2 && (bitIndex-skipBits) < totalBits; i++ {
-                    if bitIndex < skipBits {
-                        bitIndex++
-                        continue
-                    }
-                    
-                    // Fix: Add shift for proper bit extraction
-                    bit := byte((channels[c] >> (8 + i)) & 1)
-                    
-                    outIndex := (bitIndex - skipBits) / 8
-                    bitPos := 7 - ((bitIndex - skipBits) % 8)
-                    
-                    output[outIndex] |= bit << bitPos
-                    
-                    bitIndex++
+            if x++; x == width {
+                x, y = 0, y+1
+            }
+            if (p+1)%progressStep == 0 {
+                if err := t.add(reported - remaining); err != nil {
+                    return err
                 }
+                reported = remaining
             }
         }
+        return t.add(reported - remaining)
+    })
+    if err != nil {
+        return nil, err
     }
     
-    return output
+    return output, nil
 }
 
-// ========================= Encryption Functions =========================
+// lsbEmbedRGBA writes data into an RGBA image, for the deprecated EncodeLSB functions
+func lsbEmbedRGBA(img *image.RGBA, slots []lsbSlot, data []byte) {
+    bounds := img.Bounds()
+    t := newTracker(context.Background(), nil, len(data)*8)
+    lsbEmbed(t, img.Pix, img.Stride, bounds.Dx(), bounds.Dy(), slots, data)
+}
 
-// encrypt data with AES-256-GCM
-func encrypt(data []byte, password string) ([]byte, error) {
-    // Create a key from the password
-    key := sha256.Sum256([]byte(password))
-    
-    block, err := aes.NewCipher(key[:])
-    if err != nil {
-        return nil, err
-    }
-    
-    // Create GCM mode
-    gcm, err := cipher.NewGCM(block)
-    if err != nil {
-        return nil, err
-    }
-    
-    // Create a nonce
-    nonce := make([]byte, gcm.NonceSize())
-    if _, err = io.ReadFull(rand.Reader, nonce); err != nil {
-        return nil, err
+// lsbExtractImage reads data from any image type
+func lsbExtractImage(t *tracker, img image.Image, slots []lsbSlot, dataSize, offset int) ([]byte, error) {
+    bounds := img.Bounds()
+    if bounds.Empty() {
+        return make([]byte, dataSize), nil
     }
     
-    // Encrypt
-    ciphertext := gcm.Seal(nonce, nonce, data, nil)
-    
-    return ciphertext, nil
+    pixels := ((offset+dataSize)*8 + len(slots) - 1) / len(slots)
+    pix, stride, rows := rawPixels(img, pixels)
+    return lsbExtract(t, pix, stride, bounds.Dx(), rows, slots, dataSize, offset)
 }
 
-// decrypt data with AES-256-GCM
+// ========================= Encryption Functions =========================
+
+// payloadKeyLabel separates the payload key from other keys derived from the same password
+const payloadKeyLabel = "mosquito payload"
+
+// decrypt data with AES-256-GCM, as written by header versions before cipher suites
 func decrypt(data []byte, password string) ([]byte, error) {
     // Create a key from the password
-    key := sha256.Sum256([]byte(password))
-    
-    block, err := aes.NewCipher(key[:])
-    if err != nil {
-        return nil, err
-    }
-    
-    // Create GCM mode
-    gcm, err := cipher.NewGCM(block)
-    if err != nil {
-        return nil, err
-    }
-    
-    // Extract nonce
-    nonceSize := gcm.NonceSize()
-    if len(data) < nonceSize {
-        return nil, ErrDecryptionFailed
-    }
-    
-    nonce, ciphertext := data[:nonceSize], data[nonceSize:]
+    pw := SecretFromString(password)
+    defer pw.Release()
+    key := sha256.Sum256(pw.Bytes())
+    defer Wipe(key[:])
     
-    // Decrypt
-    plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
-    if err != nil {
-        return nil, ErrDecryptionFailed
-    }
-    
-    return plaintext, nil
+    return SuiteAES256GCM.open(key[:], data, nil)
+}
+
+// deriveKey derives an independent 256-bit key for the given purpose from an
+// unsalted hash of a password, as header versions up to legacyKDFVersion did.
+// HMAC keys are still derived this way: authenticated payloads have no room for
+// a salt, and stretching the key would fail every tag written so far. They are
+// meant for random shared secrets, which need no stretching. The caller must
+// release the returned key once done with it.
+func deriveKey(password, label string) *SecretBuffer {
+    pw := SecretFromString(password)
+    defer pw.Release()
+    base := sha256.Sum256(pw.Bytes())
+    hashed := SecretFromBytes(base[:])
+    defer hashed.Release()
+
+    return labelKey(hashed, label)
 }
 
 // Public versions of the encoding/decoding functions
 
-// EncodeLSB1 is the public version of encodeLSB1
+// EncodeLSB1 embeds data into an image using mode LSB1
+//
+// Deprecated: it writes no header. Use NewEncoder and NewDecoder, or Lookup for
+// raw access to a mode.
 func EncodeLSB1(img *image.RGBA, data []byte) {
-    encodeLSB1(img, data)
+    lsbEmbedRGBA(img, lsb1Slots, data)
 }
 
-// EncodeLSB3 is the public version of encodeLSB3
+// EncodeLSB3 embeds data into an image using mode LSB3
+//
+// Deprecated: it writes no header. Use NewEncoder and NewDecoder, or Lookup for
+// raw access to a mode.
 func EncodeLSB3(img *image.RGBA, data []byte) {
-    encodeLSB3(img, data)
+    lsbEmbedRGBA(img, lsb3Slots, data)
 }
 
-// EncodeLSB4 is the public version of encodeLSB4
+// EncodeLSB4 embeds data into an image using mode LSB4
+//
+// Deprecated: it writes no header. Use NewEncoder and NewDecoder, or Lookup for
+// raw access to a mode.
 func EncodeLSB4(img *image.RGBA, data []byte) {
-    encodeLSB4(img, data)
+    lsbEmbedRGBA(img, lsb4Slots, data)
 }
 
-// EncodeLSB8 is the public version of encodeLSB8
+// EncodeLSB8 embeds data into an image using mode LSB8
+//
+// Deprecated: it writes no header. Use NewEncoder and NewDecoder, or Lookup for
+// raw access to a mode.
 func EncodeLSB8(img *image.RGBA, data []byte) {
-    encodeLSB8(img, data)
+    lsbEmbedRGBA(img, lsb8Slots, data)
 }
 
-// DecodeLSB1 is the public version of decodeLSB1
+// DecodeLSB1 extracts data from an image using mode LSB1
+//
+// Deprecated: it reads no header. Use NewEncoder and NewDecoder, or Lookup for
+// raw access to a mode.
 func DecodeLSB1(img image.Image, dataSize int, offset int) []byte {
-    return decodeLSB1(img, dataSize, offset)
+    data, _ := lsbExtractImage(newTracker(context.Background(), nil, dataSize*8), img, lsb1Slots, dataSize, offset)
+    return data
 }
 
-// DecodeLSB3 is the public version of decodeLSB3
+// DecodeLSB3 extracts data from an image using mode LSB3
+//
+// Deprecated: it reads no header. Use NewEncoder and NewDecoder, or Lookup for
+// raw access to a mode.
 func DecodeLSB3(img image.Image, dataSize int, offset int) []byte {
-    return decodeLSB3(img, dataSize, offset)
+    data, _ := lsbExtractImage(newTracker(context.Background(), nil, dataSize*8), img, lsb3Slots, dataSize, offset)
+    return data
 }
 
-// DecodeLSB4 is the public version of decodeLSB4
+// DecodeLSB4 extracts data from an image using mode LSB4
+//
+// Deprecated: it reads no header. Use NewEncoder and NewDecoder, or Lookup for
+// raw access to a mode.
 func DecodeLSB4(img image.Image, dataSize int, offset int) []byte {
-    return decodeLSB4(img, dataSize, offset)
+    data, _ := lsbExtractImage(newTracker(context.Background(), nil, dataSize*8), img, lsb4Slots, dataSize, offset)
+    return data
 }
 
-// DecodeLSB8 is the public version of decodeLSB8
+// DecodeLSB8 extracts data from an image using mode LSB8
+//
+// Deprecated: it reads no header. Use NewEncoder and NewDecoder, or Lookup for
+// raw access to a mode.
 func DecodeLSB8(img image.Image, dataSize int, offset int) []byte {
-    return decodeLSB8(img, dataSize, offset)
+    data, _ := lsbExtractImage(newTracker(context.Background(), nil, dataSize*8), img, lsb8Slots, dataSize, offset)
+    return data
 }
\ No newline at end of file
@@ -0,0 +1,139 @@
+package steg
+
+import (
+    "bytes"
+    "errors"
+    "image"
+    "image/color"
+    "image/draw"
+    "math/rand"
+    "testing"
+)
+
+// benchSize is the side of the square covers the benchmarks embed into
+const benchSize = 2048
+
+// genericImage hides the concrete type of the image it wraps, so embedding has
+// to go through At rather than one of the fast paths in pixels.go
+type genericImage struct {
+    image.Image
+}
+
+// noisyNRGBA returns an opaque cover filled with noise from a fixed seed
+func noisyNRGBA(width, height int) *image.NRGBA {
+    img := image.NewNRGBA(image.Rect(0, 0, width, height))
+    rand.New(rand.NewSource(1)).Read(img.Pix)
+    for i := 3; i < len(img.Pix); i += 4 {
+        img.Pix[i] = 0xFF
+    }
+    return img
+}
+
+func toRGBA(img image.Image) *image.RGBA {
+    out := image.NewRGBA(img.Bounds())
+    draw.Draw(out, out.Bounds(), img, img.Bounds().Min, draw.Src)
+    return out
+}
+
+func toYCbCr(img image.Image) *image.YCbCr {
+    b := img.Bounds()
+    out := image.NewYCbCr(b, image.YCbCrSubsampleRatio420)
+    for y := b.Min.Y; y < b.Max.Y; y++ {
+        for x := b.Min.X; x < b.Max.X; x++ {
+            r, g, bl, _ := img.At(x, y).RGBA()
+            yy, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(bl>>8))
+            out.Y[out.YOffset(x, y)] = yy
+            ci := out.COffset(x, y)
+            out.Cb[ci], out.Cr[ci] = cb, cr
+        }
+    }
+    return out
+}
+
+// CheckCapacity must agree with encoding on the largest payload that fits, for
+// every kind of protection
+func TestCheckCapacity(t *testing.T) {
+    cover := noisyNRGBA(32, 32)
+    for name, opts := range map[string][]Option{
+        "plain":    nil,
+        "typed":    {WithContentType("text/plain")},
+        "hmac":     {WithHMACKey("secret")},
+        "password": {WithPassword("secret"), WithKDFCost(MinKDFCost)},
+        "stealth":  {WithPassword("secret"), WithKDFCost(MinKDFCost), WithStealth()},
+    } {
+        enc := NewEncoder(cover, opts...)
+        n := 0
+        for enc.CheckCapacity(n+1) == nil {
+            n++
+        }
+        if _, err := enc.EncodeBytes(make([]byte, n)); err != nil {
+            t.Errorf("%s: %d bytes should fit: %v", name, n, err)
+        }
+        if _, err := enc.EncodeBytes(make([]byte, n+1)); !errors.Is(err, ErrImageTooSmall) {
+            t.Errorf("%s: %d bytes should not fit, got %v", name, n+1, err)
+        }
+    }
+}
+
+// benchMessage fills most of what LSB3 can hold in a benchSize cover
+func benchMessage() []byte {
+    msg := make([]byte, benchSize*benchSize*3/8-headerSize-1024)
+    rand.New(rand.NewSource(2)).Read(msg)
+    return msg
+}
+
+func BenchmarkEncode(b *testing.B) {
+    cover := noisyNRGBA(benchSize, benchSize)
+    msg := benchMessage()
+    for _, bc := range []struct {
+        name  string
+        cover image.Image
+    }{
+        {"RGBA", toRGBA(cover)},
+        {"NRGBA", cover},
+        {"YCbCr", toYCbCr(cover)},
+        {"Generic", genericImage{cover}},
+    } {
+        b.Run(bc.name, func(b *testing.B) {
+            b.SetBytes(int64(len(msg)))
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                if _, err := EncodeMessage(bc.cover, msg, LSB3); err != nil {
+                    b.Fatal(err)
+                }
+            }
+        })
+    }
+}
+
+// A YCbCr image cannot carry a message, the conversion loses the low bits, so
+// decoding is only measured on the lossless types
+func BenchmarkDecode(b *testing.B) {
+    msg := benchMessage()
+    encoded, err := EncodeMessage(noisyNRGBA(benchSize, benchSize), msg, LSB3)
+    if err != nil {
+        b.Fatal(err)
+    }
+    for _, bc := range []struct {
+        name  string
+        cover image.Image
+    }{
+        {"RGBA", toRGBA(encoded)},
+        {"NRGBA", encoded},
+        {"Generic", genericImage{encoded}},
+    } {
+        b.Run(bc.name, func(b *testing.B) {
+            b.SetBytes(int64(len(msg)))
+            b.ReportAllocs()
+            for i := 0; i < b.N; i++ {
+                got, err := DecodeMessage(bc.cover)
+                if err != nil {
+                    b.Fatal(err)
+                }
+                if !bytes.Equal(got, msg) {
+                    b.Fatal("decoded message differs")
+                }
+            }
+        })
+    }
+}
@@ -1,9 +1,11 @@
 package steg
 
 import (
+    "context"
+    "fmt"
     "image"
     "image/color"
-    "fmt"
+    "io"
     "os"
     "path/filepath"
     "strings"
@@ -12,8 +14,8 @@ import (
     "image/gif"
     "image/jpeg"
     "image/png"
-    _ "golang.org/x/image/bmp"
-    _ "golang.org/x/image/tiff"
+    "golang.org/x/image/bmp"
+    "golang.org/x/image/tiff"
     _ "golang.org/x/image/webp"
 )
 
@@ -30,7 +32,12 @@ func LoadImage(path string) (image.Image, error) {
     }
     defer f.Close()
     
-    img, _, err := image.Decode(f)
+    return ReadImage(f)
+}
+
+// ReadImage decodes an image in any of the supported formats from a reader
+func ReadImage(r io.Reader) (image.Image, error) {
+    img, _, err := image.Decode(r)
     if err != nil {
         return nil, err
     }
@@ -38,26 +45,96 @@ func LoadImage(path string) (image.Image, error) {
     return img, nil
 }
 
+// WriteImage encodes an image to a writer in the given format. Only lossless
+// formats are accepted (png, bmp, tiff), since anything else would destroy the
+// hidden bits.
+func WriteImage(w io.Writer, img image.Image, format string) error {
+    switch strings.ToLower(format) {
+    case "png":
+        return png.Encode(w, img)
+    case "bmp":
+        return bmp.Encode(w, img)
+    case "tiff", "tif":
+        return tiff.Encode(w, img, &tiff.Options{Compression: tiff.Deflate})
+    default:
+        return fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+    }
+}
+
+// CheckFormat returns an error unless WriteImage supports format
+func CheckFormat(format string) error {
+    switch strings.ToLower(format) {
+    case "png", "bmp", "tiff", "tif":
+        return nil
+    }
+    return fmt.Errorf("%w: %q", ErrUnsupportedFormat, format)
+}
+
 // SaveImage saves an image to a file with appropriate format based on extension
 func SaveImage(img image.Image, path string) error {
-    f, err := os.Create(path)
+    return SaveImageContext(context.Background(), img, path)
+}
+
+// SaveImageContext saves an image like SaveImage. The image is written to a
+// temporary file that replaces path only once it is complete, so a failed or
+// cancelled save never leaves a half-written file behind.
+func SaveImageContext(ctx context.Context, img image.Image, path string) error {
+    return SaveImageAs(ctx, img, path, "")
+}
+
+// SaveImageAs saves an image like SaveImageContext, in the given format instead of
+// the one matching the file extension. An empty format falls back to the
+// extension. See WriteImage for the supported formats.
+func SaveImageAs(ctx context.Context, img image.Image, path, format string) (err error) {
+    if format != "" {
+        if err := CheckFormat(format); err != nil {
+            return err
+        }
+    }
+
+    f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
     if err != nil {
         return err
     }
-    defer f.Close()
+    defer func() {
+        if err != nil {
+            f.Close()
+            os.Remove(f.Name())
+        }
+    }()
     
+    w := &contextWriter{ctx: ctx, w: f}
+    if format != "" {
+        err = WriteImage(w, img, format)
+    } else {
+        err = encodeForPath(w, img, path)
+    }
+    if err != nil {
+        return err
+    }
+    if err = f.Chmod(0644); err != nil {
+        return err
+    }
+    if err = f.Close(); err != nil {
+        return err
+    }
+    return os.Rename(f.Name(), path)
+}
+
+// encodeForPath encodes an image in the format matching the file extension
+func encodeForPath(w io.Writer, img image.Image, path string) error {
     ext := strings.ToLower(filepath.Ext(path))
     
     switch ext {
     case ".jpg", ".jpeg":
-        return jpeg.Encode(f, img, &jpeg.Options{Quality: 95})
+        return jpeg.Encode(w, img, &jpeg.Options{Quality: 95})
     case ".png":
-        return png.Encode(f, img)
+        return png.Encode(w, img)
     case ".gif":
-        return gif.Encode(f, img, &gif.Options{NumColors: 256})
+        return gif.Encode(w, img, &gif.Options{NumColors: 256})
     default:
         // Default to PNG if extension not recognized
-        return png.Encode(f, img)
+        return png.Encode(w, img)
     }
 }
 
@@ -72,23 +149,8 @@ func ImageInfo(img image.Image) (width, height, maxBits int) {
 
 // CalculateMaxPayloadSize calculates the maximum payload size in bytes for an image
 func CalculateMaxPayloadSize(img image.Image, mode StegMode) int {
-    width, height, _ := ImageInfo(img)
-    pixelCount := width * height
-    
-    // Calculate bits per pixel for the mode
-    bitsPerPixel := mode.CapacityFactor()
-    
-    // Total capacity in bits
-    totalBits := pixelCount * bitsPerPixel
-    
-    // Header size in bits (8 bytes * 8 bits)
-    headerBits := 8 * 8
-    
-    // Available bits for payload
-    availableBits := totalBits - headerBits
-    
-    // Convert to bytes (integer division)
-    return availableBits / 8
+    _, available, _ := Capacity(img, 0, mode)
+    return available
 }
 
 // ConvertToRGBA converts any image to RGBA format
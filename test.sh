@@ -253,16 +253,12 @@ test_encrypted_messages() {
     
     # Test 8: Extract with no password from encrypted (should fail)
     echo "Attempting extraction without password from encrypted image (should fail)..."
-    if ./Mosquito extract -i $TEST_SUBDIR/encrypted_std.png -t > $TEST_SUBDIR/no_pwd_output.txt 2>&1; then
-        # This might succeed with an error message, so check for error
-        if grep -q "Error" $TEST_SUBDIR/no_pwd_output.txt || grep -q "fail" $TEST_SUBDIR/no_pwd_output.txt; then
-            check_result "Extraction without password correctly reports error"
-        else
-            echo -e "${RED}✗ FAILED: Extraction without password should fail${NC}"
-            echo "- ❌ Extraction without password should fail" >> $TEST_REPORT
-        fi
-    else
+    ./Mosquito extract -i $TEST_SUBDIR/encrypted_std.png -t > $TEST_SUBDIR/no_pwd_output.txt 2>&1
+    if [ $? -eq 5 ]; then
         check_result "Extraction without password correctly fails"
+    else
+        echo -e "${RED}✗ FAILED: Extraction without password should exit with status 5${NC}"
+        echo "- ❌ Extraction without password should exit with status 5" >> $TEST_REPORT
     fi
 }
 
@@ -514,17 +510,12 @@ test_edge_cases() {
     
     # Test 3: Extracting from non-steganographic image
     echo "Testing extraction from non-steganographic image..."
-    if ./Mosquito extract -i test-images/250204_18h55m28s_screenshot.png -t > $TEST_SUBDIR/non_stego.log 2>&1; then
-        # This might succeed with an error message
-        if grep -q "does not appear to contain hidden data" $TEST_SUBDIR/non_stego.log; then
-            check_result "Correctly identifies non-steganographic image"
-        else
-            echo -e "${RED}✗ FAILED: Should identify non-steganographic image${NC}"
-            echo "- ❌ Should identify non-steganographic image" >> $TEST_REPORT
-        fi
+    ./Mosquito extract -i test-images/250204_18h55m28s_screenshot.png -t > $TEST_SUBDIR/non_stego.log 2>&1
+    if [ $? -eq 4 ]; then
+        check_result "Correctly identifies non-steganographic image"
     else
-        # This might fail with exit status, which is also acceptable
-        check_result "Correctly fails to extract from non-steganographic image"
+        echo -e "${RED}✗ FAILED: Should identify non-steganographic image (exit status 4)${NC}"
+        echo "- ❌ Should identify non-steganographic image" >> $TEST_REPORT
     fi
     
     # Test 4: Missing required arguments